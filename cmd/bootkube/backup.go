@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kubernetes-sigs/bootkube/pkg/bootkube"
+	"github.com/kubernetes-sigs/bootkube/pkg/recovery"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	backupFilePrefix = "bootkube-backup-"
+	backupFileSuffix = ".tar.gz"
+)
+
+var (
+	cmdBackup = &cobra.Command{
+		Use:          "backup",
+		Short:        "Back up a self-hosted control plane",
+		Long:         "This command takes an etcd snapshot and exports the objects that `bootkube recover` needs into a single archive with a manifest and checksums. The archive can be passed to `bootkube recover --backup-file`, and the snapshot restored with `etcdctl snapshot restore`.",
+		PreRunE:      validateBackupOpts,
+		RunE:         runCmdBackup,
+		SilenceUsage: true,
+	}
+
+	backupOpts struct {
+		backupDir           string
+		keep                int
+		etcdCAPath          string
+		etcdCertificatePath string
+		etcdPrivateKeyPath  string
+		etcdServers         string
+		etcdPrefix          string
+		kubeConfigPath      string
+		inCluster           bool
+		namespaces          []string
+		resources           []string
+	}
+)
+
+func init() {
+	cmdRoot.AddCommand(cmdBackup)
+	cmdBackup.Flags().StringVar(&backupOpts.backupDir, "backup-dir", "", "Directory to write the backup archive to. Archives are named "+backupFilePrefix+"<timestamp>"+backupFileSuffix+".")
+	cmdBackup.Flags().IntVar(&backupOpts.keep, "keep", 0, "Number of backup archives to keep in --backup-dir, older ones are deleted. Keeps all archives if 0.")
+	cmdBackup.Flags().StringVar(&backupOpts.etcdCAPath, "etcd-ca-path", "", "Path to an existing PEM encoded CA that will be used for TLS-enabled communication with etcd.")
+	cmdBackup.Flags().StringVar(&backupOpts.etcdCertificatePath, "etcd-certificate-path", "", "Path to an existing certificate that will be used for TLS-enabled communication with etcd. Must be used in conjunction with --etcd-private-key-path.")
+	cmdBackup.Flags().StringVar(&backupOpts.etcdPrivateKeyPath, "etcd-private-key-path", "", "Path to an existing private key that will be used for TLS-enabled communication with etcd. Must be used in conjunction with --etcd-certificate-path.")
+	cmdBackup.Flags().StringVar(&backupOpts.etcdServers, "etcd-servers", "", "List of etcd server URLs including host:port, comma separated. If set, the backup includes an etcd snapshot.")
+	cmdBackup.Flags().StringVar(&backupOpts.etcdPrefix, "etcd-prefix", "/registry", "Path prefix to Kubernetes cluster data in etcd.")
+	cmdBackup.Flags().StringVar(&backupOpts.kubeConfigPath, "kubeconfig", "", "Path to kubeconfig for reading the control plane from the apiserver when --etcd-servers is not set.")
+	cmdBackup.Flags().BoolVar(&backupOpts.inCluster, "in-cluster", false, "Read the control plane from the apiserver using the service account of the pod that bootkube runs in when --etcd-servers is not set.")
+	cmdBackup.Flags().StringSliceVar(&backupOpts.namespaces, "namespaces", nil, "Namespaces to export objects from, comma separated. Defaults to kube-system.")
+	cmdBackup.Flags().StringSliceVar(&backupOpts.resources, "resources", nil, "Resources to export in addition to configmaps, daemonsets, deployments and secrets, comma separated, in the format [group/]version/resource[=etcd-key]. The etcd key is the path below --etcd-prefix that the objects are stored under, and can also be given for the default resources, e.g. apps/v1/deployments=deployments.")
+}
+
+func runCmdBackup(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	scope, err := parseScope(backupOpts.namespaces, backupOpts.resources)
+	if err != nil {
+		return err
+	}
+
+	var backend recovery.Backend
+	var snapshot io.ReadCloser
+	switch {
+	case backupOpts.etcdServers != "":
+		bootkube.UserOutput("Backing up etcd cluster at %q...\n", backupOpts.etcdServers)
+		etcdClient, err := createEtcdClient(backupOpts.etcdServers, backupOpts.etcdCAPath, backupOpts.etcdCertificatePath, backupOpts.etcdPrivateKeyPath)
+		if err != nil {
+			return err
+		}
+		defer etcdClient.Close()
+		backend = recovery.NewEtcdBackend(etcdClient, backupOpts.etcdPrefix)
+		if snapshot, err = etcdClient.Snapshot(ctx); err != nil {
+			return fmt.Errorf("failed to take etcd snapshot: %v", err)
+		}
+		defer snapshot.Close()
+
+	case backupOpts.inCluster:
+		bootkube.UserOutput("Backing up in-cluster apiserver...\n")
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return err
+		}
+		if backend, err = recovery.NewAPIServerBackendForConfig(config); err != nil {
+			return err
+		}
+
+	default:
+		bootkube.UserOutput("Backing up apiserver at %q...\n", backupOpts.kubeConfigPath)
+		if backend, err = recovery.NewAPIServerBackend(backupOpts.kubeConfigPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(backupOpts.backupDir, 0700); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that an interrupted backup never looks complete.
+	f, err := ioutil.TempFile(backupOpts.backupDir, ".backup")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	// Avoid a nil io.ReadCloser wrapped in a non-nil io.Reader.
+	var r io.Reader
+	if snapshot != nil {
+		r = snapshot
+	}
+	manifest, err := recovery.Backup(ctx, backend, scope, r, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	name := filepath.Join(backupOpts.backupDir, backupFilePrefix+manifest.Created.Format("20060102T150405Z")+backupFileSuffix)
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	bootkube.UserOutput("Wrote backup to %q.\n", name)
+
+	return pruneBackups(backupOpts.backupDir, backupOpts.keep)
+}
+
+// pruneBackups deletes all but the newest keep backup archives in dir. The timestamps in the file
+// names sort chronologically.
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(f.Name(), backupFilePrefix) && strings.HasSuffix(f.Name(), backupFileSuffix) {
+			backups = append(backups, f.Name())
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		bootkube.UserOutput("Deleted old backup %q.\n", backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func validateBackupOpts(cmd *cobra.Command, args []string) error {
+	if backupOpts.backupDir == "" {
+		return errors.New("missing required flag: --backup-dir")
+	}
+	if (backupOpts.etcdCertificatePath != "" || backupOpts.etcdPrivateKeyPath != "") && (backupOpts.etcdCertificatePath == "" || backupOpts.etcdPrivateKeyPath == "") {
+		return errors.New("you must specify both --etcd-certificate-path, and --etcd-private-key-path")
+	}
+	if backupOpts.etcdPrefix == "" {
+		return errors.New("missing required flag: --etcd-prefix")
+	}
+	if backupOpts.etcdServers == "" && backupOpts.kubeConfigPath == "" && !backupOpts.inCluster {
+		return errors.New("missing required flag: --etcd-servers (or --kubeconfig or --in-cluster)")
+	}
+	if backupOpts.keep < 0 {
+		return errors.New("--keep must not be negative")
+	}
+	_, err := parseScope(backupOpts.namespaces, backupOpts.resources)
+	return err
+}
+
+// parseScope returns the recovery.Scope for the --namespaces and --resources flags.
+func parseScope(namespaces, resources []string) (recovery.Scope, error) {
+	scope := recovery.Scope{Namespaces: namespaces}
+	for _, s := range resources {
+		r, err := recovery.ParseResource(s)
+		if err != nil {
+			return recovery.Scope{}, err
+		}
+		scope.Resources = append(scope.Resources, r)
+	}
+	return scope, nil
+}
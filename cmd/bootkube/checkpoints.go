@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kubernetes-sigs/bootkube/pkg/bootkube"
+	"github.com/kubernetes-sigs/bootkube/pkg/checkpoint"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	cmdCheckpoints = &cobra.Command{
+		Use:          "checkpoints",
+		Short:        "Summarise the pod checkpoints of the cluster",
+		Long:         "This command reads the checkpoint statuses that the pod-checkpointer of each node publishes, and prints the checkpoints of every node. It fails if a status was not updated within --stale-after, or if a checkpoint is active, which means that its parent pod is not running on the node.",
+		PreRunE:      validateCheckpointsOpts,
+		RunE:         runCmdCheckpoints,
+		SilenceUsage: true,
+	}
+
+	checkpointsOpts struct {
+		kubeConfigPath string
+		namespace      string
+		staleAfter     time.Duration
+	}
+)
+
+func init() {
+	cmdRoot.AddCommand(cmdCheckpoints)
+	cmdCheckpoints.Flags().StringVar(&checkpointsOpts.kubeConfigPath, "kubeconfig", "", "Path to kubeconfig for communicating with the cluster.")
+	cmdCheckpoints.Flags().StringVar(&checkpointsOpts.namespace, "namespace", "pod-checkpointer-status", "Namespace that the pod-checkpointers publish their statuses in, see their --status-namespace flag.")
+	cmdCheckpoints.Flags().DurationVar(&checkpointsOpts.staleAfter, "stale-after", 5*time.Minute, "Report the status of a node as stale if it was not updated within this duration. Checkpointers update their status at least once a minute while the apiserver is reachable.")
+}
+
+func runCmdCheckpoints(cmd *cobra.Command, args []string) error {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: checkpointsOpts.kubeConfigPath},
+		&clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	statuses, err := checkpoint.ListNodeStatuses(context.Background(), client, checkpointsOpts.namespace)
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		return fmt.Errorf("no checkpointer statuses found in namespace %s", checkpointsOpts.namespace)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tPARENT\tSTATE\tLAST WRITE\tSECRETS\tCONFIGMAPS")
+	var problems []string
+	for _, s := range statuses {
+		for _, cp := range s.Checkpoints {
+			lastWrite := "-"
+			if cp.LastWriteTime != nil {
+				lastWrite = now.Sub(*cp.LastWriteTime).Round(time.Second).String() + " ago"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.NodeName, cp.Parent, cp.State, lastWrite, listOrNone(cp.Secrets), listOrNone(cp.ConfigMaps))
+		}
+		for _, p := range s.Problems(now, checkpointsOpts.staleAfter) {
+			problems = append(problems, fmt.Sprintf("%s: %s", s.NodeName, p))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		bootkube.UserOutput("\nProblems:\n")
+		for _, p := range problems {
+			bootkube.UserOutput("  %s\n", p)
+		}
+		return fmt.Errorf("found %d checkpoint problems", len(problems))
+	}
+	bootkube.UserOutput("\nAll checkpoints of %d nodes are healthy.\n", len(statuses))
+	return nil
+}
+
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, ",")
+}
+
+func validateCheckpointsOpts(cmd *cobra.Command, args []string) error {
+	if checkpointsOpts.kubeConfigPath == "" {
+		return errors.New("missing required flag: --kubeconfig")
+	}
+	if checkpointsOpts.namespace == "" {
+		return errors.New("missing required flag: --namespace")
+	}
+	return nil
+}
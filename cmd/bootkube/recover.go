@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/spf13/cobra"
 	"go.etcd.io/etcd/clientv3"
+	"k8s.io/client-go/rest"
 )
 
 var (
@@ -37,6 +39,19 @@ var (
 		etcdPrefix          string
 		kubeConfigPath      string
 		podManifestPath     string
+		componentsPath      string
+		dryRun              bool
+		diffAssetDir        string
+		apiServerURL        string
+		inCluster           bool
+		tokenFile           string
+		caFile              string
+		backupFile          string
+		namespaces          []string
+		etcdKeys            []string
+		skipValidation      bool
+		node                string
+		allMasters          bool
 	}
 )
 
@@ -48,27 +63,74 @@ func init() {
 	cmdRecover.Flags().StringVar(&recoverOpts.etcdPrivateKeyPath, "etcd-private-key-path", "", "Path to an existing private key that will be used for TLS-enabled communication between the apiserver and etcd. Must be used in conjunction with --etcd-ca-path and --etcd-certificate-path, and must have etcd configured to use TLS with matching secrets.")
 	cmdRecover.Flags().StringVar(&recoverOpts.etcdServers, "etcd-servers", "", "List of etcd server URLs including host:port, comma separated.")
 	cmdRecover.Flags().StringVar(&recoverOpts.etcdPrefix, "etcd-prefix", "/registry", "Path prefix to Kubernetes cluster data in etcd.")
-	cmdRecover.Flags().StringVar(&recoverOpts.kubeConfigPath, "kubeconfig", "", "Path to kubeconfig for communicating with the cluster.")
+	cmdRecover.Flags().StringVar(&recoverOpts.kubeConfigPath, "kubeconfig", "", "Path to kubeconfig for communicating with the cluster. May be omitted in favor of --apiserver-url when recovering from etcd, --in-cluster or --token-file.")
+	cmdRecover.Flags().StringVar(&recoverOpts.apiServerURL, "apiserver-url", "", "URL of the apiserver, e.g. https://10.0.0.1:6443. Used with --token-file. Without --kubeconfig, a new admin kubeconfig pointing at this URL is created using the CA recovered from the kube-controller-manager secret.")
+	cmdRecover.Flags().BoolVar(&recoverOpts.inCluster, "in-cluster", false, "Read the control plane from the apiserver using the service account of the pod that bootkube runs in, instead of --kubeconfig. Requires --apiserver-url unless --kubeconfig is given.")
+	cmdRecover.Flags().StringVar(&recoverOpts.tokenFile, "token-file", "", "Path to a service account token used to read the control plane from the apiserver at --apiserver-url, instead of --kubeconfig. Must be used in conjunction with --ca-file.")
+	cmdRecover.Flags().StringVar(&recoverOpts.caFile, "ca-file", "", "Path to the CA certificate used to verify the apiserver when using --token-file.")
+	cmdRecover.Flags().StringVar(&recoverOpts.backupFile, "backup-file", "", "Path to an archive created by `bootkube backup`. The control plane is read from the archive after verifying its checksums. Requires --kubeconfig or --apiserver-url.")
+	cmdRecover.Flags().StringSliceVar(&recoverOpts.namespaces, "namespaces", nil, "Namespaces to read the control plane from, comma separated. Defaults to kube-system.")
+	cmdRecover.Flags().StringSliceVar(&recoverOpts.etcdKeys, "etcd-keys", nil, "Etcd keys of the resources that recovery reads, comma separated, in the format [group/]version/resource=etcd-key, e.g. apps/v1/deployments=custom/deployments. The etcd key is the path below --etcd-prefix that the objects are stored under. Only configmaps, daemonsets, deployments, secrets and nodes are read; other resources are exported by `bootkube backup --resources`.")
+	cmdRecover.Flags().StringVar(&recoverOpts.componentsPath, "components", "", "Path to a YAML file listing the components to recover, optionally with label selectors and the containers that need an injected kubeconfig. Defaults to the control plane components output by `bootkube render`.")
+	cmdRecover.Flags().BoolVar(&recoverOpts.dryRun, "dry-run", false, "Print the recovered components, required secrets and configmaps, and the files that would be written, without writing anything to --recovery-dir.")
+	cmdRecover.Flags().StringVar(&recoverOpts.diffAssetDir, "diff", "", "Path to the output of `bootkube render`. Compares the images and flags of the recovered bootstrap manifests with the rendered ones, without writing anything to --recovery-dir.")
+	cmdRecover.Flags().StringVar(&recoverOpts.node, "node", "", "Name or address of the node that the bootstrap control plane will run on. Environment variables of the bootstrap pods that are set from node-specific fields, such as POD_IP, are replaced by the node's values, so that flags like --advertise-address are explicit.")
+	cmdRecover.Flags().BoolVar(&recoverOpts.allMasters, "all-masters", false, "Write node-specific assets for every master node to a subdirectory of --recovery-dir named after the node. Master nodes are the nodes that match the node selectors of the bootstrap pods.")
+	cmdRecover.Flags().BoolVar(&recoverOpts.skipValidation, "skip-validation", false, "Write the recovered assets even if they fail validation. By default, expired or unchained certificates, apiserver certificates that do not cover the apiserver URL, missing hostPath assets and invalid images are reported and nothing is written.")
 	cmdRecover.Flags().StringVar(&recoverOpts.podManifestPath, "pod-manifest-path", "/etc/kubernetes/manifests", "The location where the kubelet is configured to look for static pod manifests. (Only need to be set when recovering from a etcd backup file)")
 }
 
 func runCmdRecover(cmd *cobra.Command, args []string) error {
 	var err error
-	recoverOpts.kubeConfigPath, err = filepath.Abs(recoverOpts.kubeConfigPath)
-	if err != nil {
-		return err
+	if recoverOpts.kubeConfigPath != "" {
+		recoverOpts.kubeConfigPath, err = filepath.Abs(recoverOpts.kubeConfigPath)
+		if err != nil {
+			return err
+		}
 	}
 
 	var backend recovery.Backend
 	switch {
+	case recoverOpts.backupFile != "":
+		bootkube.UserOutput("Attempting recovery using backup %q...\n", recoverOpts.backupFile)
+		f, err := os.Open(recoverOpts.backupFile)
+		if err != nil {
+			return err
+		}
+		backend, err = recovery.NewBackupBackend(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
 	case recoverOpts.etcdServers != "":
 		bootkube.UserOutput("Attempting recovery using etcd cluster at %q...\n", recoverOpts.etcdServers)
-		etcdClient, err := createEtcdClient()
+		etcdClient, err := createEtcdClient(recoverOpts.etcdServers, recoverOpts.etcdCAPath, recoverOpts.etcdCertificatePath, recoverOpts.etcdPrivateKeyPath)
 		if err != nil {
 			return err
 		}
 		backend = recovery.NewEtcdBackend(etcdClient, recoverOpts.etcdPrefix)
 
+	case recoverOpts.inCluster:
+		bootkube.UserOutput("Attempting recovery using in-cluster apiserver...\n")
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return err
+		}
+		if backend, err = recovery.NewAPIServerBackendForConfig(config); err != nil {
+			return err
+		}
+
+	case recoverOpts.tokenFile != "":
+		bootkube.UserOutput("Attempting recovery using apiserver at %q...\n", recoverOpts.apiServerURL)
+		config, err := recovery.ServiceAccountConfig(recoverOpts.apiServerURL, recoverOpts.tokenFile, recoverOpts.caFile)
+		if err != nil {
+			return err
+		}
+		if backend, err = recovery.NewAPIServerBackendForConfig(config); err != nil {
+			return err
+		}
+
 	default:
 		bootkube.UserOutput("Attempting recovery using apiserver at %q...\n", recoverOpts.kubeConfigPath)
 		backend, err = recovery.NewAPIServerBackend(recoverOpts.kubeConfigPath)
@@ -77,15 +139,89 @@ func runCmdRecover(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	as, err := recovery.Recover(context.Background(), backend, recoverOpts.kubeConfigPath)
+	var components []recovery.Component
+	if recoverOpts.componentsPath != "" {
+		data, err := ioutil.ReadFile(recoverOpts.componentsPath)
+		if err != nil {
+			return err
+		}
+		if components, err = recovery.ParseComponents(data); err != nil {
+			return err
+		}
+	}
+
+	scope, err := parseRecoveryScope(recoverOpts.namespaces, recoverOpts.etcdKeys)
 	if err != nil {
 		return err
 	}
-	return as.WriteFiles(recoverOpts.recoveryDir)
+
+	opts := recovery.Options{
+		KubeConfigPath: recoverOpts.kubeConfigPath,
+		Server:         recoverOpts.apiServerURL,
+		Scope:          scope,
+		Components:     components,
+		Node:           recoverOpts.node,
+	}
+	var plans []*recovery.Plan
+	if recoverOpts.allMasters {
+		if plans, err = recovery.NewMasterPlans(context.Background(), backend, opts); err != nil {
+			return err
+		}
+	} else {
+		plan, err := recovery.NewPlan(context.Background(), backend, opts)
+		if err != nil {
+			return err
+		}
+		plans = append(plans, plan)
+	}
+
+	// All plans are checked before anything is written.
+	valid := true
+	for _, plan := range plans {
+		desc := "Recovered assets"
+		if plan.Node != "" {
+			desc = fmt.Sprintf("Recovered assets for node %s", plan.Node)
+		}
+		if recoverOpts.dryRun {
+			plan.WriteSummary(os.Stdout)
+		}
+		if recoverOpts.diffAssetDir != "" {
+			rendered, err := recovery.LoadBootstrapPods(recoverOpts.diffAssetDir)
+			if err != nil {
+				return err
+			}
+			if !recovery.DiffBootstrapPods(os.Stdout, plan.Pods, rendered) {
+				bootkube.UserOutput("%s match %q.\n", desc, recoverOpts.diffAssetDir)
+			}
+		}
+		if problems := plan.Validate(); len(problems) > 0 {
+			bootkube.UserOutput("%s failed validation:\n", desc)
+			for _, p := range problems {
+				bootkube.UserOutput("  %v\n", p)
+			}
+			valid = false
+		}
+	}
+	if !valid && !recoverOpts.skipValidation {
+		return errors.New("recovered assets failed validation, not writing them (use --skip-validation to write them anyway)")
+	}
+	if recoverOpts.dryRun || recoverOpts.diffAssetDir != "" {
+		return nil
+	}
+	for _, plan := range plans {
+		dir := recoverOpts.recoveryDir
+		if recoverOpts.allMasters {
+			dir = filepath.Join(dir, plan.Node)
+		}
+		if err := plan.Assets.WriteFiles(dir); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func validateRecoverOpts(cmd *cobra.Command, args []string) error {
-	if recoverOpts.recoveryDir == "" {
+	if recoverOpts.recoveryDir == "" && !recoverOpts.dryRun && recoverOpts.diffAssetDir == "" {
 		return errors.New("missing required flag: --recovery-dir")
 	}
 	if (recoverOpts.etcdCertificatePath != "" || recoverOpts.etcdPrivateKeyPath != "") && (recoverOpts.etcdCertificatePath == "" || recoverOpts.etcdPrivateKeyPath == "") {
@@ -94,31 +230,60 @@ func validateRecoverOpts(cmd *cobra.Command, args []string) error {
 	if recoverOpts.etcdPrefix == "" {
 		return errors.New("missing required flag: --etcd-prefix")
 	}
-	if recoverOpts.kubeConfigPath == "" {
-		return errors.New("missing required flag: --kubeconfig")
+	if recoverOpts.tokenFile != "" && (recoverOpts.caFile == "" || recoverOpts.apiServerURL == "") {
+		return errors.New("--token-file must be used in conjunction with --ca-file and --apiserver-url")
 	}
-	return nil
+	if recoverOpts.kubeConfigPath == "" && recoverOpts.etcdServers == "" && !recoverOpts.inCluster && recoverOpts.tokenFile == "" && recoverOpts.backupFile == "" {
+		return errors.New("missing required flag: --kubeconfig (or --etcd-servers, --in-cluster, --token-file or --backup-file)")
+	}
+	if recoverOpts.kubeConfigPath == "" && recoverOpts.apiServerURL == "" {
+		return errors.New("missing required flag: --apiserver-url (required when --kubeconfig is not given)")
+	}
+	if recoverOpts.node != "" && recoverOpts.allMasters {
+		return errors.New("--node and --all-masters are mutually exclusive")
+	}
+	_, err := parseRecoveryScope(recoverOpts.namespaces, recoverOpts.etcdKeys)
+	return err
+}
+
+// parseRecoveryScope returns the recovery.Scope for the --namespaces and --etcd-keys flags.
+func parseRecoveryScope(namespaces, etcdKeys []string) (recovery.Scope, error) {
+	scope, err := parseScope(namespaces, etcdKeys)
+	if err != nil {
+		return recovery.Scope{}, err
+	}
+	for _, r := range scope.Resources {
+		if r.EtcdKey == "" {
+			return recovery.Scope{}, fmt.Errorf("invalid --etcd-keys entry %q: missing etcd key", r)
+		}
+	}
+	if err := recovery.CheckRecoveryScope(scope); err != nil {
+		return recovery.Scope{}, fmt.Errorf("invalid --etcd-keys: %v", err)
+	}
+	return scope, nil
 }
 
-func createEtcdClient() (*clientv3.Client, error) {
+// createEtcdClient creates a client for the comma separated etcd servers, using TLS if a CA or a
+// client certificate and key are given.
+func createEtcdClient(servers, caPath, certificatePath, privateKeyPath string) (*clientv3.Client, error) {
 	cfg := clientv3.Config{
-		Endpoints:   strings.Split(recoverOpts.etcdServers, ","),
+		Endpoints:   strings.Split(servers, ","),
 		DialTimeout: 5 * time.Second,
 	}
 	var roots *x509.CertPool
-	if recoverOpts.etcdCAPath != "" {
+	if caPath != "" {
 		roots = x509.NewCertPool()
-		etcdCA, err := ioutil.ReadFile(recoverOpts.etcdCAPath)
+		etcdCA, err := ioutil.ReadFile(caPath)
 		if err != nil {
 			return nil, err
 		}
 		if ok := roots.AppendCertsFromPEM(etcdCA); !ok {
-			return nil, fmt.Errorf("error processing --etcd-ca-file %s", recoverOpts.etcdCAPath)
+			return nil, fmt.Errorf("error processing --etcd-ca-file %s", caPath)
 		}
 	}
 	var certs []tls.Certificate
-	if recoverOpts.etcdCertificatePath != "" && recoverOpts.etcdPrivateKeyPath != "" {
-		clientCert, err := tls.LoadX509KeyPair(recoverOpts.etcdCertificatePath, recoverOpts.etcdPrivateKeyPath)
+	if certificatePath != "" && privateKeyPath != "" {
+		clientCert, err := tls.LoadX509KeyPair(certificatePath, privateKeyPath)
 		if err != nil {
 			return nil, err
 		}
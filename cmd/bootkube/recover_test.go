@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kubernetes-sigs/bootkube/pkg/recovery"
+)
+
+func TestParseRecoveryScope(t *testing.T) {
+	scope, err := parseRecoveryScope([]string{"kube-system", "control-plane"}, []string{"apps/v1/deployments=custom/deployments", "v1/nodes=nodes"})
+	if err != nil {
+		t.Fatalf("parseRecoveryScope() = %v, want: nil", err)
+	}
+	want := recovery.Scope{
+		Namespaces: []string{"kube-system", "control-plane"},
+		Resources: []recovery.Resource{
+			{Group: "apps", Version: "v1", Resource: "deployments", EtcdKey: "custom/deployments"},
+			{Version: "v1", Resource: "nodes", EtcdKey: "nodes"},
+		},
+	}
+	if !reflect.DeepEqual(scope, want) {
+		t.Errorf("parseRecoveryScope() = %+v, want: %+v", scope, want)
+	}
+
+	for _, keys := range [][]string{
+		{"apps/v1/deployments"},
+		{"example.com/v1/widgets=example.com/widgets"},
+		{"deployments=custom/deployments"},
+	} {
+		if _, err := parseRecoveryScope(nil, keys); err == nil {
+			t.Errorf("parseRecoveryScope(%v) = nil, want: non-nil", keys)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+// +build windows
+
+package main
+
+import (
+	"math"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// flock tries to grab an exclusive lock on the given path.
+// If the lock is already acquired by other process, the function will block.
+func flock(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+
+	// We don't need to close the file since we should hold
+	// it until the process exits.
+
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, math.MaxUint32, math.MaxUint32, &windows.Overlapped{})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kubernetes-sigs/bootkube/pkg/checkpoint"
+)
+
+const inspectUsage = `Usage: %s inspect [flags]
+
+Reports the checkpoints on this node: whether they are active, their checkpointed secret,
+configMap and volume files, missing files, orphaned files, and how active manifests differ from
+their inactive copies. Only local files are read, so it works without an apiserver or kubelet.
+
+With --activate or --deactivate, a checkpoint is started or stopped by hand first. A running
+checkpointer reverts this if it disagrees, so stop it first, for example by moving its active
+manifest out of the kubelet manifest directory. With --secret-encryption-key-file, --activate fails
+without activating the checkpoint if its secrets cannot be decrypted. --deactivate keeps the
+decrypted secrets, since the pod may still be terminating; remove the reported directories once it
+stopped, or let a reboot clear the tmpfs.
+
+Flags:
+`
+
+// runInspect runs the inspect subcommand with args, and returns the exit code.
+func runInspect(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), inspectUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	var (
+		opts          checkpoint.InspectOptions
+		activate      string
+		deactivate    string
+		secretKeyFile string
+	)
+	fs.StringVar(&opts.Root, "root", "/", "The directory that the checkpoint paths are relative to, e.g. the mount point of the root filesystem of a node that does not boot. Must be / with --activate or --deactivate.")
+	fs.BoolVar(&opts.Diff, "diff", true, "Show the differences between active manifests and their inactive copies.")
+	fs.StringVar(&activate, "activate", "", "Activate the checkpoint of the pod with this namespace/name.")
+	fs.StringVar(&deactivate, "deactivate", "", "Deactivate the checkpoint of the pod with this namespace/name.")
+	fs.StringVar(&secretKeyFile, "secret-encryption-key-file", "", "The key that checkpointed secrets are encrypted with. Secrets are decrypted when a checkpoint is activated, and their directories are reported when it is deactivated.")
+	fs.StringVar(&opts.DecryptedSecretsPath, "decrypted-secrets-path", checkpoint.DefaultDecryptedSecretsPath, "The tmpfs directory that encrypted secrets of active checkpoints are decrypted to.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (activate != "" || deactivate != "") && opts.Root != "/" {
+		fmt.Fprintln(os.Stderr, "--activate and --deactivate cannot be used with --root")
+		return 2
+	}
+
+	var secrets *checkpoint.SecretDecrypter
+	if secretKeyFile != "" && (activate != "" || deactivate != "") {
+		var err error
+		if secrets, err = checkpoint.NewSecretDecrypter(secretKeyFile, opts.DecryptedSecretsPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	if deactivate != "" {
+		if err := checkpoint.Deactivate(deactivate); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Deactivated checkpoint of %s.\n", deactivate)
+		if secrets != nil {
+			for _, p := range secrets.DecryptedPaths(deactivate) {
+				fmt.Printf("Decrypted secrets are kept in %s until the pod stopped.\n", p)
+			}
+		}
+		fmt.Println()
+	}
+	if activate != "" {
+		if err := checkpoint.Activate(activate, secrets); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Activated checkpoint of %s.\n\n", activate)
+	}
+
+	if err := checkpoint.Inspect(os.Stdout, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
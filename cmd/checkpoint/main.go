@@ -4,7 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"syscall"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -18,29 +18,73 @@ const (
 	podNameEnv      = "POD_NAME"
 	podNamespaceEnv = "POD_NAMESPACE"
 
-	defaultRuntimeEndpoint       = "unix:///var/run/dockershim.sock"
 	defaultRuntimeRequestTimeout = 2 * time.Minute
 	defaultCheckpointGracePeriod = 1 * time.Minute
+	defaultResyncPeriod          = 5 * time.Second
 )
 
 var (
 	lockfilePath          string
 	kubeconfigPath        string
 	remoteRuntimeEndpoint string
+	kubeletCAFile         string
+	kubeletServerName     string
+	kubeletInsecure       bool
 	runtimeRequestTimeout time.Duration
+	runtimeTLSCAFile      string
+	runtimeTLSCertFile    string
+	runtimeTLSKeyFile     string
 	checkpointGracePeriod time.Duration
+	resyncPeriod          time.Duration
+	listenAddress         string
+	secretKeyFile         string
+	decryptedSecretsPath  string
+	recordInputsFile      string
+	namespaces            string
+	parentSelector        string
+	peerListenAddress     string
+	peers                 string
+	peerSelfActivate      bool
+	peerTLSCAFile         string
+	peerTLSCertFile       string
+	peerTLSKeyFile        string
+	statusNamespace       string
 )
 
 func init() {
 	flag.StringVar(&lockfilePath, "lock-file", "/var/run/lock/pod-checkpointer.lock", "The path to lock file for checkpointer to use")
 	flag.StringVar(&kubeconfigPath, "kubeconfig", "/etc/kubernetes/kubeconfig", "Path to a kubeconfig file containing credentials used to talk to the kubelet.")
 	flag.Set("logtostderr", "true")
-	flag.StringVar(&remoteRuntimeEndpoint, "container-runtime-endpoint", defaultRuntimeEndpoint, "[Experimental] The endpoint of remote runtime service. Currently unix socket is supported on Linux, and tcp is supported on windows.  Examples:'unix:///var/run/dockershim.sock', 'tcp://localhost:3735'")
+	flag.StringVar(&kubeletCAFile, "kubelet-certificate-authority", "", "Path to a CA bundle that the kubelet's serving certificate is verified with, e.g. /var/lib/kubelet/pki/kubelet.crt for a self-signed kubelet certificate. If empty, the CA of the kubeconfig is used.")
+	flag.StringVar(&kubeletServerName, "kubelet-server-name", "", "The name that the kubelet's serving certificate is verified for, e.g. the node name. If empty, the address that the kubelet is reached at is used.")
+	flag.BoolVar(&kubeletInsecure, "kubelet-insecure-fallback", false, "If the kubelet cannot be queried with a verified connection, query it without verifying its serving certificate, and then on its read-only port 10255. Each fallback is logged as a warning.")
+	flag.StringVar(&namespaces, "checkpoint-namespaces", "", "Comma-separated namespaces, besides the namespace of the checkpointer, whose parent pods are checkpointed, or '*' for all namespaces.")
+	flag.StringVar(&parentSelector, "checkpoint-selector", "", "Label selector, e.g. 'tier=control-plane', that parent pods must match to be checkpointed. If empty, all parent pods in the selected namespaces are checkpointed.")
+	flag.StringVar(&remoteRuntimeEndpoint, "container-runtime-endpoint", "", "[Experimental] The endpoint of remote runtime service. Unix sockets and tcp are supported on Linux, and tcp and named pipes on Windows. If empty, the containerd, CRI-O, cri-dockerd and dockershim sockets under /var/run are tried in that order. Examples:'unix:///run/containerd/containerd.sock', 'tcp://localhost:3735', 'npipe:////./pipe/containerd-containerd'")
+	flag.StringVar(&runtimeTLSCAFile, "container-runtime-tls-ca", "", "Path to a CA bundle that the serving certificate of a tcp container runtime endpoint is verified with. Setting any of the --container-runtime-tls flags enables TLS.")
+	flag.StringVar(&runtimeTLSCertFile, "container-runtime-tls-cert", "", "Path to a client certificate that is presented to a tcp container runtime endpoint.")
+	flag.StringVar(&runtimeTLSKeyFile, "container-runtime-tls-key", "", "Path to the key of --container-runtime-tls-cert.")
 	flag.DurationVar(&runtimeRequestTimeout, "runtime-request-timeout", defaultRuntimeRequestTimeout, "Timeout of all runtime requests except long running request - pull, logs, exec and attach. When timeout exceeded, kubelet will cancel the request, throw out an error and retry later.")
 	flag.DurationVar(&checkpointGracePeriod, "checkpoint-grace-period", defaultCheckpointGracePeriod, "Grace period for cleaning up checkpoints when the parent pod is deleted. Non-zero values are helpful for accommodating control plane eventual consistency.")
+	flag.StringVar(&listenAddress, "listen-address", "", "The address on which to serve /healthz and /metrics, for example '127.0.0.1:9191'. They are not served if empty.")
+	flag.StringVar(&secretKeyFile, "secret-encryption-key-file", "", "Path to a node-local 32 byte key that checkpointed secrets and service account tokens are encrypted with. It is generated if it does not exist. If empty, secrets are checkpointed in plaintext.")
+	flag.StringVar(&decryptedSecretsPath, "decrypted-secrets-path", checkpoint.DefaultDecryptedSecretsPath, "The tmpfs directory that encrypted secrets of active checkpoints are decrypted to. Only used with --secret-encryption-key-file.")
+	flag.StringVar(&recordInputsFile, "record-inputs", "", "[Debugging] Record the kubelet, container runtime and apiserver inputs and the actions of every iteration to this file, so that they can be replayed by the simulation tests. Recordings hold the specs of parent pods, but not the contents of secrets and configmaps, and grow with every iteration.")
+	flag.StringVar(&peerListenAddress, "peer-listen-address", "", "The address on which to serve the heartbeat for the checkpointers on the other masters over mutual TLS, for example '$(HOST_IP):9192'. Bind it to the address of the master rather than all interfaces. If set, checkpoints are only activated while the apiserver is unavailable if a quorum of the masters, including this one, cannot reach it either. Requires the --peer-tls flags.")
+	flag.StringVar(&peers, "peers", "", "Comma-separated host:port --peer-listen-addresses of the checkpointers on the other masters.")
+	flag.StringVar(&peerTLSCAFile, "peer-tls-ca", "", "Path to the CA bundle that the certificates of the peers are verified with.")
+	flag.StringVar(&peerTLSCertFile, "peer-tls-cert", "", "Path to the certificate that the heartbeat is served and peers are probed with. It must be valid for the address of this master in the --peers of the others, for both server and client authentication.")
+	flag.StringVar(&peerTLSKeyFile, "peer-tls-key", "", "Path to the key of --peer-tls-cert.")
+	flag.BoolVar(&peerSelfActivate, "peer-self-activate", false, "Activate checkpoints while the apiserver is unavailable if no peer is reachable either. Only used with --peer-listen-address.")
+	flag.StringVar(&statusNamespace, "status-namespace", "", "The namespace that the status of the checkpoints on this node is published in, in a ConfigMap named pod-checkpointer-status-<node-name>. If empty, the namespace of the checkpointer is used. The checkpointer needs permission to create and update ConfigMaps in it.")
+	flag.DurationVar(&resyncPeriod, "resync-period", defaultResyncPeriod, "Interval at which checkpoints are reconciled against the kubelet and container runtime. Changes to parent pods and their secrets and configmaps are watched and handled immediately.")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		os.Exit(runInspect(os.Args[2:]))
+	}
+
 	flag.Parse()
 	defer glog.Flush()
 
@@ -55,6 +99,23 @@ func main() {
 		glog.Fatalf("Error when acquiring the flock: %v", err)
 	}
 
+	var checkpointNamespaces []string
+	allNamespaces := namespaces == "*"
+	if namespaces != "" && !allNamespaces {
+		for _, ns := range strings.Split(namespaces, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				checkpointNamespaces = append(checkpointNamespaces, ns)
+			}
+		}
+	}
+
+	var peerAddresses []string
+	for _, peer := range strings.Split(peers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peerAddresses = append(peerAddresses, peer)
+		}
+	}
+
 	glog.Infof("Starting checkpointer for node: %s", nodeName)
 	// This is run as a static pod, so we can't use InClusterConfig because
 	// KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT won't be set in
@@ -69,31 +130,36 @@ func main() {
 			PodName:      podName,
 			PodNamespace: podNamespace,
 		},
-		KubeConfig:            kubeConfig,
-		RemoteRuntimeEndpoint: remoteRuntimeEndpoint,
-		RuntimeRequestTimeout: runtimeRequestTimeout,
-		CheckpointGracePeriod: checkpointGracePeriod,
+		KubeConfig:              kubeConfig,
+		Namespaces:              checkpointNamespaces,
+		AllNamespaces:           allNamespaces,
+		ParentSelector:          parentSelector,
+		KubeletCAFile:           kubeletCAFile,
+		KubeletServerName:       kubeletServerName,
+		KubeletInsecureFallback: kubeletInsecure,
+		RemoteRuntimeEndpoint:   remoteRuntimeEndpoint,
+		RuntimeRequestTimeout:   runtimeRequestTimeout,
+		RuntimeTLSCAFile:        runtimeTLSCAFile,
+		RuntimeTLSCertFile:      runtimeTLSCertFile,
+		RuntimeTLSKeyFile:       runtimeTLSKeyFile,
+		CheckpointGracePeriod:   checkpointGracePeriod,
+		ResyncPeriod:            resyncPeriod,
+		ListenAddress:           listenAddress,
+		SecretEncryptionKeyFile: secretKeyFile,
+		DecryptedSecretsPath:    decryptedSecretsPath,
+		RecordInputsFile:        recordInputsFile,
+		PeerListenAddress:       peerListenAddress,
+		Peers:                   peerAddresses,
+		PeerSelfActivate:        peerSelfActivate,
+		PeerTLSCAFile:           peerTLSCAFile,
+		PeerTLSCertFile:         peerTLSCertFile,
+		PeerTLSKeyFile:          peerTLSKeyFile,
+		StatusNamespace:         statusNamespace,
 	}); err != nil {
 		glog.Fatalf("Error starting checkpointer: %v", err)
 	}
 }
 
-// flock tries to grab a flock on the given path.
-// If the lock is already acquired by other process, the function will block.
-// TODO(yifan): Maybe replace this with kubernetes/pkg/util/flock.Acquire() once
-// https://github.com/kubernetes/kubernetes/issues/42929 is solved, or maybe not.
-func flock(path string) error {
-	fd, err := syscall.Open(path, syscall.O_CREAT|syscall.O_RDWR, 0600)
-	if err != nil {
-		return err
-	}
-
-	// We don't need to close the fd since we should hold
-	// it until the process exits.
-
-	return syscall.Flock(fd, syscall.LOCK_EX)
-}
-
 // readDownwardAPI fills the node name, pod name, and pod namespace.
 func readDownwardAPI() (nodeName, podName, podNamespace string, err error) {
 	nodeName = os.Getenv(nodeNameEnv)
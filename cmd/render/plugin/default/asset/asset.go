@@ -90,6 +90,7 @@ const (
 	AssetPathCheckpointerRoleBinding        = "manifests/pod-checkpointer-role-binding.yaml"
 	AssetPathCheckpointerClusterRole        = "manifests/pod-checkpointer-cluster-role.yaml"
 	AssetPathCheckpointerClusterRoleBinding = "manifests/pod-checkpointer-cluster-role-binding.yaml"
+	AssetPathCheckpointerStatusNamespace    = "manifests/pod-checkpointer-status-namespace.yaml"
 	AssetPathEtcdClientSecret               = "manifests/etcd-client-tls.yaml"
 	AssetPathEtcdPeerSecret                 = "manifests/etcd-peer-tls.yaml"
 	AssetPathEtcdServerSecret               = "manifests/etcd-server-tls.yaml"
@@ -97,6 +98,7 @@ const (
 	AssetPathCSRApproverRoleBinding         = "manifests/csr-approver-role-binding.yaml"
 	AssetPathCSRRenewalRoleBinding          = "manifests/csr-renewal-role-binding.yaml"
 	AssetPathKubeSystemSARoleBinding        = "manifests/kube-system-rbac-role-binding.yaml"
+	AssetPathBackupCronJob                  = "manifests/bootkube-backup.yaml"
 	AssetPathBootstrapManifests             = "bootstrap-manifests"
 	AssetPathBootstrapAPIServer             = "bootstrap-manifests/bootstrap-apiserver.yaml"
 	AssetPathBootstrapControllerManager     = "bootstrap-manifests/bootstrap-controller-manager.yaml"
@@ -105,6 +107,10 @@ const (
 
 var BootstrapSecretsDir = "/etc/kubernetes/bootstrap-secrets" // Overridden for testing.
 
+// DefaultCheckpointKubeletCA is the self-signed serving certificate that kubelets without
+// --tls-cert-file or server certificate rotation generate in their default --cert-dir.
+const DefaultCheckpointKubeletCA = "/var/lib/kubelet/pki/kubelet.crt"
+
 // AssetConfig holds all configuration needed when generating
 // the default set of assets.
 type Config struct {
@@ -127,6 +133,28 @@ type Config struct {
 	BootstrapSecretsSubdir string
 	Images                 ImageVersions
 
+	// BackupSchedule is the cron schedule of a CronJob that runs `bootkube backup` on the master
+	// nodes. No CronJob is rendered if empty.
+	BackupSchedule string
+	// BackupImage is the bootkube image that the backup CronJob runs. It must be a bootkube version
+	// with the backup subcommand.
+	BackupImage string
+	// BackupHostPath is the directory on the masters that the backup CronJob writes its archives to,
+	// e.g. a network filesystem, so that they survive the loss of a master.
+	BackupHostPath string
+
+	// CheckpointNamespaces are the namespaces, besides kube-system, whose parent pods the pod
+	// checkpointer checkpoints. CheckpointAllNamespaces selects all namespaces instead. The RBAC of
+	// the pod checkpointer is extended to the selected namespaces.
+	CheckpointNamespaces    []string
+	CheckpointAllNamespaces bool
+	// CheckpointSelector is a label selector that parent pods must match to be checkpointed.
+	CheckpointSelector string
+	// CheckpointKubeletCA is the path on the masters of the CA bundle that the pod checkpointer
+	// verifies the kubelet serving certificates with, usually DefaultCheckpointKubeletCA. Only this
+	// file is mounted. The CA of the kubeconfig is used if it is empty.
+	CheckpointKubeletCA string
+
 	// PodCIDR describes the networking subnet to be used for inter-pod networking.
 	//
 	// Deprecated: PodCIDR exists only for compatibility with older external
@@ -322,6 +350,8 @@ func NewDefaultAssets(conf Config) (Assets, error) {
 type Asset struct {
 	Name string
 	Data []byte
+	// Mode is the file mode the asset is written with. Defaults to 0600 if zero.
+	Mode os.FileMode
 }
 
 type Assets []Asset
@@ -360,5 +390,12 @@ func (a Asset) WriteFile(path string) error {
 		return err
 	}
 	fmt.Printf("Writing asset: %s\n", f)
-	return ioutil.WriteFile(f, a.Data, 0600)
+	if a.Mode == 0 {
+		return ioutil.WriteFile(f, a.Data, 0600)
+	}
+	if err := ioutil.WriteFile(f, a.Data, a.Mode); err != nil {
+		return err
+	}
+	// Make sure the mode is not affected by the umask.
+	return os.Chmod(f, a.Mode)
 }
@@ -1,9 +1,21 @@
 package asset
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
 	"net"
+	"reflect"
 	"strings"
 	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset/internal"
 )
 
 type f struct {
@@ -99,3 +111,155 @@ func TestStringerSlice(t *testing.T) {
 		t.Errorf("output index 1 mismatch (%s) on testNormal (%s)", out[1], testNormal1[1].String())
 	}
 }
+
+func TestCheckpointerRBAC(t *testing.T) {
+	// decode renders a template and returns the namespaces of its documents, or "cluster" for
+	// cluster-scoped ones, with the resources that they grant access to.
+	decode := func(template []byte, conf Config) map[string][]string {
+		t.Helper()
+		a, err := assetFromTemplate("rbac", template, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string][]string)
+		r := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(a.Data)))
+		for {
+			doc, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := yaml.ToJSON(doc)
+			if err != nil {
+				t.Fatalf("invalid document %s: %v", doc, err)
+			}
+			var role rbacv1.Role
+			if err := json.Unmarshal(data, &role); err != nil {
+				t.Fatal(err)
+			}
+			namespace := role.Namespace
+			if namespace == "" {
+				namespace = "cluster"
+			}
+			got[namespace] = []string{}
+			for _, rule := range role.Rules {
+				got[namespace] = append(got[namespace], rule.Resources...)
+			}
+		}
+		return got
+	}
+	parentResources := []string{"pods", "secrets", "configmaps", "serviceaccounts/token"}
+
+	roles := decode(internal.CheckpointerRole, Config{CheckpointNamespaces: []string{"monitoring", "logging"}})
+	if len(roles) != 4 || !reflect.DeepEqual(roles["monitoring"], parentResources) || !reflect.DeepEqual(roles["logging"], parentResources) {
+		t.Errorf("roles = %v, want kube-system, pod-checkpointer-status, and %v in monitoring and logging", roles, parentResources)
+	}
+	// ConfigMaps are only written in the status namespace, never in kube-system.
+	if !reflect.DeepEqual(roles["kube-system"], parentResources) {
+		t.Errorf("role in kube-system grants %v, want: %v", roles["kube-system"], parentResources)
+	}
+	if want := []string{"configmaps"}; !reflect.DeepEqual(roles["pod-checkpointer-status"], want) {
+		t.Errorf("role in pod-checkpointer-status grants %v, want: %v", roles["pod-checkpointer-status"], want)
+	}
+	bindings := decode(internal.CheckpointerRoleBinding, Config{CheckpointNamespaces: []string{"monitoring", "logging"}})
+	if len(bindings) != 4 || bindings["pod-checkpointer-status"] == nil || bindings["monitoring"] == nil || bindings["logging"] == nil {
+		t.Errorf("role bindings = %v, want kube-system, pod-checkpointer-status, monitoring and logging", bindings)
+	}
+
+	clusterRole := decode(internal.CheckpointerClusterRole, Config{})
+	if want := []string{"nodes", "nodes/proxy", "events"}; !reflect.DeepEqual(clusterRole["cluster"], want) {
+		t.Errorf("cluster role grants %v, want: %v", clusterRole["cluster"], want)
+	}
+	clusterRole = decode(internal.CheckpointerClusterRole, Config{CheckpointAllNamespaces: true})
+	if want := append([]string{"nodes", "nodes/proxy", "events"}, parentResources...); !reflect.DeepEqual(clusterRole["cluster"], want) {
+		t.Errorf("cluster role for all namespaces grants %v, want: %v", clusterRole["cluster"], want)
+	}
+}
+
+func TestCheckpointerKubeletCA(t *testing.T) {
+	for _, tc := range []struct {
+		desc       string
+		ca         string
+		wantArg    string
+		wantVolume string
+	}{{
+		desc: "kubeconfig CA",
+	}, {
+		desc:       "self-signed kubelet certificates",
+		ca:         "/var/lib/kubelet/pki/kubelet.crt",
+		wantArg:    "--kubelet-certificate-authority=/var/lib/kubelet/pki/kubelet.crt",
+		wantVolume: "/var/lib/kubelet/pki/kubelet.crt",
+	}} {
+		a, err := assetFromTemplate("checkpointer", internal.CheckpointerTemplate, Config{CheckpointKubeletCA: tc.ca})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := yaml.ToJSON(a.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ds appsv1.DaemonSet
+		if err := json.Unmarshal(data, &ds); err != nil {
+			t.Fatal(err)
+		}
+		spec := ds.Spec.Template.Spec
+
+		var gotArg string
+		for _, arg := range spec.Containers[0].Command {
+			if strings.HasPrefix(arg, "--kubelet-certificate-authority") {
+				gotArg = arg
+			}
+		}
+		if gotArg != tc.wantArg {
+			t.Errorf("%s: kubelet CA argument = %q, want: %q", tc.desc, gotArg, tc.wantArg)
+		}
+
+		// Only the CA file is mounted from the kubelet's directories, never its keys.
+		var gotVolume string
+		for _, v := range spec.Volumes {
+			if v.HostPath == nil || !strings.HasPrefix(v.HostPath.Path, "/var/lib/kubelet") {
+				continue
+			}
+			if v.HostPath.Type == nil || *v.HostPath.Type != "File" {
+				t.Errorf("%s: hostPath %s has type %v, want: File", tc.desc, v.HostPath.Path, v.HostPath.Type)
+			}
+			gotVolume = v.HostPath.Path
+		}
+		if gotVolume != tc.wantVolume {
+			t.Errorf("%s: mounted kubelet file = %q, want: %q", tc.desc, gotVolume, tc.wantVolume)
+		}
+	}
+}
+
+func TestBackupCronJob(t *testing.T) {
+	a, err := assetFromTemplate(AssetPathBackupCronJob, internal.BackupCronJobTemplate, Config{
+		BackupSchedule: "0 */6 * * *",
+		BackupImage:    "quay.io/example/bootkube:backup",
+		BackupHostPath: "/mnt/backups",
+		Images:         DefaultImages,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.ToJSON(a.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job batchv1beta1.CronJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		t.Fatal(err)
+	}
+	spec := job.Spec.JobTemplate.Spec.Template.Spec
+
+	// The released default image has no backup subcommand, so the configured image is used.
+	if got, want := spec.Containers[0].Image, "quay.io/example/bootkube:backup"; got != want {
+		t.Errorf("backup image = %q, want: %q", got, want)
+	}
+	for _, v := range spec.Volumes {
+		if v.Name == "backups" && (v.HostPath == nil || v.HostPath.Path != "/mnt/backups") {
+			t.Errorf("backups volume = %+v, want hostPath /mnt/backups", v.VolumeSource)
+		}
+	}
+}
@@ -305,6 +305,19 @@ spec:
         - --lock-file=/var/run/lock/pod-checkpointer.lock
         - --kubeconfig=/etc/checkpointer/kubeconfig
         - --checkpoint-grace-period=5m
+{{- if .CheckpointKubeletCA }}
+        - --kubelet-certificate-authority={{ .CheckpointKubeletCA }}
+{{- end }}
+        - --kubelet-server-name=$(NODE_NAME)
+        - --status-namespace=pod-checkpointer-status
+{{- if .CheckpointAllNamespaces }}
+        - --checkpoint-namespaces=*
+{{- else if .CheckpointNamespaces }}
+        - --checkpoint-namespaces={{ range $i, $e := .CheckpointNamespaces }}{{ if $i }},{{end}}{{ $e }}{{end}}
+{{- end }}
+{{- if .CheckpointSelector }}
+        - "--checkpoint-selector={{ .CheckpointSelector }}"
+{{- end }}
         env:
         - name: NODE_NAME
           valueFrom:
@@ -326,6 +339,11 @@ spec:
           name: etc-kubernetes
         - mountPath: /var/run
           name: var-run
+{{- if .CheckpointKubeletCA }}
+        - mountPath: {{ .CheckpointKubeletCA }}
+          name: kubelet-ca
+          readOnly: true
+{{- end }}
       serviceAccountName: pod-checkpointer
       hostNetwork: true
       nodeSelector:
@@ -345,6 +363,12 @@ spec:
       - name: var-run
         hostPath:
           path: /var/run
+{{- if .CheckpointKubeletCA }}
+      - name: kubelet-ca
+        hostPath:
+          path: {{ .CheckpointKubeletCA }}
+          type: File
+{{- end }}
   updateStrategy:
     rollingUpdate:
       maxUnavailable: 1
@@ -369,7 +393,35 @@ rules:
   verbs: ["get", "watch", "list"]
 - apiGroups: [""] # "" indicates the core API group
   resources: ["secrets", "configmaps"]
-  verbs: ["get"]
+  verbs: ["get", "watch", "list"]
+- apiGroups: [""] # "" indicates the core API group
+  resources: ["serviceaccounts/token"]
+  verbs: ["create"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: pod-checkpointer
+  namespace: pod-checkpointer-status
+rules:
+- apiGroups: [""] # "" indicates the core API group
+  resources: ["configmaps"]
+  verbs: ["create", "update"]
+{{- range .CheckpointNamespaces }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: pod-checkpointer
+  namespace: {{ . }}
+rules:
+- apiGroups: [""] # "" indicates the core API group
+  resources: ["pods", "secrets", "configmaps"]
+  verbs: ["get", "watch", "list"]
+- apiGroups: [""] # "" indicates the core API group
+  resources: ["serviceaccounts/token"]
+  verbs: ["create"]
+{{- end }}
 `)
 
 var CheckpointerRoleBinding = []byte(`apiVersion: rbac.authorization.k8s.io/v1
@@ -385,6 +437,44 @@ subjects:
 - kind: ServiceAccount
   name: pod-checkpointer
   namespace: kube-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: pod-checkpointer
+  namespace: pod-checkpointer-status
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: pod-checkpointer
+subjects:
+- kind: ServiceAccount
+  name: pod-checkpointer
+  namespace: kube-system
+{{- range .CheckpointNamespaces }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: pod-checkpointer
+  namespace: {{ . }}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: pod-checkpointer
+subjects:
+- kind: ServiceAccount
+  name: pod-checkpointer
+  namespace: kube-system
+{{- end }}
+`)
+
+// CheckpointerStatusNamespace is the namespace that the checkpointers publish their statuses in, so
+// that they do not need write access to the ConfigMaps in kube-system.
+var CheckpointerStatusNamespace = []byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: pod-checkpointer-status
 `)
 
 var CheckpointerClusterRole = []byte(`apiVersion: rbac.authorization.k8s.io/v1
@@ -395,6 +485,17 @@ rules:
   - apiGroups: [""]
     resources: ["nodes", "nodes/proxy"]
     verbs: ["get"]
+  - apiGroups: [""]
+    resources: ["events"]
+    verbs: ["create"]
+{{- if .CheckpointAllNamespaces }}
+  - apiGroups: [""]
+    resources: ["pods", "secrets", "configmaps"]
+    verbs: ["get", "watch", "list"]
+  - apiGroups: [""]
+    resources: ["serviceaccounts/token"]
+    verbs: ["create"]
+{{- end }}
 `)
 
 var CheckpointerClusterRoleBinding = []byte(`apiVersion: rbac.authorization.k8s.io/v1
@@ -1632,4 +1733,63 @@ subjects:
   namespace: kube-system
 `)
 
+var BackupCronJobTemplate = []byte(`apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: bootkube-backup
+  namespace: kube-system
+  labels:
+    tier: control-plane
+    k8s-app: bootkube-backup
+spec:
+  schedule: "{{ .BackupSchedule }}"
+  concurrencyPolicy: Forbid
+  successfulJobsHistoryLimit: 1
+  failedJobsHistoryLimit: 3
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          labels:
+            tier: control-plane
+            k8s-app: bootkube-backup
+        spec:
+          containers:
+          - name: bootkube-backup
+            image: {{ .BackupImage }}
+            command:
+            - /bootkube
+            - backup
+            - --backup-dir=/var/lib/bootkube/backups
+            - --keep=7
+{{- if .EtcdUseTLS }}
+            - --etcd-ca-path=/etc/kubernetes/secrets/etcd-client-ca.crt
+            - --etcd-certificate-path=/etc/kubernetes/secrets/etcd-client.crt
+            - --etcd-private-key-path=/etc/kubernetes/secrets/etcd-client.key
+{{- end }}
+            - --etcd-servers={{ range $i, $e := .EtcdServers }}{{ if $i }},{{end}}{{ $e }}{{end}}
+            volumeMounts:
+            - mountPath: /etc/kubernetes/secrets
+              name: secrets
+              readOnly: true
+            - mountPath: /var/lib/bootkube/backups
+              name: backups
+          hostNetwork: true
+          nodeSelector:
+            node-role.kubernetes.io/master: ""
+          restartPolicy: OnFailure
+          tolerations:
+          - key: node-role.kubernetes.io/master
+            operator: Exists
+            effect: NoSchedule
+          volumes:
+          - name: secrets
+            secret:
+              secretName: kube-apiserver
+          - name: backups
+            hostPath:
+              path: {{ .BackupHostPath }}
+              type: DirectoryOrCreate
+`)
+
 // vim: set expandtab:tabstop=2
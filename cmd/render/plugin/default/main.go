@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/url"
+	"path/filepath"
 	"strings"
 
 	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset"
@@ -38,6 +39,12 @@ var (
 		cloudProvider       string
 		networkProvider     string
 		clusterName         string
+		backupSchedule      string
+		backupImage         string
+		backupHostPath      string
+		checkpointNS        string
+		checkpointSelector  string
+		checkpointKubeletCA string
 	}
 
 	imageVersions = asset.DefaultImages
@@ -63,6 +70,13 @@ func (*render) Render(opts *plugin.Options, args []string) error {
 	CommandLine.StringVar(&renderOpts.cloudProvider, "cloud-provider", "", "The provider for cloud services.  Empty string for no provider")
 	CommandLine.StringVar(&renderOpts.networkProvider, "network-provider", "flannel", "CNI network provider (flannel, experimental-canal or experimental-calico).")
 	CommandLine.StringVar(&renderOpts.clusterName, "cluster-name", "", "The name of the kubernetes cluster.")
+	CommandLine.StringVar(&renderOpts.backupSchedule, "backup-schedule", "", "Cron schedule, e.g. '0 */6 * * *', of a CronJob that runs `bootkube backup` on the master nodes. No CronJob is rendered if empty.")
+	CommandLine.StringVar(&renderOpts.backupImage, "bootkube-image", "", "The bootkube image that the backup CronJob runs, which must have the `bootkube backup` subcommand, e.g. an image built from this version of bootkube. Required with --backup-schedule.")
+	CommandLine.StringVar(&renderOpts.backupHostPath, "backup-host-path", "/var/lib/bootkube/backups", "Directory on the master nodes that the backup CronJob writes its archives to. Archives are lost with the master unless it is a network filesystem or they are copied off the node.")
+
+	CommandLine.StringVar(&renderOpts.checkpointNS, "checkpoint-namespaces", "", "Comma-separated namespaces, besides kube-system, whose parent pods the pod checkpointer checkpoints, or '*' for all namespaces. The RBAC of the pod checkpointer is extended to them.")
+	CommandLine.StringVar(&renderOpts.checkpointSelector, "checkpoint-selector", "", "Label selector that parent pods must match to be checkpointed by the pod checkpointer.")
+	CommandLine.StringVar(&renderOpts.checkpointKubeletCA, "checkpoint-kubelet-ca", asset.DefaultCheckpointKubeletCA, "Absolute path on the master nodes of the CA bundle that the pod checkpointer verifies the kubelet serving certificates with. The default is the self-signed certificate that kubelets generate in their default --cert-dir. If empty, the CA of the kubeconfig is used, which signs CA-issued and rotated kubelet serving certificates.")
 
 	CommandLine.Parse(args)
 
@@ -108,6 +122,15 @@ func validateRenderOpts() error {
 	if renderOpts.networkProvider != asset.NetworkFlannel && renderOpts.networkProvider != asset.NetworkCalico && renderOpts.networkProvider != asset.NetworkCanal {
 		return errors.New("Must specify --network-provider flannel or experimental-calico or experimental-canal")
 	}
+	if renderOpts.backupSchedule != "" && renderOpts.backupImage == "" {
+		return errors.New("You must provide the --bootkube-image flag when --backup-schedule is provided, as released bootkube images have no backup subcommand.")
+	}
+	if renderOpts.backupSchedule != "" && !filepath.IsAbs(renderOpts.backupHostPath) {
+		return errors.New("--backup-host-path must be an absolute path")
+	}
+	if renderOpts.checkpointKubeletCA != "" && !filepath.IsAbs(renderOpts.checkpointKubeletCA) {
+		return errors.New("--checkpoint-kubelet-ca must be an absolute path")
+	}
 	return nil
 }
 
@@ -215,6 +238,8 @@ func flagsToAssetConfig() (c *asset.Config, err error) {
 		}
 	}
 
+	checkpointNamespaces, checkpointAllNamespaces := parseCheckpointNamespaces(renderOpts.checkpointNS)
+
 	// TODO: Find better option than asking users to make manual changes
 	if serviceNets[0].IP.String() != defaultServiceBaseIP {
 		fmt.Printf("You have selected a non-default service CIDR %s - be sure your kubelet service file uses --cluster-dns=%s\n", serviceNets[0].String(), dnsServiceIPs[0].String())
@@ -238,9 +263,35 @@ func flagsToAssetConfig() (c *asset.Config, err error) {
 		CloudProvider:   renderOpts.cloudProvider,
 		NetworkProvider: renderOpts.networkProvider,
 		Images:          imageVersions,
+		BackupSchedule:  renderOpts.backupSchedule,
+		BackupImage:     renderOpts.backupImage,
+		BackupHostPath:  renderOpts.backupHostPath,
+
+		CheckpointNamespaces:    checkpointNamespaces,
+		CheckpointAllNamespaces: checkpointAllNamespaces,
+		CheckpointSelector:      renderOpts.checkpointSelector,
+		CheckpointKubeletCA:     renderOpts.checkpointKubeletCA,
 	}, nil
 }
 
+// parseCheckpointNamespaces parses --checkpoint-namespaces. kube-system, which the pod checkpointer
+// runs in, and duplicates are dropped.
+func parseCheckpointNamespaces(s string) (namespaces []string, all bool) {
+	if strings.TrimSpace(s) == "*" {
+		return nil, true
+	}
+	seen := map[string]bool{"kube-system": true}
+	for _, ns := range strings.Split(s, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, false
+}
+
 func parseCertAndPrivateKeyFromDisk(caCertPath, privKeyPath string) (*rsa.PrivateKey, *x509.Certificate, error) {
 	// Parse CA Private key.
 	keypem, err := ioutil.ReadFile(privKeyPath)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net"
+	"reflect"
 	"testing"
 )
 
@@ -34,3 +35,44 @@ func TestOffsetIP(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCheckpointNamespaces(t *testing.T) {
+	for _, tc := range []struct {
+		input   string
+		want    []string
+		wantAll bool
+	}{
+		{input: "", want: nil},
+		{input: "monitoring", want: []string{"monitoring"}},
+		{input: "monitoring, logging,kube-system,monitoring", want: []string{"monitoring", "logging"}},
+		{input: "*", wantAll: true},
+	} {
+		got, all := parseCheckpointNamespaces(tc.input)
+		if !reflect.DeepEqual(got, tc.want) || all != tc.wantAll {
+			t.Errorf("parseCheckpointNamespaces(%q) = %q, %t, want: %q, %t", tc.input, got, all, tc.want, tc.wantAll)
+		}
+	}
+}
+
+func TestValidateBackupOpts(t *testing.T) {
+	saved := renderOpts
+	defer func() { renderOpts = saved }()
+
+	for _, tc := range []struct {
+		desc                        string
+		schedule, image, backupPath string
+		wantErr                     bool
+	}{
+		{desc: "no backups"},
+		{desc: "backups", schedule: "0 */6 * * *", image: "quay.io/example/bootkube:backup", backupPath: "/var/lib/bootkube/backups"},
+		{desc: "no image", schedule: "0 */6 * * *", backupPath: "/var/lib/bootkube/backups", wantErr: true},
+		{desc: "relative path", schedule: "0 */6 * * *", image: "quay.io/example/bootkube:backup", backupPath: "backups", wantErr: true},
+	} {
+		renderOpts = saved
+		renderOpts.etcdServers, renderOpts.apiServers, renderOpts.networkProvider = "https://127.0.0.1:2379", "https://127.0.0.1:6443", "flannel"
+		renderOpts.backupSchedule, renderOpts.backupImage, renderOpts.backupHostPath = tc.schedule, tc.image, tc.backupPath
+		if err := validateRenderOpts(); (err != nil) != tc.wantErr {
+			t.Errorf("%s: validateRenderOpts() = %v, want error: %t", tc.desc, err, tc.wantErr)
+		}
+	}
+}
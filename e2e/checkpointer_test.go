@@ -401,6 +401,8 @@ spec:
         - --checkpoint-grace-period=5s
         - --lock-file=/var/run/lock/test-checkpointer.lock
         - --kubeconfig=/etc/checkpointer/kubeconfig
+        - --kubelet-certificate-authority=/var/lib/kubelet/pki/kubelet.crt
+        - --kubelet-server-name=$(NODE_NAME)
         env:
         - name: NODE_NAME
           valueFrom:
@@ -422,6 +424,9 @@ spec:
           name: etc-kubernetes
         - mountPath: /var/run
           name: var-run
+        - mountPath: /var/lib/kubelet/pki/kubelet.crt
+          name: kubelet-ca
+          readOnly: true
       serviceAccountName: test-checkpointer
       hostNetwork: true
       restartPolicy: Always
@@ -435,6 +440,10 @@ spec:
       - name: var-run
         hostPath:
           path: /var/run
+      - name: kubelet-ca
+        hostPath:
+          path: /var/lib/kubelet/pki/kubelet.crt
+          type: File
 `
 
 var nginxDS = []byte(`apiVersion: extensions/v1beta1
@@ -63,28 +63,35 @@ func (b *bootstrapControlPlane) Teardown() error {
 	return nil
 }
 
-// copyFile copies a single file from src to dst. Returns an error if overwrite is true and dst
-// exists, or if any I/O error occurs during copying.
+// copyFile copies a single file from src to dst, preserving its permissions. Returns an error if
+// overwrite is true and dst exists, or if any I/O error occurs during copying.
 func copyFile(src, dst string, overwrite bool) error {
 	flags := os.O_CREATE | os.O_WRONLY
 	if !overwrite {
 		flags |= os.O_EXCL
 	}
 
-	dstfile, err := os.OpenFile(dst, flags, os.FileMode(0600))
+	srcfile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcfile.Close()
+	info, err := srcfile.Stat()
 	if err != nil {
 		return err
 	}
-	defer dstfile.Close()
 
-	srcfile, err := os.Open(src)
+	dstfile, err := os.OpenFile(dst, flags, os.FileMode(0600))
 	if err != nil {
 		return err
 	}
-	defer srcfile.Close()
+	defer dstfile.Close()
 
-	_, err = io.Copy(dstfile, srcfile)
-	return err
+	if _, err = io.Copy(dstfile, srcfile); err != nil {
+		return err
+	}
+	// Secret and configMap files recovered from volumes with a defaultMode rely on this.
+	return dstfile.Chmod(info.Mode().Perm())
 }
 
 // copyDirectory copies srcDir to dstDir recursively. It returns the paths of files (not
@@ -8,16 +8,23 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 )
 
-const (
+// The checkpoint paths are variables only so that simulations can redirect them to a sandbox.
+var (
 	activeCheckpointPath    = "/etc/kubernetes/manifests"
 	inactiveCheckpointPath  = "/etc/kubernetes/inactive-manifests"
 	checkpointSecretPath    = "/etc/kubernetes/checkpoint-secrets"
 	checkpointConfigMapPath = "/etc/kubernetes/checkpoint-configmaps"
+	checkpointVolumePath    = "/etc/kubernetes/checkpoint-volumes"
+)
 
+const (
 	shouldCheckpointAnnotation = "checkpointer.alpha.coreos.com/checkpoint"    // = "true"
 	checkpointParentAnnotation = "checkpointer.alpha.coreos.com/checkpoint-of" // = "podName"
 	podSourceAnnotation        = "kubernetes.io/config.source"
@@ -25,7 +32,7 @@ const (
 	shouldCheckpoint = "true"
 	podSourceFile    = "file"
 
-	defaultPollingFrequency  = 5 * time.Second
+	defaultResyncPeriod      = 5 * time.Second
 	defaultCheckpointTimeout = 1 * time.Minute
 
 	rootUID = 0
@@ -33,7 +40,6 @@ const (
 )
 
 var (
-	lastCheckpoint        time.Time
 	checkpointGracePeriod time.Duration
 )
 
@@ -43,13 +49,68 @@ type Options struct {
 	CheckpointerPod CheckpointerPod
 	// KubeConfig is a valid kubeconfig for communicating with the APIServer.
 	KubeConfig *restclient.Config
+	// Namespaces are the namespaces, besides the namespace of the checkpointer, whose parent pods
+	// are checkpointed. AllNamespaces selects the parent pods of all namespaces instead.
+	Namespaces    []string
+	AllNamespaces bool
+	// ParentSelector is a label selector that parent pods must match to be checkpointed. All parent
+	// pods in the selected namespaces are checkpointed if it is empty.
+	ParentSelector string
+	// KubeletCAFile is the CA bundle that the kubelet's serving certificate is verified with. The CA
+	// of KubeConfig is used if it is empty.
+	KubeletCAFile string
+	// KubeletServerName is the name that the kubelet's serving certificate is verified for, e.g. the
+	// node name. The address that the kubelet is reached at is used if it is empty.
+	KubeletServerName string
+	// KubeletInsecureFallback enables querying the kubelet without verifying its serving
+	// certificate, and then on its read-only port, if the kubelet cannot be queried securely.
+	KubeletInsecureFallback bool
 	// RemoteRuntimeEndpoint is the location of the CRI GRPC endpoint.
 	RemoteRuntimeEndpoint string
 	// RuntimeRequestTimeout is the timeout that is used for requests to the RemoteRuntimeEndpoint.
 	RuntimeRequestTimeout time.Duration
+	// RuntimeTLSCAFile is the CA bundle that the serving certificate of a tcp RemoteRuntimeEndpoint
+	// is verified with, and RuntimeTLSCertFile and RuntimeTLSKeyFile the client certificate that is
+	// presented to it. Connections to the runtime use TLS if any of them is set.
+	RuntimeTLSCAFile   string
+	RuntimeTLSCertFile string
+	RuntimeTLSKeyFile  string
 	// CheckpointGracePeriod is the timeout that is used for cleaning up checkpoints when the parent
 	// pod is deleted.
 	CheckpointGracePeriod time.Duration
+	// ResyncPeriod is the interval at which the checkpointer reconciles against the kubelet and
+	// CRI shim when no watched object changes. Defaults to 5 seconds.
+	ResyncPeriod time.Duration
+	// ListenAddress is the address on which /healthz and /metrics are served. They are not served
+	// if it is empty.
+	ListenAddress string
+	// SecretEncryptionKeyFile is the node-local key that checkpointed secrets and service account
+	// tokens are encrypted with. It is created if it does not exist. Secrets are stored in
+	// plaintext if it is empty.
+	SecretEncryptionKeyFile string
+	// DecryptedSecretsPath is the tmpfs directory that the secrets of active checkpoints are
+	// decrypted to when SecretEncryptionKeyFile is set. Defaults to DefaultDecryptedSecretsPath.
+	DecryptedSecretsPath string
+	// RecordInputsFile is a file that the inputs and actions of every iteration are recorded to, so
+	// that they can be replayed in a simulation. Nothing is recorded if it is empty.
+	RecordInputsFile string
+	// PeerListenAddress is the address on which the heartbeat for the checkpointers on the other
+	// masters is served. If it is set, checkpoints are only activated while the apiserver is
+	// unavailable if a quorum of the masters, including this one, cannot reach it either.
+	PeerListenAddress string
+	// Peers are the host:port PeerListenAddresses of the checkpointers on the other masters.
+	Peers []string
+	// PeerSelfActivate allows activating checkpoints when no peer is reachable.
+	PeerSelfActivate bool
+	// PeerTLSCAFile, PeerTLSCertFile and PeerTLSKeyFile are the CA and the certificate that
+	// heartbeats are exchanged with over mutual TLS. They are required with PeerListenAddress. The
+	// certificate of each master must be valid for its address in the Peers of the others.
+	PeerTLSCAFile   string
+	PeerTLSCertFile string
+	PeerTLSKeyFile  string
+	// StatusNamespace is the namespace that the status of the checkpoints is published in, see
+	// publishStatus. Defaults to the namespace of the checkpointer.
+	StatusNamespace string
 }
 
 // CheckpointerPod holds information about this checkpointer pod.
@@ -62,13 +123,67 @@ type CheckpointerPod struct {
 	PodNamespace string
 }
 
+// kubeletSource returns the parent pods that the kubelet knows of. It is implemented by
+// kubeletClient.
+type kubeletSource interface {
+	localParentPods() map[string]*corev1.Pod
+}
+
+// runtimeSource returns the pods that the container runtime runs, or nil if it cannot be queried.
+// It is implemented by remoteRuntimeService.
+type runtimeSource interface {
+	localRunningPods() map[string]*corev1.Pod
+}
+
+// apiSource returns the parent pods scheduled to this node, and the secrets and configMaps they
+// reference, from the apiserver. It is implemented by apiWatcher.
+type apiSource interface {
+	parentPods() (bool, map[string]*corev1.Pod)
+	watchObjects(keys map[objectKey]bool)
+	takeChangedObjects() map[objectKey]bool
+	getSecret(namespace, name string) (*corev1.Secret, error)
+	getConfigMap(namespace, name string) (*corev1.ConfigMap, error)
+}
+
+// peerSource reports whether the apiserver is unavailable only to this node, in which case
+// checkpoints must not be activated. It is implemented by peerProber.
+type peerSource interface {
+	partitioned(apiAvailable bool) bool
+}
+
 // checkpointer holds state used by the checkpointer to perform its duties.
 type checkpointer struct {
 	apiserver       kubernetes.Interface
-	kubelet         *kubeletClient
-	cri             *remoteRuntimeService
+	kubelet         kubeletSource
+	cri             runtimeSource
+	watcher         apiSource
+	clock           clock.Clock
 	checkpointerPod CheckpointerPod
+	parents         parentSelector
 	checkpoints     checkpoints
+	resyncPeriod    time.Duration
+	health          *health
+	secrets         *secretStore
+	events          *eventRecorder
+	// lastWrites holds the time each checkpoint was last written, see nodeStatus().
+	lastWrites map[string]time.Time
+	// statusNamespace is the namespace that the status is published in.
+	statusNamespace string
+	// statusOwner references the node, so that its status is deleted along with it.
+	statusOwner *metav1.OwnerReference
+	// publishedStatus is the status that was last published, see publishStatus().
+	publishedStatus *NodeStatus
+
+	journal           *checkpointJournal
+	activeManifests   *manifestDir
+	inactiveManifests *manifestDir
+	// lastCheckpoint is when the secrets and configMaps of all checkpoints were last refreshed.
+	lastCheckpoint time.Time
+	// recorder records the inputs of every iteration if it is set, see Options.RecordInputsFile.
+	recorder *inputRecorder
+	// peers confirms apiserver outages with the other masters if it is set, see
+	// Options.PeerListenAddress.
+	peers peerSource
 }
 
 // Run instantiates and starts a new checkpointer. Returns error if there was a problem creating
@@ -76,71 +191,223 @@ type checkpointer struct {
 func Run(opts Options) error {
 	apiserver := kubernetes.NewForConfigOrDie(opts.KubeConfig)
 
-	kubelet, err := newKubeletClient(opts.KubeConfig)
+	parents, err := newParentSelector(opts)
+	if err != nil {
+		return err
+	}
+
+	kubelet, err := newKubeletClient(opts.KubeConfig, newKubeletConfig(opts))
 	if err != nil {
 		return fmt.Errorf("failed to load kubelet client: %v", err)
 	}
 
 	// Open a GRPC connection to the CRI shim
-	cri, err := newRemoteRuntimeService(opts.RemoteRuntimeEndpoint, opts.RuntimeRequestTimeout)
+	runtimeConfig, err := newRuntimeConfig(opts)
+	if err != nil {
+		return fmt.Errorf("failed to load CRI client configuration: %v", err)
+	}
+	cri, err := newRemoteRuntimeService(runtimeConfig)
 	if err != nil {
 		return fmt.Errorf("failed to connect to CRI server: %v", err)
 	}
 
 	checkpointGracePeriod = opts.CheckpointGracePeriod
 
+	resyncPeriod := opts.ResyncPeriod
+	if resyncPeriod <= 0 {
+		resyncPeriod = defaultResyncPeriod
+	}
+
+	var secrets *secretStore
+	if opts.SecretEncryptionKeyFile != "" {
+		decryptedPath := opts.DecryptedSecretsPath
+		if decryptedPath == "" {
+			decryptedPath = DefaultDecryptedSecretsPath
+		}
+		if secrets, err = newSecretStore(opts.SecretEncryptionKeyFile, decryptedPath); err != nil {
+			return fmt.Errorf("failed to set up secret encryption: %v", err)
+		}
+	}
+
+	var recorder *inputRecorder
+	if opts.RecordInputsFile != "" {
+		if recorder, err = newInputRecorder(opts.RecordInputsFile); err != nil {
+			return fmt.Errorf("failed to record inputs: %v", err)
+		}
+	}
+
+	statusNamespace := opts.StatusNamespace
+	if statusNamespace == "" {
+		statusNamespace = opts.CheckpointerPod.PodNamespace
+	}
+
+	glog.Infof("Checkpointing parent pods in namespaces %q", parents.watchedNamespaces())
+	watcher := newAPIWatcher(apiserver, opts.CheckpointerPod.NodeName, parents.watchedNamespaces())
 	cp := &checkpointer{
 		apiserver:       apiserver,
 		kubelet:         kubelet,
 		cri:             cri,
+		watcher:         watcher,
+		clock:           clock.RealClock{},
 		checkpointerPod: opts.CheckpointerPod,
+		parents:         parents,
+		resyncPeriod:    resyncPeriod,
+		health: &health{
+			// An iteration makes up to three requests to the kubelet, if the insecure fallback is
+			// enabled, and two to the CRI shim, so allow for all of them to time out before
+			// considering the loop stuck.
+			maxSyncAge: 3*resyncPeriod + 3*kubeletRequestTimeout + 2*opts.RuntimeRequestTimeout,
+			lastSync:   time.Now(),
+		},
+		secrets: secrets,
+		events:  newEventRecorder(apiserver, opts.CheckpointerPod.NodeName),
+
+		statusNamespace: statusNamespace,
+
+		journal:           &checkpointJournal{path: checkpointJournalPath},
+		activeManifests:   &manifestDir{path: activeCheckpointPath},
+		inactiveManifests: &manifestDir{path: inactiveCheckpointPath},
+		recorder:          recorder,
+	}
+	if opts.ListenAddress != "" {
+		go serveHealthAndMetrics(opts.ListenAddress, cp.health)
+	}
+	if opts.PeerListenAddress != "" {
+		peers := newPeerProber(opts.CheckpointerPod.NodeName, opts.Peers, opts.PeerSelfActivate, resyncPeriod)
+		if err := peers.loadTLS(opts.PeerTLSCAFile, opts.PeerTLSCertFile, opts.PeerTLSKeyFile); err != nil {
+			return fmt.Errorf("failed to load peer TLS configuration: %v", err)
+		}
+		go peers.serve(opts.PeerListenAddress)
+		go peers.run(make(chan struct{}))
+		cp.peers = peers
 	}
-	cp.run()
+	cp.run(watcher)
 
 	return nil
 }
 
-// run is the main checkpointing loop.
-func (c *checkpointer) run() {
+// run is the main checkpointing loop. It syncs whenever a watched parent pod, secret or configMap
+// changes, and at least once every resync period, because the kubelet and CRI shim cannot be
+// watched and the apiserver may be unavailable.
+func (c *checkpointer) run(watcher *apiWatcher) {
+	if err := c.start(); err != nil {
+		glog.Fatal(err)
+	}
+	watcher.start(make(chan struct{}))
+
+	ticker := time.NewTicker(c.resyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-watcher.changed:
+		case <-ticker.C:
+		}
+		c.sync()
+	}
+}
+
+// start prepares the checkpointer for its first iteration.
+func (c *checkpointer) start() error {
 	// Make sure the inactive checkpoint path exists.
 	if err := os.MkdirAll(inactiveCheckpointPath, 0700); err != nil {
-		glog.Fatalf("Could not create inactive checkpoint path: %v", err)
+		return fmt.Errorf("could not create inactive checkpoint path: %v", err)
 	}
 
-	for {
-		time.Sleep(defaultPollingFrequency)
+	c.restoreCheckpoints()
+	if c.recorder != nil {
+		c.recorder.recordStart(c)
+	}
+	return nil
+}
 
-		// We must use both the kubelet /pods endpoint and CRI shim, because /pods
-		// endpoint could have stale data. The /pods endpoint will only show the last cached
-		// status which has successfully been written to an apiserver. However, if there is
-		// no apiserver, we may get stale state (e.g. saying pod is running, when it really is
-		// not).
-		localParentPods := c.kubelet.localParentPods()
-		localRunningPods := c.cri.localRunningPods()
+// sync reconciles the checkpoints with the current state of the apiserver, kubelet and CRI shim.
+func (c *checkpointer) sync() {
+	syncStart := time.Now()
 
-		// Try to get scheduled pods from the apiserver.
-		// These will be used to GC checkpoints for parents no longer scheduled to this node.
-		// TODO(aaron): only check this every 30 seconds or so
-		apiAvailable, apiParentPods := c.getAPIParentPods(c.checkpointerPod.NodeName)
+	// We must use both the kubelet /pods endpoint and CRI shim, because /pods
+	// endpoint could have stale data. The /pods endpoint will only show the last cached
+	// status which has successfully been written to an apiserver. However, if there is
+	// no apiserver, we may get stale state (e.g. saying pod is running, when it really is
+	// not).
+	// The kubelet reports the parent pods of all namespaces, and only the selected ones are
+	// checkpointed.
+	localParentPods := c.parents.filter(c.kubelet.localParentPods())
+	localRunningPods := c.cri.localRunningPods()
 
-		// Get on disk copies of (in)active checkpoints
-		//TODO(aaron): Could be racy to load from disk each time, but much easier than trying to keep in-memory state in sync.
-		activeCheckpoints := getFileCheckpoints(activeCheckpointPath)
-		inactiveCheckpoints := getFileCheckpoints(inactiveCheckpointPath)
+	// Get scheduled pods from the apiserver watch.
+	// These will be used to GC checkpoints for parents no longer scheduled to this node.
+	apiAvailable, apiParentPods := c.watcher.parentPods()
+	apiParentPods = c.parents.filter(apiParentPods)
 
-		// Update checkpoints using the latest information from the APIs.
-		c.checkpoints.update(localRunningPods, localParentPods, apiParentPods, activeCheckpoints, inactiveCheckpoints, c.checkpointerPod)
+	// Whether the other masters can reach the apiserver is only known if peer probing is enabled.
+	partitioned := c.peers != nil && c.peers.partitioned(apiAvailable)
 
-		// Update on-disk manifests based on updated checkpoint state.
-		c.createCheckpointsForValidParents()
+	// The in-memory checkpoint states are authoritative. The on disk copies of (in)active
+	// checkpoints are only read again if they changed, and the states are reconciled with the
+	// active checkpoints if those did.
+	activeChanged, err := c.activeManifests.refresh()
+	if err != nil {
+		glog.Errorf("Failed to read active checkpoints: %v", err)
+	}
+	if _, err := c.inactiveManifests.refresh(); err != nil {
+		glog.Errorf("Failed to read inactive checkpoints: %v", err)
+	}
+	if activeChanged {
+		c.checkpoints.reconcile(c.activeManifests.pods)
+	}
+	activeCheckpoints, inactiveCheckpoints := c.activeManifests.pods, c.inactiveManifests.pods
+
+	// Update checkpoints using the latest information from the APIs.
+	c.checkpoints.update(localRunningPods, localParentPods, apiParentPods, activeCheckpoints, inactiveCheckpoints, c.checkpointerPod)
 
-		// Update checkpoint states and determine which checkpoints to start, stop, or remove.
-		start, stop, remove := c.checkpoints.process(time.Now(), apiAvailable, localRunningPods, localParentPods, apiParentPods)
+	// Update on-disk manifests based on updated checkpoint state.
+	c.createCheckpointsForValidParents()
 
-		// Handle remove at last because we may still have some work to do
-		// before removing the checkpointer itself.
-		handleStop(stop)
-		handleStart(start)
-		handleRemove(remove)
+	// Update checkpoint states and determine which checkpoints to start, stop, or remove. Removed
+	// checkpoints are forgotten by process, so keep their pods for the events.
+	now := c.clock.Now()
+	pods := c.checkpoints.pods()
+	start, stop, remove := c.checkpoints.process(now, apiAvailable, partitioned, localRunningPods, localParentPods, apiParentPods)
+	c.events.checkpointsChanged(pods, start, stop, remove)
+	if c.recorder != nil {
+		c.recorder.recordSync(recordedSync{
+			Time:             now,
+			LocalParentPods:  localParentPods,
+			LocalRunningPods: localRunningPods,
+			APIAvailable:     apiAvailable,
+			Partitioned:      partitioned,
+			APIParentPods:    apiParentPods,
+			Start:            start,
+			Stop:             stop,
+			Remove:           remove,
+		})
 	}
+
+	// Handle remove at last because we may still have some work to do
+	// before removing the checkpointer itself.
+	c.handleStop(stop, pods)
+	if c.secrets != nil {
+		// Secrets of active checkpoints are decrypted before they start, and again if a reboot
+		// cleared the tmpfs. Those of stopped checkpoints are removed once their pods terminated.
+		c.secrets.decryptCheckpoints(start, activeCheckpoints)
+	}
+	handleStart(start)
+	c.handleRemove(remove, pods)
+	c.removeTerminated(localRunningPods)
+	c.activeManifests.forget(remove)
+	c.inactiveManifests.forget(remove)
+
+	if err := c.journal.save(&c.checkpoints); err != nil {
+		glog.Errorf("Failed to save checkpoint states: %v", err)
+	}
+
+	// Events are buffered while the apiserver is unavailable, and replayed once it is back. The
+	// status is published once it is back as well.
+	if apiAvailable {
+		c.events.flush()
+		c.publishStatus(c.clock.Now())
+	}
+
+	c.checkpoints.recordStates()
+	c.health.synced(syncStart, time.Now())
 }
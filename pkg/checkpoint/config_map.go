@@ -1,14 +1,11 @@
 package checkpoint
 
 import (
-	"context"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // checkpointConfigMapVolumes ensures that all pod configMaps are checkpointed locally, then converts the configMap volume to a hostpath.
@@ -24,40 +21,20 @@ func (c *checkpointer) checkpointConfigMapVolumes(pod *corev1.Pod) (*corev1.Pod,
 			continue
 		}
 
-		_, err := c.checkpointConfigMap(pod.Namespace, pod.Name, v.ConfigMap.Name, uid, gid)
+		files, err := c.configMapFiles(pod.Namespace, v.ConfigMap.Name, v.ConfigMap.Items, v.ConfigMap.DefaultMode, v.ConfigMap.Optional)
 		if err != nil {
 			return nil, fmt.Errorf("failed to checkpoint configMap for pod %s/%s: %v", pod.Namespace, pod.Name, err)
 		}
-	}
-	return pod, nil
-}
-
-// checkpointConfigMap will locally store configMap data.
-// The path to the configMap data becomes: checkpointConfigMapPath/namespace/podname/configMapName/configMap.file
-// Where each "configMap.file" is a key from the configMap.Data field.
-func (c *checkpointer) checkpointConfigMap(namespace, podName, configMapName string, uid, gid int) (string, error) {
-	configMap, err := c.apiserver.CoreV1().ConfigMaps(namespace).Get(context.TODO(), configMapName, metav1.GetOptions{})
-	if err != nil {
-		return "", fmt.Errorf("failed to retrieve configMap %s/%s: %v", namespace, configMapName, err)
-	}
-
-	basePath := configMapPath(namespace, podName, configMapName)
-	if err := os.MkdirAll(basePath, 0700); err != nil {
-		return "", fmt.Errorf("failed to create configMap checkpoint path %s: %v", basePath, err)
-	}
-	if err := os.Chown(basePath, uid, gid); err != nil {
-		return "", fmt.Errorf("failed to chown configMap checkpoint path %s: %v", basePath, err)
-	}
-
-	// TODO(aaron): No need to store if already exists
-	for f, d := range configMap.Data {
-		if err := writeAndAtomicRename(filepath.Join(basePath, f), []byte(d), uid, gid, 0600); err != nil {
-			return "", fmt.Errorf("failed to write configMap %s: %v", configMap.Name, err)
+		if err := writeVolumeFiles(configMapVolumePath(pod.Namespace, pod.Name, v), files, uid, gid, nil); err != nil {
+			return nil, fmt.Errorf("failed to checkpoint configMap for pod %s/%s: %v", pod.Namespace, pod.Name, err)
 		}
 	}
-	return basePath, nil
+	return pod, nil
 }
 
+// configMapPath returns the path that a configMap volume without items is checkpointed to:
+// checkpointConfigMapPath/namespace/podname/configMapName/configMap.file
+// Where each "configMap.file" is a key from the configMap.Data or configMap.BinaryData fields.
 func configMapPath(namespace, podName, configMapName string) string {
 	return filepath.Join(checkpointConfigMapPath, namespace, podName, configMapName)
 }
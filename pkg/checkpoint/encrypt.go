@@ -0,0 +1,307 @@
+package checkpoint
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultDecryptedSecretsPath is the tmpfs directory that secrets of active checkpoints are
+	// decrypted to. /var/run is a tmpfs, or a link to one, on most distributions.
+	DefaultDecryptedSecretsPath = "/var/run/checkpoint-secrets"
+
+	// secretKeySize is the size of the AES-256 key that encrypts checkpointed secrets.
+	secretKeySize = 32
+)
+
+// encryptedFileHeader starts every encrypted checkpoint file, followed by the nonce and the
+// AES-GCM sealed data.
+var encryptedFileHeader = []byte("k8s-checkpoint-aesgcm-v1\n")
+
+// secretStore encrypts checkpointed secrets with a node-local key, and decrypts them to a tmpfs for
+// active checkpoints, so that secret data is never stored on disk in plaintext.
+type secretStore struct {
+	aead cipher.AEAD
+	// decryptedPath is the tmpfs directory that secrets are decrypted to.
+	decryptedPath string
+}
+
+// newSecretStore returns a secretStore that uses the key in keyFile, which is created if it does
+// not exist. decryptedPath must be on a tmpfs.
+func newSecretStore(keyFile, decryptedPath string) (*secretStore, error) {
+	key, err := loadOrCreateSecretKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(decryptedPath, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create decrypted secrets path %s: %v", decryptedPath, err)
+	}
+	if err := checkTmpfs(decryptedPath); err != nil {
+		return nil, fmt.Errorf("decrypted secrets path %s: %v", decryptedPath, err)
+	}
+	return newSecretStoreWithKey(key, decryptedPath)
+}
+
+func newSecretStoreWithKey(key []byte, decryptedPath string) (*secretStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretStore{aead: aead, decryptedPath: decryptedPath}, nil
+}
+
+// loadOrCreateSecretKey reads the key in path, or writes a new random key to it if it does not
+// exist.
+func loadOrCreateSecretKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		glog.Infof("Creating secret encryption key %s", path)
+		key = make([]byte, secretKeySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, fmt.Errorf("failed to create secret encryption key: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create secret encryption key: %v", err)
+		}
+		if err := writeAndAtomicRename(path, key, rootUID, rootGID, 0400); err != nil {
+			return nil, fmt.Errorf("failed to write secret encryption key %s: %v", path, err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret encryption key %s: %v", path, err)
+	}
+	if len(key) != secretKeySize {
+		return nil, fmt.Errorf("secret encryption key %s must be %d bytes, found %d", path, secretKeySize, len(key))
+	}
+	return key, nil
+}
+
+// encrypt returns the encrypted form of data.
+func (s *secretStore) encrypt(data []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte(nil), encryptedFileHeader...), nonce...)
+	return s.aead.Seal(out, nonce, data, nil), nil
+}
+
+// decrypt returns the plaintext of data. Data that is not encrypted is returned as is, so that
+// checkpoints written before encryption was enabled keep working until they are updated.
+func (s *secretStore) decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedFileHeader) {
+		return data, nil
+	}
+	data = data[len(encryptedFileHeader):]
+	if len(data) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	nonce, sealed := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	return s.aead.Open(nil, nonce, sealed, nil)
+}
+
+// sameContent returns true if the checkpointed file content existing holds data. Once encryption
+// is enabled, plaintext files never hold the same content, so that they are encrypted.
+func (s *secretStore) sameContent(existing, data []byte) bool {
+	if s == nil {
+		return bytes.Equal(existing, data)
+	}
+	if !bytes.HasPrefix(existing, encryptedFileHeader) {
+		return false
+	}
+	plaintext, err := s.decrypt(existing)
+	return err == nil && bytes.Equal(plaintext, data)
+}
+
+// readFile reads a checkpointed file, decrypting it if needed.
+func (s *secretStore) readFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || s == nil {
+		return data, err
+	}
+	return s.decrypt(data)
+}
+
+// isEncryptedVolume returns true if a volume of a parent pod contains secret data: secrets or
+// service account tokens.
+func isEncryptedVolume(v *corev1.Volume) bool {
+	if v.Secret != nil {
+		return true
+	}
+	if v.Projected != nil {
+		for _, source := range v.Projected.Sources {
+			if source.Secret != nil || source.ServiceAccountToken != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// useDecryptedPaths points the hostPaths of the encrypted volumes of a sanitized checkpoint to the
+// paths that they are decrypted to. parent is the pod that the checkpoint was created from.
+func (s *secretStore) useDecryptedPaths(parent, cp *corev1.Pod) {
+	for i := range parent.Spec.Volumes {
+		if !isEncryptedVolume(&parent.Spec.Volumes[i]) {
+			continue
+		}
+		v := &cp.Spec.Volumes[i]
+		if v.HostPath == nil {
+			continue
+		}
+		if p, ok := s.decryptedPathOf(v.HostPath.Path); ok {
+			v.HostPath.Path = p
+		}
+	}
+}
+
+// decryptedPathOf returns the path that an encrypted checkpoint path is decrypted to.
+func (s *secretStore) decryptedPathOf(encryptedPath string) (string, bool) {
+	for _, root := range []string{checkpointSecretPath, checkpointVolumePath} {
+		if rel, err := filepath.Rel(root, encryptedPath); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join(s.decryptedPath, filepath.Base(root), rel), true
+		}
+	}
+	return "", false
+}
+
+// encryptedPathOf returns the encrypted checkpoint path that decryptedPath is decrypted from.
+func (s *secretStore) encryptedPathOf(decryptedPath string) (string, bool) {
+	for _, root := range []string{checkpointSecretPath, checkpointVolumePath} {
+		dir := filepath.Join(s.decryptedPath, filepath.Base(root))
+		if rel, err := filepath.Rel(dir, decryptedPath); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join(root, rel), true
+		}
+	}
+	return "", false
+}
+
+// decryptCheckpoints decrypts the encrypted volumes of the checkpoints that are about to start and
+// of the active checkpoints. Decrypted files are only rewritten if they are missing or older than
+// the encrypted ones, which also restores them after a reboot clears the tmpfs.
+func (s *secretStore) decryptCheckpoints(start []string, activeCheckpoints map[string]*corev1.Pod) {
+	pods := make(map[string]*corev1.Pod, len(activeCheckpoints)+len(start))
+	for id, pod := range activeCheckpoints {
+		pods[id] = pod
+	}
+	for _, id := range start {
+		pod, err := readCheckpointManifest(podFullNameToInactiveCheckpointPath(id))
+		if err != nil {
+			glog.Errorf("Failed to read checkpoint %s to decrypt its secrets: %v", id, err)
+			continue
+		}
+		pods[id] = pod
+	}
+
+	for id, pod := range pods {
+		if err := s.decryptCheckpoint(pod); err != nil {
+			glog.Errorf("Failed to decrypt secrets of checkpoint %s: %v", id, err)
+		}
+	}
+}
+
+// decryptCheckpoint decrypts the encrypted volumes of the checkpoint pod. All volumes are
+// decrypted even if one fails, and the first error is returned.
+func (s *secretStore) decryptCheckpoint(pod *corev1.Pod) error {
+	uid, gid, err := podUserAndGroup(pod)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, v := range pod.Spec.Volumes {
+		if v.HostPath == nil {
+			continue
+		}
+		src, ok := s.encryptedPathOf(v.HostPath.Path)
+		if !ok {
+			continue
+		}
+		if err := s.decryptDir(src, v.HostPath.Path, uid, gid); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to decrypt volume %s: %v", v.Name, err)
+		}
+	}
+	return firstErr
+}
+
+// decryptDir decrypts the files below src to dst with the same modes, and removes the files from dst
+// that were removed from src.
+func (s *secretStore) decryptDir(src, dst string, uid, gid int) error {
+	err := filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p == src {
+			// Missing optional secrets are not checkpointed.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			return os.Chown(target, uid, gid)
+		}
+		// Skip temporary files of writeAndAtomicRename.
+		if strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+		if dfi, err := os.Stat(target); err == nil && !dfi.ModTime().Before(fi.ModTime()) {
+			// Changing the mode of a checkpointed file does not change its modification time.
+			return os.Chmod(target, fi.Mode().Perm())
+		}
+		data, err := s.readFile(p)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %v", p, err)
+		}
+		return writeAndAtomicRename(target, data, uid, gid, fi.Mode().Perm())
+	})
+	if err != nil {
+		return err
+	}
+	return removeStaleFiles(dst, func(rel string) bool {
+		_, err := os.Lstat(filepath.Join(src, rel))
+		return err == nil
+	})
+}
+
+// podFullNameToDecryptedPaths returns the paths that the secrets of a checkpoint are decrypted to.
+func (s *secretStore) podFullNameToDecryptedPaths(id string) []string {
+	var paths []string
+	for _, p := range []string{podFullNameToSecretPath(id), podFullNameToVolumePath(id)} {
+		if d, ok := s.decryptedPathOf(p); ok {
+			paths = append(paths, d)
+		}
+	}
+	return paths
+}
+
+// removeDecrypted removes the decrypted secrets of checkpoints that are stopped or removed.
+func (s *secretStore) removeDecrypted(ids []string) {
+	for _, id := range ids {
+		for _, p := range s.podFullNameToDecryptedPaths(id) {
+			if err := os.RemoveAll(p); err != nil {
+				glog.Errorf("Failed to remove decrypted secrets %s: %v", p, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package checkpoint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/kubernetes-sigs/bootkube/pkg/projection"
+)
+
+func newTestSecretStore(t *testing.T) *secretStore {
+	s, err := newSecretStoreWithKey(bytes.Repeat([]byte{1}, secretKeySize), "/var/run/checkpoint-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSecretStoreEncrypt(t *testing.T) {
+	s := newTestSecretStore(t)
+	plaintext := []byte("service-account-key")
+
+	encrypted, err := s.encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("encrypt() = %v, want: nil", err)
+	}
+	if bytes.Contains(encrypted, plaintext) {
+		t.Errorf("encrypt() = %q, contains the plaintext", encrypted)
+	}
+	if got, err := s.decrypt(encrypted); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("decrypt() = %q, %v, want: %q, nil", got, err, plaintext)
+	}
+
+	// Plaintext checkpoints from before encryption was enabled are returned as is.
+	if got, err := s.decrypt(plaintext); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("decrypt() of plaintext = %q, %v, want: %q, nil", got, err, plaintext)
+	}
+
+	encrypted[len(encrypted)-1] ^= 1
+	if _, err := s.decrypt(encrypted); err == nil {
+		t.Errorf("decrypt() of modified data = nil, want: non-nil")
+	}
+}
+
+func TestLoadOrCreateSecretKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys", "checkpoint.key")
+	created, err := loadOrCreateSecretKey(path)
+	if err != nil || len(created) != secretKeySize {
+		t.Fatalf("loadOrCreateSecretKey() = %d bytes, %v, want: %d bytes, nil", len(created), err, secretKeySize)
+	}
+	if loaded, err := loadOrCreateSecretKey(path); err != nil || !bytes.Equal(loaded, created) {
+		t.Errorf("loadOrCreateSecretKey() of an existing key = %v, %v, want: the created key, nil", loaded, err)
+	}
+
+	short := filepath.Join(dir, "short.key")
+	if err := ioutil.WriteFile(short, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOrCreateSecretKey(short); err == nil {
+		t.Errorf("loadOrCreateSecretKey() of a short key = nil, want: non-nil")
+	}
+}
+
+func TestUseDecryptedPaths(t *testing.T) {
+	s := newTestSecretStore(t)
+	parent := &v1.Pod{Spec: v1.PodSpec{Volumes: []v1.Volume{
+		{Name: "secret", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "secret"}}},
+		{Name: "token", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{Sources: []v1.VolumeProjection{
+			{ServiceAccountToken: &v1.ServiceAccountTokenProjection{Path: "token"}},
+		}}}},
+		{Name: "configmap", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "configmap"}}}},
+	}}}
+	cp := &v1.Pod{Spec: v1.PodSpec{Volumes: []v1.Volume{
+		{Name: "secret", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/etc/kubernetes/checkpoint-secrets/kube-system/pod/secret"}}},
+		{Name: "token", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/etc/kubernetes/checkpoint-volumes/kube-system/pod/token"}}},
+		{Name: "configmap", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/etc/kubernetes/checkpoint-configmaps/kube-system/pod/configmap"}}},
+	}}}
+	s.useDecryptedPaths(parent, cp)
+
+	for i, want := range []string{
+		"/var/run/checkpoint-secrets/checkpoint-secrets/kube-system/pod/secret",
+		"/var/run/checkpoint-secrets/checkpoint-volumes/kube-system/pod/token",
+		"/etc/kubernetes/checkpoint-configmaps/kube-system/pod/configmap",
+	} {
+		if got := cp.Spec.Volumes[i].HostPath.Path; got != want {
+			t.Errorf("volume %s path = %s, want: %s", cp.Spec.Volumes[i].Name, got, want)
+		}
+	}
+
+	want := "/etc/kubernetes/checkpoint-volumes/kube-system/pod/token"
+	if got, ok := s.encryptedPathOf(cp.Spec.Volumes[1].HostPath.Path); !ok || got != want {
+		t.Errorf("encryptedPathOf() = %s, %t, want: %s, true", got, ok, want)
+	}
+}
+
+func TestDecryptDir(t *testing.T) {
+	s := newTestSecretStore(t)
+	dir, err := ioutil.TempDir("", "decrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "encrypted"), filepath.Join(dir, "decrypted")
+	files := map[string]projection.File{"tls.key": {Data: []byte("key"), Mode: 0640}, "dir/tls.crt": {Data: []byte("crt")}}
+	if err := writeVolumeFiles(src, files, os.Getuid(), os.Getgid(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.decryptDir(src, dst, os.Getuid(), os.Getgid()); err != nil {
+		t.Fatalf("decryptDir() = %v, want: nil", err)
+	}
+	for p, want := range files {
+		got, err := ioutil.ReadFile(filepath.Join(dst, p))
+		if err != nil || !bytes.Equal(got, want.Data) {
+			t.Errorf("decrypted file %s = %q, %v, want: %q, nil", p, got, err, want.Data)
+		}
+	}
+	// Decrypted files keep the modes of the checkpointed files.
+	if fi, err := os.Stat(filepath.Join(dst, "tls.key")); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("decrypted file tls.key = %v, %v, want: mode %v", fi, err, os.FileMode(0640))
+	}
+
+	// Files that were removed from the checkpoint are removed from the decrypted files.
+	delete(files, "dir/tls.crt")
+	if err := writeVolumeFiles(src, files, os.Getuid(), os.Getgid(), s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.decryptDir(src, dst, os.Getuid(), os.Getgid()); err != nil {
+		t.Fatalf("decryptDir() = %v, want: nil", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "dir/tls.crt")); !os.IsNotExist(err) {
+		t.Errorf("decrypted file dir/tls.crt of a removed file was kept: %v", err)
+	}
+
+	// Volumes that were never checkpointed, like missing optional secrets, are skipped.
+	if err := s.decryptDir(filepath.Join(dir, "missing"), dst, os.Getuid(), os.Getgid()); err != nil {
+		t.Errorf("decryptDir() of a missing path = %v, want: nil", err)
+	}
+}
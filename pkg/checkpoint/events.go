@@ -0,0 +1,159 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	eventComponent = "pod-checkpointer"
+
+	// Reasons of the events that the checkpointer records.
+	reasonCheckpointActivated   = "CheckpointActivated"
+	reasonCheckpointDeactivated = "CheckpointDeactivated"
+	reasonCheckpointRemoved     = "CheckpointRemoved"
+	reasonCheckpointFailed      = "CheckpointFailed"
+
+	// maxBufferedEvents bounds the events that are kept while the apiserver is unavailable. The
+	// oldest events are dropped first.
+	maxBufferedEvents = 256
+	// eventFlushTimeout bounds the time spent sending buffered events in one sync.
+	eventFlushTimeout = 10 * time.Second
+)
+
+// eventKey identifies events that are aggregated into one, by incrementing its count.
+type eventKey struct {
+	object  corev1.ObjectReference
+	reason  string
+	message string
+}
+
+// eventRecorder records Events against parent pods and the node. Events are buffered until they
+// are flushed, so that the events of an apiserver outage, which is when checkpoints are activated,
+// are replayed once the apiserver is back. Identical events are aggregated while they are
+// buffered. It is only used from the sync loop.
+type eventRecorder struct {
+	client kubernetes.Interface
+	node   string
+	events []*corev1.Event
+	keys   map[eventKey]*corev1.Event
+}
+
+func newEventRecorder(client kubernetes.Interface, node string) *eventRecorder {
+	return &eventRecorder{
+		client: client,
+		node:   node,
+		keys:   make(map[eventKey]*corev1.Event),
+	}
+}
+
+// checkpointsChanged records the checkpoints that were started, stopped or removed. pods maps the
+// checkpoint names to their parent pods.
+func (r *eventRecorder) checkpointsChanged(pods map[string]*corev1.Pod, starts, stops, removes []string) {
+	for _, tc := range []struct {
+		names  []string
+		reason string
+		action string
+	}{
+		{starts, reasonCheckpointActivated, "Activated"},
+		{stops, reasonCheckpointDeactivated, "Deactivated"},
+		{removes, reasonCheckpointRemoved, "Removed"},
+	} {
+		for _, name := range tc.names {
+			r.podEvent(pods[name], name, corev1.EventTypeNormal, tc.reason, fmt.Sprintf("%s checkpoint of pod %s on node %s", tc.action, name, r.node))
+		}
+	}
+}
+
+// checkpointFailed records that a pod could not be checkpointed.
+func (r *eventRecorder) checkpointFailed(pod *corev1.Pod, err error) {
+	r.podEvent(pod, podFullName(pod), corev1.EventTypeWarning, reasonCheckpointFailed, fmt.Sprintf("Failed to checkpoint pod %s on node %s: %v", podFullName(pod), r.node, err))
+}
+
+// podEvent records an event against a pod and the node.
+func (r *eventRecorder) podEvent(pod *corev1.Pod, name, eventType, reason, message string) {
+	if pod == nil {
+		glog.Warningf("Not recording event %s for unknown pod %s", reason, name)
+		return
+	}
+	now := time.Now()
+	r.record(corev1.ObjectReference{
+		Kind:      "Pod",
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		UID:       pod.UID,
+	}, eventType, reason, message, now)
+	// Like the kubelet, use the node name as the UID of the node.
+	r.record(corev1.ObjectReference{
+		Kind: "Node",
+		Name: r.node,
+		UID:  types.UID(r.node),
+	}, eventType, reason, message, now)
+}
+
+func (r *eventRecorder) record(object corev1.ObjectReference, eventType, reason, message string, now time.Time) {
+	key := eventKey{object: object, reason: reason, message: message}
+	if e, ok := r.keys[key]; ok {
+		e.Count++
+		e.LastTimestamp = metav1.NewTime(now)
+		return
+	}
+
+	namespace := object.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	e := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", object.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: object,
+		Reason:         reason,
+		Message:        message,
+		Source:         corev1.EventSource{Component: eventComponent, Host: r.node},
+		FirstTimestamp: metav1.NewTime(now),
+		LastTimestamp:  metav1.NewTime(now),
+		Count:          1,
+		Type:           eventType,
+	}
+	if len(r.events) == maxBufferedEvents {
+		glog.Warningf("Dropping event %s of %s %s: too many buffered events", r.events[0].Reason, r.events[0].InvolvedObject.Kind, r.events[0].InvolvedObject.Name)
+		r.forget(r.events[0])
+		r.events = r.events[1:]
+	}
+	r.events = append(r.events, e)
+	r.keys[key] = e
+}
+
+func (r *eventRecorder) forget(e *corev1.Event) {
+	delete(r.keys, eventKey{object: e.InvolvedObject, reason: e.Reason, message: e.Message})
+}
+
+// flush sends the buffered events to the apiserver, in the order they were recorded. Events that
+// cannot be sent stay buffered for the next flush.
+func (r *eventRecorder) flush() {
+	if len(r.events) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventFlushTimeout)
+	defer cancel()
+	for len(r.events) > 0 {
+		e := r.events[0]
+		if _, err := r.client.CoreV1().Events(e.Namespace).Create(ctx, e, metav1.CreateOptions{}); err != nil {
+			recordError(errorSourceAPIServer)
+			glog.Warningf("Failed to send event %s of %s %s, will retry: %v", e.Reason, e.InvolvedObject.Kind, e.InvolvedObject.Name, err)
+			return
+		}
+		r.forget(e)
+		r.events = r.events[1:]
+	}
+}
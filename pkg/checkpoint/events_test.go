@@ -0,0 +1,90 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestEventRecorder(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	apiAvailable := false
+	client.PrependReactor("create", "events", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if !apiAvailable {
+			return true, nil, errors.New("apiserver unavailable")
+		}
+		return false, nil, nil
+	})
+	r := newEventRecorder(client, "node1")
+
+	pods := map[string]*v1.Pod{
+		"kube-system/kube-apiserver": {ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "kube-system", UID: "uid"}},
+	}
+	r.checkpointsChanged(pods, []string{"kube-system/kube-apiserver"}, nil, nil)
+	r.checkpointFailed(pods["kube-system/kube-apiserver"], errors.New("secret not found"))
+	r.checkpointFailed(pods["kube-system/kube-apiserver"], errors.New("secret not found"))
+	// Events of unknown pods are skipped.
+	r.checkpointsChanged(pods, nil, nil, []string{"kube-system/unknown"})
+
+	// Events stay buffered while the apiserver is unavailable.
+	r.flush()
+	if len(r.events) != 4 {
+		t.Fatalf("buffered events = %d, want: 4", len(r.events))
+	}
+
+	apiAvailable = true
+	r.flush()
+	if len(r.events) != 0 || len(r.keys) != 0 {
+		t.Errorf("buffered events after flush = %d, want: 0", len(r.events))
+	}
+
+	podEvents, err := client.CoreV1().Events("kube-system").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeEvents, err := client.CoreV1().Events(metav1.NamespaceDefault).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		events []v1.Event
+		kind   string
+	}{
+		{podEvents.Items, "Pod"},
+		{nodeEvents.Items, "Node"},
+	} {
+		got := make(map[string]int32)
+		for _, e := range tc.events {
+			if e.InvolvedObject.Kind != tc.kind {
+				t.Errorf("event %s involves a %s, want: %s", e.Name, e.InvolvedObject.Kind, tc.kind)
+			}
+			got[fmt.Sprintf("%s/%s", e.Type, e.Reason)] = e.Count
+		}
+		want := map[string]int32{"Normal/CheckpointActivated": 1, "Warning/CheckpointFailed": 2}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s events = %v, want: %v", tc.kind, got, want)
+		}
+	}
+}
+
+func TestEventRecorderDropsOldestEvents(t *testing.T) {
+	r := newEventRecorder(fake.NewSimpleClientset(), "node1")
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "kube-system"}}
+	for i := 0; i < maxBufferedEvents; i++ {
+		r.checkpointFailed(pod, fmt.Errorf("error %d", i))
+	}
+	if len(r.events) != maxBufferedEvents || len(r.keys) != maxBufferedEvents {
+		t.Fatalf("buffered events = %d, want: %d", len(r.events), maxBufferedEvents)
+	}
+	// Each failure records an event for the pod and the node, so the first half was dropped.
+	if got, want := r.events[0].Message, "Failed to checkpoint pod kube-system/kube-apiserver on node node1: error 128"; got != want {
+		t.Errorf("oldest buffered event = %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,359 @@
+package checkpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// InspectOptions defines the parameters of Inspect.
+type InspectOptions struct {
+	// Root is the directory that the checkpoint paths are relative to. It is "/" on a running node,
+	// and may be the mount point of the root filesystem of a node that does not boot.
+	Root string
+	// DecryptedSecretsPath is the tmpfs directory that the secrets of active checkpoints are
+	// decrypted to, if secrets are encrypted.
+	DecryptedSecretsPath string
+	// Diff shows the differences between the active checkpoints and their inactive copies.
+	Diff bool
+}
+
+// checkpointReport is what Inspect reports about a checkpoint.
+type checkpointReport struct {
+	id       string
+	inactive bool
+	active   bool
+	// files are the checkpointed secret, configMap and volume files of the checkpoint.
+	files []string
+	// missing are the hostPaths of checkpointed volumes that the checkpoint mounts, but that do not
+	// exist.
+	missing []string
+	// diff are the lines that differ between the inactive and the active manifest.
+	diff []string
+}
+
+// inspection is what Inspect reports about a node.
+type inspection struct {
+	checkpoints []*checkpointReport
+	// orphans are checkpointed files and directories that belong to no checkpoint, and leftover
+	// temporary files.
+	orphans []string
+	// errors are manifests that could not be read.
+	errors []string
+}
+
+// Inspect writes a report of the checkpoints on a node to w. It only reads local files, so that
+// it can be used when the apiserver, kubelet or checkpointer are not running.
+func Inspect(w io.Writer, opts InspectOptions) error {
+	in, err := inspect(opts)
+	if err != nil {
+		return err
+	}
+
+	if len(in.checkpoints) == 0 {
+		fmt.Fprintf(w, "No checkpoints found in %s.\n", filepath.Join(opts.Root, inactiveCheckpointPath))
+	}
+	for _, cp := range in.checkpoints {
+		state := "inactive"
+		switch {
+		case cp.active && cp.inactive:
+			state = "active"
+		case cp.active:
+			state = "active, without an inactive manifest"
+		}
+		fmt.Fprintf(w, "%s: %s\n", cp.id, state)
+		for _, f := range cp.files {
+			fmt.Fprintf(w, "  file: %s\n", f)
+		}
+		for _, f := range cp.missing {
+			fmt.Fprintf(w, "  missing: %s\n", f)
+		}
+		if len(cp.diff) > 0 {
+			fmt.Fprintf(w, "  active manifest differs from the inactive manifest:\n")
+			for _, l := range cp.diff {
+				fmt.Fprintf(w, "    %s\n", l)
+			}
+		}
+	}
+	for _, o := range in.orphans {
+		fmt.Fprintf(w, "orphaned: %s\n", o)
+	}
+	for _, e := range in.errors {
+		fmt.Fprintf(w, "error: %s\n", e)
+	}
+	return nil
+}
+
+func inspect(opts InspectOptions) (*inspection, error) {
+	root := opts.Root
+	if root == "" {
+		root = "/"
+	}
+	in := &inspection{}
+	reports := make(map[string]*checkpointReport)
+	pods := make(map[string]*corev1.Pod)
+	rawManifests := make(map[string][2][]byte)
+
+	for i, dir := range []string{inactiveCheckpointPath, activeCheckpointPath} {
+		dir = filepath.Join(root, dir)
+		fis, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read checkpoint manifests: %v", err)
+		}
+		for _, fi := range fis {
+			p := filepath.Join(dir, fi.Name())
+			if strings.HasPrefix(fi.Name(), ".") {
+				in.orphans = append(in.orphans, p+" (temporary file)")
+				continue
+			}
+			if fi.IsDir() {
+				continue
+			}
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				in.errors = append(in.errors, err.Error())
+				continue
+			}
+			pod, err := readCheckpointManifest(p)
+			if err != nil {
+				// The active manifest directory also holds other static pods, which need not be
+				// valid pods to the checkpointer.
+				if i == 0 {
+					in.errors = append(in.errors, err.Error())
+				}
+				continue
+			}
+			if !isCheckpoint(pod) {
+				continue
+			}
+			id := podFullName(pod)
+			r, ok := reports[id]
+			if !ok {
+				r = &checkpointReport{id: id}
+				reports[id] = r
+				pods[id] = pod
+			}
+			raw := rawManifests[id]
+			raw[i] = data
+			rawManifests[id] = raw
+			if i == 0 {
+				r.inactive = true
+			} else {
+				r.active = true
+			}
+		}
+	}
+
+	checkpointDirs := []string{checkpointSecretPath, checkpointConfigMapPath, checkpointVolumePath}
+	for id, r := range reports {
+		for _, dir := range []string{podFullNameToSecretPath(id), podFullNameToConfigMapPath(id), podFullNameToVolumePath(id)} {
+			files, err := listFiles(filepath.Join(root, dir))
+			if err != nil {
+				in.errors = append(in.errors, err.Error())
+			}
+			r.files = append(r.files, files...)
+		}
+
+		for _, v := range pods[id].Spec.Volumes {
+			if v.HostPath == nil {
+				continue
+			}
+			p := v.HostPath.Path
+			if !isBelow(p, append(checkpointDirs, opts.DecryptedSecretsPath)...) {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(root, p)); os.IsNotExist(err) {
+				r.missing = append(r.missing, filepath.Join(root, p))
+			}
+		}
+
+		if raw := rawManifests[id]; opts.Diff && r.active && r.inactive && !bytes.Equal(raw[0], raw[1]) {
+			r.diff = diffLines(indentJSON(raw[0]), indentJSON(raw[1]))
+		}
+		in.checkpoints = append(in.checkpoints, r)
+	}
+	sort.Slice(in.checkpoints, func(i, j int) bool { return in.checkpoints[i].id < in.checkpoints[j].id })
+
+	// Checkpointed files are stored below <dir>/<namespace>/<pod-name>.
+	for _, dir := range checkpointDirs {
+		namespaces, err := ioutil.ReadDir(filepath.Join(root, dir))
+		if err != nil && !os.IsNotExist(err) {
+			in.errors = append(in.errors, err.Error())
+		}
+		for _, ns := range namespaces {
+			podDirs, err := ioutil.ReadDir(filepath.Join(root, dir, ns.Name()))
+			if err != nil {
+				in.errors = append(in.errors, err.Error())
+				continue
+			}
+			for _, pod := range podDirs {
+				if _, ok := reports[ns.Name()+"/"+pod.Name()]; !ok {
+					in.orphans = append(in.orphans, filepath.Join(root, dir, ns.Name(), pod.Name()))
+				}
+			}
+		}
+	}
+	sort.Strings(in.orphans)
+	sort.Strings(in.errors)
+	return in, nil
+}
+
+// listFiles returns the files below dir. A missing dir has no files.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p == dir {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// isBelow returns true if p is one of dirs or below one of them.
+func isBelow(p string, dirs ...string) bool {
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, p); err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+// indentJSON returns the lines of an indented JSON document, or of data as is if it is not JSON.
+func indentJSON(data []byte) []string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err == nil {
+		data = buf.Bytes()
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// diffLines returns the lines that were removed from a, prefixed with "-", and added in b, prefixed
+// with "+", based on their longest common subsequence. Manifests are small enough for its
+// quadratic cost.
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	return diff
+}
+
+// Activate starts the inactive checkpoint of a pod, like the checkpointer does when the parent pod
+// is not running. If secrets is set, the encrypted secrets of the checkpoint are decrypted first,
+// and the checkpoint is not activated if that fails, since the pod would start without them. A
+// running checkpointer stops the checkpoint again if it decides that the checkpoint should not be
+// active.
+func Activate(id string, secrets *SecretDecrypter) error {
+	path := podFullNameToInactiveCheckpointPath(id)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read inactive checkpoint of %s: %v", id, err)
+	}
+	if secrets != nil {
+		pod, err := readCheckpointManifest(path)
+		if err != nil {
+			return fmt.Errorf("failed to read inactive checkpoint of %s: %v", id, err)
+		}
+		if err := secrets.store.decryptCheckpoint(pod); err != nil {
+			return fmt.Errorf("failed to decrypt secrets of checkpoint %s, not activating it: %v", id, err)
+		}
+	}
+	if _, err := writeManifestIfDifferent(podFullNameToActiveCheckpointPath(id), id, data); err != nil {
+		return fmt.Errorf("failed to activate checkpoint of %s: %v", id, err)
+	}
+	return nil
+}
+
+// Deactivate stops the active checkpoint of a pod. Its decrypted secrets are kept, since the pod
+// may still be terminating, see SecretDecrypter.DecryptedPaths. A running checkpointer starts the
+// checkpoint again if it decides that it should be active.
+func Deactivate(id string) error {
+	p := podFullNameToActiveCheckpointPath(id)
+	if err := os.Remove(p); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("checkpoint of %s is not active", id)
+		}
+		return fmt.Errorf("failed to deactivate checkpoint of %s: %v", id, err)
+	}
+	return nil
+}
+
+// SecretDecrypter decrypts the checkpointed secrets of checkpoints that are activated by hand.
+type SecretDecrypter struct {
+	store *secretStore
+}
+
+// NewSecretDecrypter returns a SecretDecrypter that uses the existing key in keyFile, and decrypts
+// secrets to the tmpfs directory decryptedPath.
+func NewSecretDecrypter(keyFile, decryptedPath string) (*SecretDecrypter, error) {
+	if _, err := os.Stat(keyFile); err != nil {
+		return nil, fmt.Errorf("failed to read secret encryption key: %v", err)
+	}
+	store, err := newSecretStore(keyFile, decryptedPath)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretDecrypter{store: store}, nil
+}
+
+// DecryptedPaths returns the existing directories that the secrets of a checkpoint are decrypted
+// to.
+func (d *SecretDecrypter) DecryptedPaths(id string) []string {
+	var paths []string
+	for _, p := range d.store.podFullNameToDecryptedPaths(id) {
+		if _, err := os.Stat(p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
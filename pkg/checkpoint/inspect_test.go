@@ -0,0 +1,198 @@
+package checkpoint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubernetes-sigs/bootkube/pkg/projection"
+)
+
+func TestInspect(t *testing.T) {
+	root, err := ioutil.TempDir("", "inspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeFile := func(p string, data []byte) {
+		p = filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeManifest := func(p, name, image string) {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "kube-system",
+				Annotations: map[string]string{checkpointParentAnnotation: name},
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: name, Image: image}},
+				Volumes: []v1.Volume{
+					{Name: "secrets", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: secretPath("kube-system", name, "secrets")}}},
+					{Name: "host", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/etc/ssl/certs"}}},
+				},
+			},
+		}
+		buf := &bytes.Buffer{}
+		if err := podSerializer.Encode(pod, buf); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(p, buf.Bytes())
+	}
+
+	writeManifest(podFullNameToInactiveCheckpointPath("kube-system/kube-apiserver"), "kube-apiserver", "hyperkube:v1")
+	writeManifest(podFullNameToActiveCheckpointPath("kube-system/kube-apiserver"), "kube-apiserver", "hyperkube:v2")
+	writeFile(filepath.Join(secretPath("kube-system", "kube-apiserver", "secrets"), "apiserver.key"), []byte("key"))
+	writeManifest(podFullNameToInactiveCheckpointPath("kube-system/kube-scheduler"), "kube-scheduler", "hyperkube:v1")
+	writeFile(filepath.Join(activeCheckpointPath, "etcd.yaml"), []byte("not a checkpoint"))
+	writeFile(filepath.Join(inactiveCheckpointPath, ".123"), nil)
+	writeFile(filepath.Join(podFullNameToConfigMapPath("kube-system/removed"), "config", "config.yaml"), nil)
+
+	in, err := inspect(InspectOptions{Root: root, Diff: true})
+	if err != nil {
+		t.Fatalf("inspect() = %v, want: nil", err)
+	}
+	want := []*checkpointReport{
+		{
+			id:       "kube-system/kube-apiserver",
+			inactive: true,
+			active:   true,
+			files:    []string{filepath.Join(root, checkpointSecretPath, "kube-system/kube-apiserver/secrets/apiserver.key")},
+			diff:     []string{`-        "image": "hyperkube:v1",`, `+        "image": "hyperkube:v2",`},
+		},
+		{
+			id:       "kube-system/kube-scheduler",
+			inactive: true,
+			missing:  []string{filepath.Join(root, checkpointSecretPath, "kube-system/kube-scheduler/secrets")},
+		},
+	}
+	if !reflect.DeepEqual(in.checkpoints, want) {
+		for _, cp := range in.checkpoints {
+			t.Errorf("inspect() checkpoint = %+v", cp)
+		}
+		t.Errorf("want: %+v, %+v", want[0], want[1])
+	}
+	wantOrphans := []string{
+		filepath.Join(root, checkpointConfigMapPath, "kube-system/removed"),
+		filepath.Join(root, inactiveCheckpointPath, ".123") + " (temporary file)",
+	}
+	if !reflect.DeepEqual(in.orphans, wantOrphans) || len(in.errors) != 0 {
+		t.Errorf("inspect() orphans = %q, errors = %q, want: %q, none", in.orphans, in.errors, wantOrphans)
+	}
+
+	var out bytes.Buffer
+	if err := Inspect(&out, InspectOptions{Root: root}); err != nil {
+		t.Fatalf("Inspect() = %v, want: nil", err)
+	}
+	if !strings.Contains(out.String(), "kube-system/kube-apiserver: active\n") || strings.Contains(out.String(), "differs") {
+		t.Errorf("Inspect() without diff = %q", out.String())
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, tc := range []struct {
+		a, b []string
+		want []string
+	}{
+		{a: []string{"a", "b"}, b: []string{"a", "b"}, want: nil},
+		{a: []string{"a", "b", "c"}, b: []string{"a", "x", "c", "d"}, want: []string{"-b", "+x", "+d"}},
+		{a: nil, b: []string{"a"}, want: []string{"+a"}},
+		{a: []string{"a"}, b: nil, want: []string{"-a"}},
+	} {
+		if got := diffLines(tc.a, tc.b); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("diffLines(%q, %q) = %q, want: %q", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestActivateEncrypted(t *testing.T) {
+	root, err := ioutil.TempDir("", "activate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, p := range []*string{&activeCheckpointPath, &inactiveCheckpointPath, &checkpointSecretPath} {
+		p, orig := p, *p
+		*p = filepath.Join(root, orig)
+		defer func() { *p = orig }()
+	}
+	for _, p := range []string{activeCheckpointPath, inactiveCheckpointPath} {
+		if err := os.MkdirAll(p, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const id = "kube-system/kube-apiserver"
+	s, err := newSecretStoreWithKey(bytes.Repeat([]byte{1}, secretKeySize), filepath.Join(root, "decrypted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := secretPath("kube-system", "kube-apiserver", "secrets")
+	if err := writeVolumeFiles(encrypted, map[string]projection.File{"apiserver.key": {Data: []byte("key")}}, os.Getuid(), os.Getgid(), s); err != nil {
+		t.Fatal(err)
+	}
+	decrypted, _ := s.decryptedPathOf(encrypted)
+	uid, gid := int64(os.Getuid()), int64(os.Getgid())
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "kube-system"},
+		Spec: v1.PodSpec{
+			SecurityContext: &v1.PodSecurityContext{RunAsUser: &uid, FSGroup: &gid},
+			Containers:      []v1.Container{{Name: "kube-apiserver", Image: "hyperkube:v1"}},
+			Volumes:         []v1.Volume{{Name: "secrets", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: decrypted}}}},
+		},
+	}
+	buf := &bytes.Buffer{}
+	if err := podSerializer.Encode(pod, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(podFullNameToInactiveCheckpointPath(id), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A checkpoint whose secrets cannot be decrypted is not activated.
+	wrongKey, err := newSecretStoreWithKey(bytes.Repeat([]byte{2}, secretKeySize), s.decryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Activate(id, &SecretDecrypter{store: wrongKey}); err == nil {
+		t.Errorf("Activate() with the wrong key = nil, want: non-nil")
+	}
+	if _, err := os.Stat(podFullNameToActiveCheckpointPath(id)); !os.IsNotExist(err) {
+		t.Errorf("checkpoint was activated although its secrets were not decrypted: %v", err)
+	}
+
+	d := &SecretDecrypter{store: s}
+	if err := os.RemoveAll(decrypted); err != nil {
+		t.Fatal(err)
+	}
+	if err := Activate(id, d); err != nil {
+		t.Fatalf("Activate() = %v, want: nil", err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(decrypted, "apiserver.key")); err != nil || string(got) != "key" {
+		t.Errorf("decrypted secret = %q, %v, want: %q, nil", got, err, "key")
+	}
+
+	// The decrypted secrets are kept for the terminating pod.
+	if err := Deactivate(id); err != nil {
+		t.Fatalf("Deactivate() = %v, want: nil", err)
+	}
+	if _, err := os.Stat(podFullNameToActiveCheckpointPath(id)); !os.IsNotExist(err) {
+		t.Errorf("active checkpoint was kept: %v", err)
+	}
+	if got, want := d.DecryptedPaths(id), []string{filepath.Dir(decrypted)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecryptedPaths() = %v, want: %v", got, want)
+	}
+}
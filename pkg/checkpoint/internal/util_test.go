@@ -0,0 +1,30 @@
+package internal
+
+import "testing"
+
+func TestParseEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint     string
+		wantProtocol string
+		wantAddr     string
+		wantErr      bool
+	}{
+		{endpoint: "unix:///run/containerd/containerd.sock", wantProtocol: "unix", wantAddr: "/run/containerd/containerd.sock"},
+		{endpoint: "tcp://localhost:3735", wantProtocol: "tcp", wantAddr: "localhost:3735"},
+		{endpoint: "npipe:////./pipe/containerd-containerd", wantProtocol: "npipe", wantAddr: "//./pipe/containerd-containerd"},
+		{endpoint: "npipe://./pipe/containerd-containerd", wantProtocol: "npipe", wantAddr: "//./pipe/containerd-containerd"},
+		{endpoint: "npipe:///pipe/containerd-containerd", wantProtocol: "npipe", wantAddr: "//./pipe/containerd-containerd"},
+		{endpoint: "npipe://host/pipe/containerd-containerd", wantProtocol: "npipe", wantAddr: "//host/pipe/containerd-containerd"},
+		{endpoint: "/run/containerd/containerd.sock", wantErr: true},
+		{endpoint: "http://localhost:3735", wantProtocol: "http", wantErr: true},
+	} {
+		protocol, addr, err := parseEndpoint(tc.endpoint)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseEndpoint(%q) error = %v, want error: %t", tc.endpoint, err, tc.wantErr)
+			continue
+		}
+		if protocol != tc.wantProtocol || addr != tc.wantAddr {
+			t.Errorf("parseEndpoint(%q) = %q, %q, want: %q, %q", tc.endpoint, protocol, addr, tc.wantProtocol, tc.wantAddr)
+		}
+	}
+}
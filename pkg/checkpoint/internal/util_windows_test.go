@@ -0,0 +1,68 @@
+// +build windows
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+func TestGetAddressAndDialerNamedPipe(t *testing.T) {
+	name := fmt.Sprintf("bootkube-checkpoint-test-%d", os.Getpid())
+	l, err := winio.ListenPipe(`\\.\pipe\`+name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}(conn)
+		}
+	}()
+
+	addr, dial, err := GetAddressAndDialer("npipe:////./pipe/" + name)
+	if err != nil {
+		t.Fatalf("GetAddressAndDialer() = %v, want: nil", err)
+	}
+	if want := "//./pipe/" + name; addr != want {
+		t.Errorf("address = %q, want: %q", addr, want)
+	}
+
+	conn, err := dial(addr, time.Second)
+	if err != nil {
+		t.Fatalf("dial() = %v, want: nil", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write() = %v, want: nil", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("ReadFull() = %q, %v, want: %q, nil", buf, err, "ping")
+	}
+
+	// Deadlines must interrupt blocked reads, or gRPC cannot time out requests to a hung runtime.
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(buf); err == nil {
+		t.Errorf("Read() past deadline = nil, want: timeout")
+	} else if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Errorf("Read() past deadline = %v, want: timeout", err)
+	}
+
+	if _, err := dial("//./pipe/"+name+"-missing", 100*time.Millisecond); err == nil {
+		t.Errorf("dial() of a missing pipe = nil, want: non-nil")
+	}
+}
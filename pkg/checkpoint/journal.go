@@ -0,0 +1,243 @@
+package checkpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// checkpointJournalPath is the file that the states of the checkpoints are persisted to.
+	checkpointJournalPath = "/etc/kubernetes/checkpoint-journal.json"
+
+	journalVersion = 1
+
+	// States in the journal. stateNone and stateRemove are not persisted: checkpoints in stateNone
+	// have no manifest yet, and checkpoints in stateRemove are removed in the same iteration.
+	journalStateSelfCheckpoint      = "self-checkpoint"
+	journalStateInactive            = "inactive"
+	journalStateInactiveGracePeriod = "inactive-grace-period"
+	journalStateActive              = "active"
+	journalStateActiveGracePeriod   = "active-grace-period"
+	// journalStateTerminating is a removed checkpoint whose files are kept until its pod terminated
+	// or the end of its grace period, and journalStateStopping a stopped checkpoint whose decrypted
+	// secrets are. Stopped checkpoints have a second entry with their state.
+	journalStateTerminating = "terminating"
+	journalStateStopping    = "stopping"
+)
+
+// journalFile is the format of the checkpoint journal.
+type journalFile struct {
+	Version     int            `json:"version"`
+	Checkpoints []journalEntry `json:"checkpoints"`
+}
+
+// journalEntry is the persisted state of a checkpoint.
+type journalEntry struct {
+	// Name is the namespace/name of the checkpointed pod.
+	Name string `json:"name"`
+	// Self is true for the checkpoint of the checkpointer.
+	Self bool `json:"self,omitempty"`
+	// State is the state of the checkpoint.
+	State string `json:"state"`
+	// GracePeriodEnd is the end of the grace period of the grace period states.
+	GracePeriodEnd *time.Time `json:"gracePeriodEnd,omitempty"`
+}
+
+// checkpointJournal persists the in-memory states of the checkpoints, so that they survive
+// restarts of the checkpointer, including the deadlines of grace periods.
+type checkpointJournal struct {
+	path string
+	// saved is the content that was last written, to skip writes of unchanged states.
+	saved []byte
+}
+
+// load reads the journal. A missing journal has no entries.
+func (j *checkpointJournal) load() ([]journalEntry, error) {
+	data, err := ioutil.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint journal: %v", err)
+	}
+	var f journalFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint journal %s: %v", j.path, err)
+	}
+	if f.Version != journalVersion {
+		return nil, fmt.Errorf("unsupported checkpoint journal version %d in %s", f.Version, j.path)
+	}
+	j.saved = data
+	return f.Checkpoints, nil
+}
+
+// save writes the states of the checkpoints to the journal, if they changed.
+func (j *checkpointJournal) save(cs *checkpoints) error {
+	data, err := json.Marshal(journalFile{Version: journalVersion, Checkpoints: journalEntries(cs)})
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, j.saved) {
+		return nil
+	}
+	if err := writeAndAtomicRename(j.path, data, rootUID, rootGID, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint journal: %v", err)
+	}
+	j.saved = data
+	return nil
+}
+
+// journalEntries returns the journal entries of the checkpoints, sorted by name.
+func journalEntries(cs *checkpoints) []journalEntry {
+	entries := []journalEntry{}
+	add := func(cp *checkpoint, self bool) {
+		state, gracePeriodEnd, ok := journalState(cp.state)
+		if !ok {
+			return
+		}
+		entries = append(entries, journalEntry{Name: cp.name, Self: self, State: state, GracePeriodEnd: gracePeriodEnd})
+	}
+	for _, cp := range cs.checkpoints {
+		add(cp, false)
+	}
+	if cs.selfCheckpoint != nil {
+		add(cs.selfCheckpoint, true)
+	}
+	for id, t := range cs.terminating {
+		state, deadline := journalStateStopping, t.deadline
+		if t.removed {
+			state = journalStateTerminating
+		}
+		entries = append(entries, journalEntry{Name: id, State: state, GracePeriodEnd: &deadline})
+	}
+	sort.Slice(entries, func(i, k int) bool {
+		if entries[i].Name != entries[k].Name {
+			return entries[i].Name < entries[k].Name
+		}
+		return entries[i].State < entries[k].State
+	})
+	return entries
+}
+
+// journalState returns the journal state of a checkpoint state, and false for states that are not
+// persisted.
+func journalState(s checkpointState) (string, *time.Time, bool) {
+	switch s := s.(type) {
+	case stateSelfCheckpointActive:
+		return journalStateSelfCheckpoint, nil, true
+	case stateInactive:
+		return journalStateInactive, nil, true
+	case stateInactiveGracePeriod:
+		return journalStateInactiveGracePeriod, &s.gracePeriodEnd, true
+	case stateActive:
+		return journalStateActive, nil, true
+	case stateActiveGracePeriod:
+		return journalStateActiveGracePeriod, &s.gracePeriodEnd, true
+	}
+	return "", nil, false
+}
+
+// checkpointStateFromJournal returns the checkpoint state of a journal entry.
+func checkpointStateFromJournal(e journalEntry) (checkpointState, error) {
+	gracePeriodEnd := func() (time.Time, error) {
+		if e.GracePeriodEnd == nil {
+			return time.Time{}, fmt.Errorf("state %s of %s has no grace period end", e.State, e.Name)
+		}
+		return *e.GracePeriodEnd, nil
+	}
+	switch e.State {
+	case journalStateSelfCheckpoint:
+		return stateSelfCheckpointActive{}, nil
+	case journalStateInactive:
+		return stateInactive{}, nil
+	case journalStateInactiveGracePeriod:
+		end, err := gracePeriodEnd()
+		return stateInactiveGracePeriod{gracePeriodEnd: end}, err
+	case journalStateActive:
+		return stateActive{}, nil
+	case journalStateActiveGracePeriod:
+		end, err := gracePeriodEnd()
+		return stateActiveGracePeriod{gracePeriodEnd: end}, err
+	}
+	return nil, fmt.Errorf("unknown state %q of %s", e.State, e.Name)
+}
+
+// restoreCheckpoints restores the states of the checkpoints from the journal, and reconciles them
+// with the manifests on disk. Checkpoints on disk without a journal entry are added by
+// checkpoints.update() as if they were just discovered.
+func (c *checkpointer) restoreCheckpoints() {
+	for _, d := range []*manifestDir{c.activeManifests, c.inactiveManifests} {
+		if _, err := d.refresh(); err != nil {
+			glog.Errorf("Failed to read checkpoints: %v", err)
+		}
+	}
+	entries, err := c.journal.load()
+	if err != nil {
+		glog.Errorf("Failed to load the checkpoint journal, restoring checkpoints from their manifests: %v", err)
+	}
+	c.checkpoints.restore(entries, c.activeManifests.pods, c.inactiveManifests.pods)
+	c.checkpoints.reconcile(c.activeManifests.pods)
+}
+
+// restore restores the checkpoints of the journal entries that still have a manifest on disk.
+func (cs *checkpoints) restore(entries []journalEntry, activeCheckpoints, inactiveCheckpoints map[string]*v1.Pod) {
+	if cs.checkpoints == nil {
+		cs.checkpoints = make(map[string]*checkpoint)
+	}
+	for _, e := range entries {
+		if e.State == journalStateTerminating || e.State == journalStateStopping {
+			if e.GracePeriodEnd == nil {
+				glog.Errorf("Ignoring journal entry: state %s of %s has no grace period end", e.State, e.Name)
+				continue
+			}
+			cs.addTerminating(e.Name, *e.GracePeriodEnd, e.State == journalStateTerminating)
+			glog.Infof("Restored terminating checkpoint %s from the journal", e.Name)
+			continue
+		}
+		pod := inactiveCheckpoints[e.Name]
+		if pod == nil {
+			pod = activeCheckpoints[e.Name]
+		}
+		if pod == nil {
+			glog.Warningf("Ignoring journal entry of checkpoint %s without a manifest", e.Name)
+			continue
+		}
+		state, err := checkpointStateFromJournal(e)
+		if err != nil {
+			glog.Errorf("Ignoring journal entry: %v", err)
+			continue
+		}
+		cp := &checkpoint{name: e.Name, pod: pod, state: state}
+		if e.Self {
+			cs.selfCheckpoint = cp
+		} else {
+			cs.checkpoints[e.Name] = cp
+		}
+		glog.Infof("Restored checkpoint %s from the journal", cp)
+	}
+}
+
+// reconcile corrects the states of checkpoints whose active manifests disagree with their state,
+// e.g. if the checkpointer stopped after starting or stopping a checkpoint but before saving the
+// journal, or if a manifest was changed by hand. The manifests on disk win.
+func (cs *checkpoints) reconcile(activeCheckpoints map[string]*v1.Pod) {
+	for name, cp := range cs.checkpoints {
+		_, active := activeCheckpoints[name]
+		switch {
+		case active && cp.state.action() == stop:
+			glog.Warningf("Checkpoint %s is active on disk, changing its state to %s", cp, stateActive{})
+			cp.state = stateActive{}
+		case !active && cp.state.action() == start:
+			glog.Warningf("Checkpoint %s is not active on disk, changing its state to %s", cp, stateInactive{})
+			cp.state = stateInactive{}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package checkpoint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pod := func(name string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system"}}
+	}
+	gracePeriodEnd := time.Unix(1600000000, 0).UTC()
+	cs := checkpoints{
+		checkpoints: map[string]*checkpoint{
+			"kube-system/inactive":     {name: "kube-system/inactive", pod: pod("inactive"), state: stateInactive{}},
+			"kube-system/active-grace": {name: "kube-system/active-grace", pod: pod("active-grace"), state: stateActiveGracePeriod{gracePeriodEnd: gracePeriodEnd}},
+			"kube-system/deleted":      {name: "kube-system/deleted", pod: pod("deleted"), state: stateInactiveGracePeriod{gracePeriodEnd: gracePeriodEnd}},
+			"kube-system/none":         {name: "kube-system/none", pod: pod("none"), state: stateNone{}},
+		},
+		selfCheckpoint: &checkpoint{name: "kube-system/pod-checkpointer", pod: pod("pod-checkpointer"), state: stateSelfCheckpointActive{}},
+		terminating: map[string]terminatingCheckpoint{
+			"kube-system/terminating": {deadline: gracePeriodEnd, removed: true},
+			"kube-system/inactive":    {deadline: gracePeriodEnd},
+		},
+	}
+
+	j := &checkpointJournal{path: filepath.Join(dir, "journal.json")}
+	if err := j.save(&cs); err != nil {
+		t.Fatalf("save() = %v, want: nil", err)
+	}
+	// Unchanged states are not written again.
+	if err := os.Remove(j.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.save(&cs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(j.path); !os.IsNotExist(err) {
+		t.Errorf("save() of unchanged states wrote the journal")
+	}
+	j.saved = nil
+	if err := j.save(&cs); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := (&checkpointJournal{path: j.path}).load()
+	if err != nil {
+		t.Fatalf("load() = %v, want: nil", err)
+	}
+	inactive := map[string]*v1.Pod{
+		"kube-system/inactive":         pod("inactive"),
+		"kube-system/active-grace":     pod("active-grace"),
+		"kube-system/pod-checkpointer": pod("pod-checkpointer"),
+	}
+	active := map[string]*v1.Pod{
+		"kube-system/active-grace":     pod("active-grace"),
+		"kube-system/pod-checkpointer": pod("pod-checkpointer"),
+	}
+	var restored checkpoints
+	restored.restore(entries, active, inactive)
+	restored.reconcile(active)
+
+	// The removed checkpoint and the one in stateNone are not restored.
+	want := map[string]checkpointState{
+		"kube-system/inactive":     stateInactive{},
+		"kube-system/active-grace": stateActiveGracePeriod{gracePeriodEnd: gracePeriodEnd},
+	}
+	got := make(map[string]checkpointState)
+	for name, cp := range restored.checkpoints {
+		got[name] = cp.state
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restored states = %v, want: %v", got, want)
+	}
+	if restored.selfCheckpoint == nil || restored.selfCheckpoint.name != "kube-system/pod-checkpointer" {
+		t.Errorf("restored self-checkpoint = %v, want: kube-system/pod-checkpointer", restored.selfCheckpoint)
+	}
+	// Terminating checkpoints are restored with their deadlines, whether they have a manifest or not.
+	if !reflect.DeepEqual(restored.terminating, cs.terminating) {
+		t.Errorf("restored terminating checkpoints = %v, want: %v", restored.terminating, cs.terminating)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	cs := checkpoints{checkpoints: map[string]*checkpoint{
+		"kube-system/started": {name: "kube-system/started", state: stateInactive{}},
+		"kube-system/stopped": {name: "kube-system/stopped", state: stateActive{}},
+		"kube-system/active":  {name: "kube-system/active", state: stateActive{}},
+	}}
+	cs.reconcile(map[string]*v1.Pod{"kube-system/started": {}, "kube-system/active": {}})
+	want := map[string]checkpointState{
+		"kube-system/started": stateActive{},
+		"kube-system/stopped": stateInactive{},
+		"kube-system/active":  stateActive{},
+	}
+	for name, cp := range cs.checkpoints {
+		if cp.state != want[name] {
+			t.Errorf("reconciled state of %s = %s, want: %s", name, cp.state, want[name])
+		}
+	}
+}
+
+func TestManifestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &manifestDir{path: filepath.Join(dir, "missing")}
+	if _, err := d.refresh(); err == nil {
+		t.Errorf("refresh() of a missing directory = nil, want: non-nil")
+	}
+
+	d = &manifestDir{path: dir}
+	write := func(name string, modTime time.Time) {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "kube-system",
+			Annotations: map[string]string{checkpointParentAnnotation: name},
+		}}
+		buf := &bytes.Buffer{}
+		if err := podSerializer.Encode(pod, buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "kube-system-"+name+".json"), buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Set the modification time explicitly, as it may not change within its resolution.
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	write("kube-apiserver", now)
+	for _, tc := range []struct {
+		desc        string
+		write       string
+		modTime     time.Time
+		wantChanged bool
+		wantPods    int
+	}{
+		{desc: "first read", wantChanged: true, wantPods: 1},
+		{desc: "unchanged", wantChanged: false, wantPods: 1},
+		{desc: "new manifest", write: "kube-scheduler", modTime: now.Add(time.Second), wantChanged: true, wantPods: 2},
+	} {
+		if tc.write != "" {
+			write(tc.write, tc.modTime)
+		}
+		changed, err := d.refresh()
+		if err != nil || changed != tc.wantChanged || len(d.pods) != tc.wantPods {
+			t.Errorf("%s: refresh() = %t, %v with %d pods, want: %t, nil with %d pods", tc.desc, changed, err, len(d.pods), tc.wantChanged, tc.wantPods)
+		}
+	}
+
+	d.forget([]string{"kube-system/kube-apiserver"})
+	if _, ok := d.pods["kube-system/kube-apiserver"]; ok {
+		t.Errorf("forget() kept the forgotten checkpoint")
+	}
+}
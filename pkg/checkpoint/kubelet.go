@@ -13,58 +13,118 @@ import (
 	"k8s.io/client-go/rest"
 )
 
-// A minimal kubelet client. It assumes the kubelet can be reached the kubelet's insecure API at
-// HOST_IP:10255 and the secure API at HOST_IP:10250 or localhost at the same ports.
-type kubeletClient struct {
-	insecureClient *rest.RESTClient
-	secureClient   *rest.RESTClient
-}
+// kubeletRequestTimeout is the timeout of requests to the kubelet.
+const kubeletRequestTimeout = 15 * time.Second
 
-func newKubeletClient(config *rest.Config) (*kubeletClient, error) {
-	// Use the core API group serializer. Same logic as client-go.
-	// https://github.com/kubernetes/client-go/blob/v3.0.0/kubernetes/typed/core/v1/core_client.go#L147
-	config.NegotiatedSerializer = serializer.WithoutConversionCodecFactory{CodecFactory: scheme.Codecs}
-
-	// Kubelet is using a self-signed cert.
-	config.TLSClientConfig.Insecure = true
-	config.TLSClientConfig.CAFile = ""
-	config.TLSClientConfig.CAData = nil
+// kubeletConfig configures a kubeletClient.
+type kubeletConfig struct {
+	// host is the URL of the kubelet's secure API, and readOnlyHost of its read-only API.
+	host         string
+	readOnlyHost string
+	// caFile is the CA bundle that the kubelet's serving certificate is verified with. The CA of
+	// the kubeconfig is used if it is empty.
+	caFile string
+	// serverName is the name that the kubelet's serving certificate is verified for. The host of
+	// the secure API is used if it is empty.
+	serverName string
+	// insecureFallback enables the fallbacks to an unverified connection to the secure API, and to
+	// the read-only API.
+	insecureFallback bool
+}
 
+// newKubeletConfig returns the configuration of the kubelet client for the checkpointer options.
+// The kubelet's APIs are reached at HOST_IP:10250 and HOST_IP:10255, or localhost at the same ports.
+func newKubeletConfig(opts Options) kubeletConfig {
 	hostIP := os.Getenv("HOST_IP")
 	// Default to previous behaviour of using localhost.
 	if hostIP == "" {
 		hostIP = "127.0.0.1"
 	}
+	return kubeletConfig{
+		host:             fmt.Sprintf("https://%s:10250", hostIP),
+		readOnlyHost:     fmt.Sprintf("http://%s:10255", hostIP),
+		caFile:           opts.KubeletCAFile,
+		serverName:       opts.KubeletServerName,
+		insecureFallback: opts.KubeletInsecureFallback,
+	}
+}
 
-	// Shallow copy.
-	insecureConfig := *config
-	secureConfig := *config
+// A minimal kubelet client. It verifies the kubelet's serving certificate and authenticates with
+// the credentials of the kubeconfig. If the insecure fallback is enabled, requests that fail are
+// retried without verifying the serving certificate, and then on the unauthenticated read-only
+// API, which recent kubelets disable.
+type kubeletClient struct {
+	secureClient *rest.RESTClient
+	// unverifiedClient and readOnlyClient are only set if the insecure fallback is enabled.
+	unverifiedClient *rest.RESTClient
+	readOnlyClient   *rest.RESTClient
+}
 
-	insecureConfig.Host = fmt.Sprintf("http://%s:10255", hostIP)
-	secureConfig.Host = fmt.Sprintf("https://%s:10250", hostIP)
+func newKubeletClient(config *rest.Config, kc kubeletConfig) (*kubeletClient, error) {
+	config = rest.CopyConfig(config)
+	// Use the core API group serializer. Same logic as client-go.
+	// https://github.com/kubernetes/client-go/blob/v3.0.0/kubernetes/typed/core/v1/core_client.go#L147
+	config.NegotiatedSerializer = serializer.WithoutConversionCodecFactory{CodecFactory: scheme.Codecs}
+
+	if kc.caFile != "" {
+		config.TLSClientConfig.CAFile = kc.caFile
+		config.TLSClientConfig.CAData = nil
+	}
+	config.TLSClientConfig.ServerName = kc.serverName
+	config.Host = kc.host
 
 	client := new(kubeletClient)
 	var err error
-	if client.insecureClient, err = rest.UnversionedRESTClientFor(&insecureConfig); err != nil {
-		return nil, fmt.Errorf("failed creating kubelet client for debug endpoints: %v", err)
-	}
-	if client.secureClient, err = rest.UnversionedRESTClientFor(&secureConfig); err != nil {
+	if client.secureClient, err = rest.UnversionedRESTClientFor(config); err != nil {
 		return nil, fmt.Errorf("failed creating kubelet client: %v", err)
 	}
+	if !kc.insecureFallback {
+		return client, nil
+	}
 
+	glog.Warning("Insecure kubelet fallback is enabled: if the kubelet's serving certificate cannot be verified, the kubelet is queried without verifying it, and then on its read-only port")
+	unverifiedConfig := rest.CopyConfig(config)
+	unverifiedConfig.TLSClientConfig.Insecure = true
+	unverifiedConfig.TLSClientConfig.CAFile = ""
+	unverifiedConfig.TLSClientConfig.CAData = nil
+	unverifiedConfig.TLSClientConfig.ServerName = ""
+	if client.unverifiedClient, err = rest.UnversionedRESTClientFor(unverifiedConfig); err != nil {
+		return nil, fmt.Errorf("failed creating unverified kubelet client: %v", err)
+	}
+	// The read-only API is plain HTTP, so it must not be sent any credentials.
+	readOnlyConfig := rest.AnonymousClientConfig(config)
+	readOnlyConfig.TLSClientConfig = rest.TLSClientConfig{}
+	readOnlyConfig.Host = kc.readOnlyHost
+	if client.readOnlyClient, err = rest.UnversionedRESTClientFor(readOnlyConfig); err != nil {
+		return nil, fmt.Errorf("failed creating kubelet client for the read-only port: %v", err)
+	}
 	return client, nil
 }
 
 // localParentPods will retrieve all pods from kubelet api that are parents & should be checkpointed
 func (k *kubeletClient) localParentPods() map[string]*corev1.Pod {
-	podList := new(corev1.PodList)
-	timeout := 15 * time.Second
-	if err := k.secureClient.Get().AbsPath("/pods/").Timeout(timeout).Do(context.TODO()).Into(podList); err != nil {
-		glog.Errorf("failed to secure list local parent pods, fallback to insecure: %v", err)
-		if err := k.insecureClient.Get().AbsPath("/pods/").Timeout(timeout).Do(context.TODO()).Into(podList); err != nil {
-			// Assume there are no local parent pods.
-			glog.Errorf("failed to insecure list local parent pods, assuming none are running: %v", err)
-		}
+	podList, err := listKubeletPods(k.secureClient)
+	if err != nil && k.unverifiedClient != nil {
+		glog.Warningf("Failed to list local parent pods, falling back to not verifying the kubelet's serving certificate: %v", err)
+		podList, err = listKubeletPods(k.unverifiedClient)
+	}
+	if err != nil && k.readOnlyClient != nil {
+		glog.Warningf("Failed to list local parent pods, falling back to the kubelet's read-only port: %v", err)
+		podList, err = listKubeletPods(k.readOnlyClient)
+	}
+	if err != nil {
+		recordError(errorSourceKubelet)
+		// Assume there are no local parent pods.
+		glog.Errorf("failed to list local parent pods, assuming none are running: %v", err)
 	}
 	return podListToParentPods(podList)
 }
+
+func listKubeletPods(client *rest.RESTClient) (*corev1.PodList, error) {
+	podList := new(corev1.PodList)
+	err := client.Get().AbsPath("/pods/").Timeout(kubeletRequestTimeout).Do(context.TODO()).Into(podList)
+	if err != nil {
+		return new(corev1.PodList), err
+	}
+	return podList, nil
+}
@@ -0,0 +1,104 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/cert"
+)
+
+func TestKubeletClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubelet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// kubelet serves a parent pod named after the API, and records the credentials it was sent.
+	var authorization map[string]string
+	kubelet := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization[name] = r.Header.Get("Authorization")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&v1.PodList{Items: []v1.Pod{{ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "kube-system",
+				Annotations: map[string]string{shouldCheckpointAnnotation: shouldCheckpoint},
+			}}}})
+		})
+	}
+	secure := httptest.NewTLSServer(kubelet("secure"))
+	defer secure.Close()
+	readOnly := httptest.NewServer(kubelet("read-only"))
+	defer readOnly.Close()
+
+	// The certificate of the test server is valid for 127.0.0.1 and example.com.
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: secure.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	otherCA, _, err := cert.GenerateSelfSignedCertKey("other", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCAFile := filepath.Join(dir, "other.crt")
+	if err := ioutil.WriteFile(otherCAFile, otherCA, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		desc             string
+		caFile           string
+		serverName       string
+		insecureFallback bool
+		readOnlyHost     string
+		want             string
+	}{
+		{desc: "verified", caFile: caFile, want: "secure"},
+		{desc: "verified server name", caFile: caFile, serverName: "example.com", want: "secure"},
+		{desc: "wrong server name", caFile: caFile, serverName: "kubelet.example.org", want: ""},
+		{desc: "untrusted certificate", caFile: otherCAFile, want: ""},
+		{desc: "unverified fallback", caFile: otherCAFile, insecureFallback: true, want: "secure"},
+		{desc: "read-only fallback", caFile: otherCAFile, insecureFallback: true, readOnlyHost: readOnly.URL, want: "read-only"},
+	} {
+		authorization = make(map[string]string)
+		host := secure.URL
+		if tc.readOnlyHost != "" {
+			// The secure API is down.
+			host = "https://127.0.0.1:1"
+		}
+		client, err := newKubeletClient(&rest.Config{BearerToken: "token"}, kubeletConfig{
+			host:             host,
+			readOnlyHost:     tc.readOnlyHost,
+			caFile:           tc.caFile,
+			serverName:       tc.serverName,
+			insecureFallback: tc.insecureFallback,
+		})
+		if err != nil {
+			t.Fatalf("%s: newKubeletClient() = %v, want: nil", tc.desc, err)
+		}
+
+		var got string
+		for _, pod := range client.localParentPods() {
+			got = pod.Name
+		}
+		if got != tc.want {
+			t.Errorf("%s: localParentPods() = %q, want: %q", tc.desc, got, tc.want)
+		}
+		if a, ok := authorization["secure"]; ok && a != "Bearer token" {
+			t.Errorf("%s: secure API was sent Authorization %q, want: %q", tc.desc, a, "Bearer token")
+		}
+		if a := authorization["read-only"]; a != "" {
+			t.Errorf("%s: read-only API was sent Authorization %q, want: none", tc.desc, a)
+		}
+	}
+}
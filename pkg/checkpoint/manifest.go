@@ -2,10 +2,12 @@ package checkpoint
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	corev1 "k8s.io/api/core/v1"
@@ -14,12 +16,12 @@ import (
 )
 
 // getFileCheckpoints will retrieve all checkpoint manifests from a given filepath.
-func getFileCheckpoints(path string) map[string]*corev1.Pod {
+func getFileCheckpoints(path string) (map[string]*corev1.Pod, error) {
 	checkpoints := make(map[string]*corev1.Pod)
 
 	fi, err := ioutil.ReadDir(path)
 	if err != nil {
-		glog.Fatalf("Failed to read checkpoint manifest path: %v", err)
+		return nil, fmt.Errorf("failed to read checkpoint manifest path: %v", err)
 	}
 
 	for _, f := range fi {
@@ -34,18 +36,12 @@ func getFileCheckpoints(path string) map[string]*corev1.Pod {
 			continue
 		}
 
-		b, err := ioutil.ReadFile(manifest)
+		cp, err := readCheckpointManifest(manifest)
 		if err != nil {
 			glog.Errorf("Error reading manifest: %v", err)
 			continue
 		}
 
-		cp := &corev1.Pod{}
-		if err := runtime.DecodeInto(scheme.Codecs.UniversalDecoder(), b, cp); err != nil {
-			glog.Errorf("Error unmarshalling manifest from %s: %v", filepath.Join(path, f.Name()), err)
-			continue
-		}
-
 		if isCheckpoint(cp) {
 			if _, ok := checkpoints[podFullName(cp)]; ok { // sanity check
 				glog.Warningf("Found multiple checkpoint pods in %s with same id: %s", path, podFullName(cp))
@@ -53,7 +49,59 @@ func getFileCheckpoints(path string) map[string]*corev1.Pod {
 			checkpoints[podFullName(cp)] = cp
 		}
 	}
-	return checkpoints
+	return checkpoints, nil
+}
+
+// manifestDir caches the checkpoint manifests in a directory. The manifests are only read again
+// when the modification time of the directory changes, which happens whenever a manifest is
+// created, removed or replaced by writeAndAtomicRename(), by the checkpointer or anyone else.
+type manifestDir struct {
+	path    string
+	pods    map[string]*corev1.Pod
+	modTime time.Time
+	loaded  bool
+}
+
+// refresh reads the manifests again if the directory changed since they were last read, and
+// returns true if it did.
+func (d *manifestDir) refresh() (bool, error) {
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read checkpoint manifest path: %v", err)
+	}
+	if d.loaded && fi.ModTime().Equal(d.modTime) {
+		return false, nil
+	}
+	pods, err := getFileCheckpoints(d.path)
+	if err != nil {
+		return false, err
+	}
+	if d.loaded {
+		glog.Infof("Detected changes to %s, reloaded checkpoint manifests", d.path)
+	}
+	d.pods, d.modTime, d.loaded = pods, fi.ModTime(), true
+	return true, nil
+}
+
+// forget removes the manifests of checkpoints that were removed from the cache, in case the
+// directory changes within the resolution of its modification time.
+func (d *manifestDir) forget(ids []string) {
+	for _, id := range ids {
+		delete(d.pods, id)
+	}
+}
+
+// readCheckpointManifest reads and decodes the checkpoint manifest at path.
+func readCheckpointManifest(path string) (*corev1.Pod, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := &corev1.Pod{}
+	if err := runtime.DecodeInto(scheme.Codecs.UniversalDecoder(), b, cp); err != nil {
+		return nil, fmt.Errorf("error unmarshalling manifest from %s: %v", path, err)
+	}
+	return cp, nil
 }
 
 // writeCheckpointManifest will save the pod to the inactive checkpoint location if it doesn't already exist.
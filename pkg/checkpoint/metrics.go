@@ -0,0 +1,148 @@
+package checkpoint
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "pod_checkpointer"
+
+// Sources of errors counted by errorsTotal.
+const (
+	errorSourceAPIServer = "apiserver"
+	errorSourceKubelet   = "kubelet"
+	errorSourceCRI       = "cri"
+)
+
+var (
+	checkpointsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "checkpoints",
+		Help:      "Number of checkpoints in each state.",
+	}, []string{"state"})
+	transitionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "transitions_total",
+		Help:      "Number of checkpoint state transitions.",
+	}, []string{"from", "to"})
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Number of failed requests to the apiserver, kubelet and CRI shim.",
+	}, []string{"source"})
+	syncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Latency of an iteration of the checkpointing loop.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	})
+	lastSyncTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_sync_timestamp_seconds",
+		Help:      "Unix time at which the last iteration of the checkpointing loop completed.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(checkpointsGauge, transitionsTotal, errorsTotal, syncDuration, lastSyncTimestamp)
+}
+
+// stateLabel returns the value of the state label of a checkpoint state.
+func stateLabel(s checkpointState) string {
+	switch s.(type) {
+	case stateSelfCheckpointActive:
+		return "self_checkpoint"
+	case stateNone:
+		return "none"
+	case stateInactive:
+		return "inactive"
+	case stateInactiveGracePeriod:
+		return "inactive_grace_period"
+	case stateActive:
+		return "active"
+	case stateActiveGracePeriod:
+		return "active_grace_period"
+	case stateRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// recordTransition counts a state transition of a checkpoint.
+func recordTransition(from, to checkpointState) {
+	transitionsTotal.WithLabelValues(stateLabel(from), stateLabel(to)).Inc()
+}
+
+// recordError counts a failed request to source.
+func recordError(source string) {
+	errorsTotal.WithLabelValues(source).Inc()
+}
+
+// recordStates sets the number of checkpoints in each state.
+func (cs *checkpoints) recordStates() {
+	counts := make(map[string]float64)
+	for _, s := range []checkpointState{stateSelfCheckpointActive{}, stateNone{}, stateInactive{}, stateInactiveGracePeriod{}, stateActive{}, stateActiveGracePeriod{}} {
+		counts[stateLabel(s)] = 0
+	}
+	for _, cp := range cs.checkpoints {
+		counts[stateLabel(cp.state)]++
+	}
+	if cs.selfCheckpoint != nil {
+		counts[stateLabel(cs.selfCheckpoint.state)]++
+	}
+	for state, n := range counts {
+		checkpointsGauge.WithLabelValues(state).Set(n)
+	}
+}
+
+// health tracks whether the checkpointing loop is alive.
+type health struct {
+	// maxSyncAge is the time after which the loop is considered stuck if no iteration completed.
+	maxSyncAge time.Duration
+
+	mu       sync.Mutex
+	lastSync time.Time
+}
+
+// synced records that an iteration of the loop that started at start completed at end.
+func (h *health) synced(start, end time.Time) {
+	h.mu.Lock()
+	h.lastSync = end
+	h.mu.Unlock()
+	syncDuration.Observe(end.Sub(start).Seconds())
+	lastSyncTimestamp.Set(float64(end.Unix()))
+}
+
+// check returns false if no iteration of the loop completed within maxSyncAge of now.
+func (h *health) check(now time.Time) (bool, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return now.Sub(h.lastSync) <= h.maxSyncAge, h.lastSync
+}
+
+// ServeHTTP serves /healthz.
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ok, lastSync := h.check(time.Now())
+	if !ok {
+		http.Error(w, "checkpointing loop has not completed since "+lastSync.UTC().Format(time.RFC3339), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// serveHealthAndMetrics serves /healthz and /metrics on addr.
+func serveHealthAndMetrics(addr string, h *health) {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", h)
+	mux.Handle("/metrics", promhttp.Handler())
+	glog.Infof("Serving /healthz and /metrics on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		glog.Fatalf("Failed to serve /healthz and /metrics: %v", err)
+	}
+}
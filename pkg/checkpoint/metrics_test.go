@@ -0,0 +1,50 @@
+package checkpoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/api/core/v1"
+)
+
+func TestHealthCheck(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		desc     string
+		lastSync time.Time
+		want     int
+	}{
+		{desc: "recent sync", lastSync: now.Add(-time.Second), want: http.StatusOK},
+		{desc: "sync at the limit", lastSync: now.Add(-time.Minute), want: http.StatusOK},
+		{desc: "stuck loop", lastSync: now.Add(-2 * time.Minute), want: http.StatusServiceUnavailable},
+	} {
+		h := &health{maxSyncAge: time.Minute + time.Second, lastSync: tc.lastSync}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s: /healthz status = %d, want: %d", tc.desc, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestProcessRecordsTransitions(t *testing.T) {
+	before := testutil.ToFloat64(transitionsTotal.WithLabelValues("inactive", "active"))
+	cs := &checkpoints{checkpoints: map[string]*checkpoint{
+		"kube-system/kube-apiserver": {name: "kube-system/kube-apiserver", state: stateInactive{}, pod: &v1.Pod{}},
+	}}
+	cs.process(time.Now(), false, false, nil, nil, nil)
+	if got := testutil.ToFloat64(transitionsTotal.WithLabelValues("inactive", "active")) - before; got != 1 {
+		t.Errorf("inactive -> active transitions = %v, want: 1", got)
+	}
+
+	cs.recordStates()
+	if got := testutil.ToFloat64(checkpointsGauge.WithLabelValues("active")); got != 1 {
+		t.Errorf("active checkpoints = %v, want: 1", got)
+	}
+	if got := testutil.ToFloat64(checkpointsGauge.WithLabelValues("inactive")); got != 0 {
+		t.Errorf("inactive checkpoints = %v, want: 0", got)
+	}
+}
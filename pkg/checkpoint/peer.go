@@ -0,0 +1,256 @@
+package checkpoint
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+const (
+	// peerHeartbeatPath is the path that the heartbeat of a checkpointer is served at.
+	peerHeartbeatPath = "/heartbeat"
+	// peerProbeTimeout is the timeout of a heartbeat request to a peer.
+	peerProbeTimeout = 2 * time.Second
+	// peerHeartbeatMaxAge is the number of probe intervals after which the last heartbeat of a peer
+	// is stale, and the peer is considered unreachable.
+	peerHeartbeatMaxAge = 3
+)
+
+// peerHeartbeat is what a checkpointer reports to its peers.
+type peerHeartbeat struct {
+	Node string `json:"node"`
+	// APIAvailable is whether the apiserver was available to the checkpointer in its last iteration.
+	APIAvailable bool `json:"apiAvailable"`
+}
+
+// receivedHeartbeat is the last heartbeat of a peer, and when it was received.
+type receivedHeartbeat struct {
+	peerHeartbeat
+	received time.Time
+}
+
+// peerProber exchanges heartbeats with the checkpointers on the other masters, so that a node that
+// is cut off from the apiserver does not activate its checkpoints while the apiserver is still up
+// for the rest of the cluster. Heartbeats are exchanged over mutual TLS, see loadTLS. It implements
+// peerSource.
+type peerProber struct {
+	node string
+	// peers are the host:port addresses of the checkpointers on the other masters.
+	peers []string
+	// selfActivate allows activation when no peer is reachable.
+	selfActivate bool
+	interval     time.Duration
+	client       *http.Client
+	clock        clock.Clock
+	// serverTLS is the TLS configuration that the heartbeat is served with.
+	serverTLS *tls.Config
+
+	mu sync.Mutex
+	// synced is set once the first iteration recorded apiAvailable. No heartbeat is served before,
+	// so that peers don't count a restarting checkpointer as confirming an outage.
+	synced       bool
+	apiAvailable bool
+	heartbeats   map[string]receivedHeartbeat
+}
+
+// newPeerProber returns a peerProber for the checkpointer on node that probes peers every interval.
+func newPeerProber(node string, peers []string, selfActivate bool, interval time.Duration) *peerProber {
+	return &peerProber{
+		node:         node,
+		peers:        peers,
+		selfActivate: selfActivate,
+		interval:     interval,
+		client:       &http.Client{Timeout: peerProbeTimeout},
+		clock:        clock.RealClock{},
+		heartbeats:   make(map[string]receivedHeartbeat),
+	}
+}
+
+// loadTLS configures the prober to serve and probe heartbeats over mutual TLS. Peers must present
+// a certificate signed by the CA in caFile, and valid for their address in peers, both when they
+// serve and when they probe: a heartbeat from any other host could confirm an outage that this node
+// only sees because it is partitioned.
+func (p *peerProber) loadTLS(caFile, certFile, keyFile string) error {
+	if caFile == "" || certFile == "" || keyFile == "" {
+		return fmt.Errorf("a CA, certificate and key are required")
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read peer CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("no certificates found in peer CA %s", caFile)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load peer certificate: %v", err)
+	}
+
+	p.serverTLS = &tls.Config{
+		MinVersion:            tls.VersionTLS12,
+		Certificates:          []tls.Certificate{cert},
+		ClientCAs:             pool,
+		ClientAuth:            tls.RequireAndVerifyClientCert,
+		VerifyPeerCertificate: p.verifyPeer,
+	}
+	p.client.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+		},
+	}
+	return nil
+}
+
+// verifyPeer checks that the verified client certificate of a probe is valid for the address of
+// one of the peers.
+func (p *peerProber) verifyPeer(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return fmt.Errorf("no verified client certificate")
+	}
+	leaf := verifiedChains[0][0]
+	for _, peer := range p.peers {
+		host, _, err := net.SplitHostPort(peer)
+		if err != nil {
+			host = peer
+		}
+		if leaf.VerifyHostname(host) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate %q is not valid for any peer", leaf.Subject.CommonName)
+}
+
+// ServeHTTP implements http.Handler, serving the heartbeat of this checkpointer.
+func (p *peerProber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	synced, hb := p.synced, peerHeartbeat{Node: p.node, APIAvailable: p.apiAvailable}
+	p.mu.Unlock()
+
+	if !synced {
+		http.Error(w, "not synced", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hb); err != nil {
+		glog.Errorf("Failed to write heartbeat: %v", err)
+	}
+}
+
+// serve serves the heartbeat on addr over mutual TLS.
+func (p *peerProber) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle(peerHeartbeatPath, p)
+	server := &http.Server{Addr: addr, Handler: mux, TLSConfig: p.serverTLS}
+	glog.Infof("Serving %s on %s", peerHeartbeatPath, addr)
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		glog.Fatalf("Failed to serve %s: %v", peerHeartbeatPath, err)
+	}
+}
+
+// run probes all peers every interval until stop is closed.
+func (p *peerProber) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.probePeers()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probePeers requests the heartbeats of all peers concurrently.
+func (p *peerProber) probePeers() {
+	var wg sync.WaitGroup
+	for _, peer := range p.peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			hb, err := p.probe(peer)
+			if err != nil {
+				glog.V(4).Infof("Failed to probe peer %s: %v", peer, err)
+				return
+			}
+			p.mu.Lock()
+			p.heartbeats[peer] = receivedHeartbeat{peerHeartbeat: *hb, received: p.clock.Now()}
+			p.mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// probe requests the heartbeat of peer.
+func (p *peerProber) probe(peer string) (*peerHeartbeat, error) {
+	resp, err := p.client.Get(fmt.Sprintf("https://%s%s", peer, peerHeartbeatPath))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	var hb peerHeartbeat
+	if err := json.NewDecoder(resp.Body).Decode(&hb); err != nil {
+		return nil, fmt.Errorf("failed to decode heartbeat: %v", err)
+	}
+	return &hb, nil
+}
+
+// partitioned records whether the apiserver is available to this node, which is reported to its
+// peers, and returns true if the apiserver is unavailable but the outage is not confirmed by the
+// peers, so that checkpoints must not be activated.
+//
+// The outage is confirmed if a quorum of the masters, including this one, cannot reach the
+// apiserver. If no peer is reachable the node is isolated, and the outage is only assumed if
+// selfActivate is set.
+func (p *peerProber) partitioned(apiAvailable bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.synced, p.apiAvailable = true, apiAvailable
+	if apiAvailable {
+		return false
+	}
+
+	now := p.clock.Now()
+	reachable, unavailable := 0, 1
+	for _, peer := range p.peers {
+		hb, ok := p.heartbeats[peer]
+		if !ok || now.Sub(hb.received) > peerHeartbeatMaxAge*p.interval {
+			continue
+		}
+		reachable++
+		if !hb.APIAvailable {
+			unavailable++
+		}
+	}
+
+	quorum := (len(p.peers)+1)/2 + 1
+	switch {
+	case unavailable >= quorum:
+		return false
+	case reachable == 0 && p.selfActivate:
+		glog.Warningf("No peer is reachable, assuming that the apiserver is down")
+		return false
+	case reachable == 0:
+		glog.Warningf("No peer is reachable, not activating checkpoints")
+		return true
+	default:
+		glog.Warningf("Only %d of %d masters cannot reach the apiserver, not activating checkpoints", unavailable, len(p.peers)+1)
+		return true
+	}
+}
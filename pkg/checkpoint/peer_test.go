@@ -0,0 +1,192 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestPeerProberPartitioned(t *testing.T) {
+	const interval = 5 * time.Second
+	now := time.Now()
+
+	// heartbeat returns a heartbeat received age ago.
+	heartbeat := func(apiAvailable bool, age time.Duration) receivedHeartbeat {
+		return receivedHeartbeat{peerHeartbeat: peerHeartbeat{APIAvailable: apiAvailable}, received: now.Add(-age)}
+	}
+
+	for _, tc := range []struct {
+		desc         string
+		peers        []string
+		heartbeats   map[string]receivedHeartbeat
+		selfActivate bool
+		apiAvailable bool
+		want         bool
+	}{{
+		desc:         "apiserver available",
+		peers:        []string{"a", "b"},
+		apiAvailable: true,
+		want:         false,
+	}, {
+		desc:  "no peers",
+		peers: nil,
+		want:  false,
+	}, {
+		desc:  "quorum cannot reach apiserver",
+		peers: []string{"a", "b"},
+		heartbeats: map[string]receivedHeartbeat{
+			"a": heartbeat(false, 0),
+			"b": heartbeat(true, 0),
+		},
+		want: false,
+	}, {
+		desc:  "peers can reach apiserver",
+		peers: []string{"a", "b"},
+		heartbeats: map[string]receivedHeartbeat{
+			"a": heartbeat(true, 0),
+			"b": heartbeat(true, 0),
+		},
+		want: true,
+	}, {
+		desc:  "single reachable peer cannot reach apiserver",
+		peers: []string{"a", "b", "c", "d"},
+		heartbeats: map[string]receivedHeartbeat{
+			"a": heartbeat(false, 0),
+		},
+		want: true,
+	}, {
+		desc:  "stale heartbeats confirming outage",
+		peers: []string{"a", "b"},
+		heartbeats: map[string]receivedHeartbeat{
+			"a": heartbeat(false, 4*interval),
+			"b": heartbeat(false, interval),
+		},
+		want: false,
+	}, {
+		desc:  "isolated",
+		peers: []string{"a", "b"},
+		heartbeats: map[string]receivedHeartbeat{
+			"a": heartbeat(false, 4*interval),
+		},
+		want: true,
+	}, {
+		desc:         "isolated with self-activation",
+		peers:        []string{"a", "b"},
+		selfActivate: true,
+		want:         false,
+	}, {
+		desc:  "two masters, peer can reach apiserver",
+		peers: []string{"a"},
+		heartbeats: map[string]receivedHeartbeat{
+			"a": heartbeat(true, 0),
+		},
+		selfActivate: true,
+		want:         true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := newPeerProber("node1", tc.peers, tc.selfActivate, interval)
+			p.clock = clock.NewFakeClock(now)
+			if tc.heartbeats != nil {
+				p.heartbeats = tc.heartbeats
+			}
+			if got := p.partitioned(tc.apiAvailable); got != tc.want {
+				t.Errorf("partitioned() = %t, want: %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPeerProberHeartbeats(t *testing.T) {
+	const interval = 5 * time.Second
+	dir, err := ioutil.TempDir("", "peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The self-signed test certificate is valid for 127.0.0.1, and is its own CA.
+	certFile, keyFile := writeTestCert(t, dir)
+
+	// serve serves the heartbeat of p over mutual TLS.
+	serve := func(p *peerProber) *httptest.Server {
+		s := httptest.NewUnstartedServer(p)
+		s.TLS = p.serverTLS
+		s.StartTLS()
+		return s
+	}
+	newProber := func(node string, peers ...string) *peerProber {
+		p := newPeerProber(node, peers, false, interval)
+		if err := p.loadTLS(certFile, certFile, keyFile); err != nil {
+			t.Fatalf("loadTLS() = %v, want: nil", err)
+		}
+		return p
+	}
+
+	// node1 accepts probes from node2 at 127.0.0.1.
+	p1 := newProber("node1", "127.0.0.1:9192")
+	s1 := serve(p1)
+	defer s1.Close()
+	p2 := newProber("node2", strings.TrimPrefix(s1.URL, "https://"))
+
+	// node1 serves no heartbeat until its first iteration.
+	if _, err := p2.probe(p2.peers[0]); err == nil {
+		t.Errorf("probe() before first iteration = nil, want: non-nil")
+	}
+	p2.probePeers()
+	if !p2.partitioned(false) {
+		t.Errorf("partitioned() = false with unsynced peer, want: true")
+	}
+
+	// Both nodes lose the apiserver.
+	p1.partitioned(false)
+	p2.probePeers()
+	if p2.partitioned(false) {
+		t.Errorf("partitioned() = true with peer confirming outage, want: false")
+	}
+
+	// Only node2 lost the apiserver.
+	p1.partitioned(true)
+	p2.probePeers()
+	if !p2.partitioned(false) {
+		t.Errorf("partitioned() = false with peer reaching apiserver, want: true")
+	}
+	if hb := p2.heartbeats[p2.peers[0]]; hb.Node != "node1" {
+		t.Errorf("heartbeat node = %q, want: %q", hb.Node, "node1")
+	}
+
+	// A heartbeat from a server without a certificate of the peer CA is not trusted.
+	rogue := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"node":"rogue","apiAvailable":false}`))
+	}))
+	defer rogue.Close()
+	if _, err := p2.probe(strings.TrimPrefix(rogue.URL, "https://")); err == nil {
+		t.Errorf("probe() of a server with an untrusted certificate = nil, want: non-nil")
+	}
+
+	// Probes from hosts that are not peers are rejected.
+	p3 := newProber("node3", "10.0.0.3:9192")
+	s3 := serve(p3)
+	defer s3.Close()
+	p3.partitioned(false)
+	if _, err := p2.probe(strings.TrimPrefix(s3.URL, "https://")); err == nil {
+		t.Errorf("probe() with a client certificate that is not valid for a peer = nil, want: non-nil")
+	}
+
+	// Plain HTTP is not served.
+	resp, err := http.Get("http://" + strings.TrimPrefix(s1.URL, "https://") + peerHeartbeatPath)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("heartbeat served over plain HTTP")
+		}
+	}
+
+	if err := newPeerProber("node1", nil, false, interval).loadTLS(certFile, "", ""); err == nil {
+		t.Errorf("loadTLS() without a certificate = nil, want: non-nil")
+	}
+}
@@ -72,12 +72,19 @@ func sanitizeCheckpointPod(cp *corev1.Pod) *corev1.Pod {
 	// Sanitize the volumes
 	for i := range cp.Spec.Volumes {
 		v := &cp.Spec.Volumes[i]
-		if v.Secret != nil {
-			v.HostPath = &corev1.HostPathVolumeSource{Path: secretPath(cp.Namespace, cp.Name, v.Secret.SecretName)}
+		switch {
+		case v.Secret != nil:
+			v.HostPath = &corev1.HostPathVolumeSource{Path: secretVolumePath(cp.Namespace, cp.Name, v)}
 			v.Secret = nil
-		} else if v.ConfigMap != nil {
-			v.HostPath = &corev1.HostPathVolumeSource{Path: configMapPath(cp.Namespace, cp.Name, v.ConfigMap.Name)}
+		case v.ConfigMap != nil:
+			v.HostPath = &corev1.HostPathVolumeSource{Path: configMapVolumePath(cp.Namespace, cp.Name, v)}
 			v.ConfigMap = nil
+		case v.Projected != nil:
+			v.HostPath = &corev1.HostPathVolumeSource{Path: volumePath(cp.Namespace, cp.Name, v.Name)}
+			v.Projected = nil
+		case v.DownwardAPI != nil:
+			v.HostPath = &corev1.HostPathVolumeSource{Path: volumePath(cp.Namespace, cp.Name, v.Name)}
+			v.DownwardAPI = nil
 		}
 	}
 
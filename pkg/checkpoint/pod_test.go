@@ -89,6 +89,37 @@ func TestSanitizeCheckpointPod(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "Volumes are converted to hostPaths",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "podname",
+					Namespace: "podnamespace",
+				},
+				Spec: v1.PodSpec{Volumes: []v1.Volume{
+					{Name: "secret", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "s"}}},
+					{Name: "secret-items", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "s", Items: []v1.KeyToPath{{Key: "a", Path: "b"}}}}},
+					{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "c"}}}},
+					{Name: "kube-api-access", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{}}},
+					{Name: "podinfo", VolumeSource: v1.VolumeSource{DownwardAPI: &v1.DownwardAPIVolumeSource{}}},
+				}},
+			},
+			expected: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "podname",
+					Namespace:       "podnamespace",
+					Annotations:     map[string]string{checkpointParentAnnotation: "podname"},
+					OwnerReferences: []metav1.OwnerReference{{Name: "podname", Controller: &trueVar}},
+				},
+				Spec: v1.PodSpec{Volumes: []v1.Volume{
+					{Name: "secret", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: checkpointSecretPath + "/podnamespace/podname/s"}}},
+					{Name: "secret-items", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: checkpointVolumePath + "/podnamespace/podname/secret-items"}}},
+					{Name: "config", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: checkpointConfigMapPath + "/podnamespace/podname/c"}}},
+					{Name: "kube-api-access", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: checkpointVolumePath + "/podnamespace/podname/kube-api-access"}}},
+					{Name: "podinfo", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: checkpointVolumePath + "/podnamespace/podname/podinfo"}}},
+				}},
+			},
+		},
 		{
 			desc: "Labels are preserved",
 			pod: &v1.Pod{
@@ -5,12 +5,19 @@ import (
 	"io/ioutil"
 	"os"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
 )
 
+const (
+	// maxTerminationWait bounds the wait for the pod of a removed checkpoint to terminate, so that
+	// the files of pods with long termination grace periods are not kept indefinitely.
+	maxTerminationWait = 2 * time.Minute
+)
+
 // checkpoint holds the state of a single checkpointed pod. A checkpoint can move between states
 // based on the apiCondition that the checkpointer sees.
 type checkpoint struct {
@@ -31,6 +38,30 @@ func (c checkpoint) String() string {
 type checkpoints struct {
 	checkpoints    map[string]*checkpoint
 	selfCheckpoint *checkpoint
+	// terminating holds the stopped and removed checkpoints whose pods may still be running.
+	terminating map[string]terminatingCheckpoint
+}
+
+// terminatingCheckpoint is a checkpoint whose active manifest was removed while its pod may still
+// be running, so that the files its pod mounts must be kept until it terminated.
+type terminatingCheckpoint struct {
+	// deadline is when the files are removed even if the pod is still running.
+	deadline time.Time
+	// removed is true if the checkpoint was removed, and all its files are removed. Only the
+	// decrypted secrets of stopped checkpoints are.
+	removed bool
+}
+
+// addTerminating adds a checkpoint whose pod may still be running until deadline. A checkpoint that
+// is removed while its pod terminates after being stopped keeps the earlier deadline.
+func (cs *checkpoints) addTerminating(id string, deadline time.Time, removed bool) {
+	if cs.terminating == nil {
+		cs.terminating = make(map[string]terminatingCheckpoint)
+	}
+	if t, ok := cs.terminating[id]; ok && t.deadline.Before(deadline) {
+		deadline = t.deadline
+	}
+	cs.terminating[id] = terminatingCheckpoint{deadline: deadline, removed: removed || cs.terminating[id].removed}
 }
 
 // update updates the checkpoints using the information retrieved from the various API endpoints.
@@ -114,7 +145,7 @@ func (cs *checkpoints) update(localRunningPods, localParentPods, apiParentPods,
 
 // process uses the apiserver inputs and curren time to determine which checkpoints to start, stop,
 // or remove.
-func (cs *checkpoints) process(now time.Time, apiAvailable bool, localRunningPods, localParentPods, apiParentPods map[string]*v1.Pod) (starts, stops, removes []string) {
+func (cs *checkpoints) process(now time.Time, apiAvailable, partitioned bool, localRunningPods, localParentPods, apiParentPods map[string]*v1.Pod) (starts, stops, removes []string) {
 	// The checkpointer must be handled specially: the checkpoint always needs to remain active, and
 	// if it is removed from the apiserver then all other checkpoints need to be removed first.
 	if cs.selfCheckpoint != nil {
@@ -123,10 +154,12 @@ func (cs *checkpoints) process(now time.Time, apiAvailable bool, localRunningPod
 			apiParent:    apiParentPods[cs.selfCheckpoint.name] != nil,
 			localRunning: localRunningPods[cs.selfCheckpoint.name] != nil,
 			localParent:  localParentPods[cs.selfCheckpoint.name] != nil,
+			partitioned:  partitioned,
 		})
 
 		if state != cs.selfCheckpoint.state {
 			glog.Infof("Self-checkpoint %s transitioning from state %s -> state %s", cs.selfCheckpoint, cs.selfCheckpoint.state, state)
+			recordTransition(cs.selfCheckpoint.state, state)
 			cs.selfCheckpoint.state = state
 		}
 
@@ -173,6 +206,7 @@ func (cs *checkpoints) process(now time.Time, apiAvailable bool, localRunningPod
 			apiParent:    apiParentPods[name] != nil,
 			localRunning: localRunningPods[name] != nil,
 			localParent:  localParentPods[name] != nil,
+			partitioned:  partitioned,
 		})
 
 		if state != cp.state {
@@ -195,6 +229,7 @@ func (cs *checkpoints) process(now time.Time, apiAvailable bool, localRunningPod
 			}
 
 			glog.Infof("Checkpoint %s transitioning from state %s -> state %s", cp, cp.state, state)
+			recordTransition(cp.state, state)
 			cp.state = state
 		}
 	}
@@ -202,6 +237,18 @@ func (cs *checkpoints) process(now time.Time, apiAvailable bool, localRunningPod
 	return starts, stops, removes
 }
 
+// pods returns the pods of the checkpoints, including the self-checkpoint, by name.
+func (cs *checkpoints) pods() map[string]*v1.Pod {
+	pods := make(map[string]*v1.Pod, len(cs.checkpoints)+1)
+	for name, cp := range cs.checkpoints {
+		pods[name] = cp.pod
+	}
+	if cs.selfCheckpoint != nil {
+		pods[cs.selfCheckpoint.name] = cs.selfCheckpoint.pod
+	}
+	return pods
+}
+
 // createCheckpointsForValidParents will iterate through pods which are candidates for checkpointing, then:
 // - checkpoint any remote assets they need (e.g. secrets, configmaps)
 // - sanitize their podSpec, removing unnecessary information
@@ -216,8 +263,12 @@ func (c *checkpointer) createCheckpointsForValidParents() {
 		parents = append(parents, c.checkpoints.selfCheckpoint.pod)
 	}
 
+	// Watch the secrets and configMaps of the parents, and find the ones that changed.
+	c.watcher.watchObjects(referencedObjects(parents))
+	changedObjects := c.watcher.takeChangedObjects()
+
 	// Update the checkpoints.
-	needsCheckpointUpdate := lastCheckpoint.IsZero() || time.Since(lastCheckpoint) >= defaultCheckpointTimeout
+	needsCheckpointUpdate := c.lastCheckpoint.IsZero() || c.clock.Since(c.lastCheckpoint) >= defaultCheckpointTimeout
 
 	for _, pod := range parents {
 		id := podFullName(pod)
@@ -225,6 +276,9 @@ func (c *checkpointer) createCheckpointsForValidParents() {
 		cp := pod.DeepCopy()
 
 		cp = sanitizeCheckpointPod(cp)
+		if c.secrets != nil {
+			c.secrets.useDecryptedPaths(pod, cp)
+		}
 
 		podChanged, err := writeCheckpointManifest(cp)
 		if err != nil {
@@ -232,14 +286,21 @@ func (c *checkpointer) createCheckpointsForValidParents() {
 			continue
 		}
 
-		// Check for secret and configmap changes if the pods have change or they haven't been checked in a while
-		if podChanged || needsCheckpointUpdate {
+		objectsChanged := false
+		for key := range referencedObjects([]*v1.Pod{pod}) {
+			objectsChanged = objectsChanged || changedObjects[key]
+		}
+
+		// Check for secret and configmap changes if the pods or their watched secrets and configmaps
+		// have changed, or they haven't been checked in a while
+		if podChanged || objectsChanged || needsCheckpointUpdate {
 
 			_, err = c.checkpointSecretVolumes(pod)
 			if err != nil {
 				//TODO(aaron): This can end up spamming logs at times when api-server is unavailable. To reduce spam
 				//             we could only log error if api-server can't be contacted and existing secret doesn't exist.
 				glog.Errorf("Failed to checkpoint secrets for pod %s: %v", id, err)
+				c.events.checkpointFailed(pod, err)
 				continue
 			}
 
@@ -248,70 +309,187 @@ func (c *checkpointer) createCheckpointsForValidParents() {
 				//TODO(aaron): This can end up spamming logs at times when api-server is unavailable. To reduce spam
 				//             we could only log error if api-server can't be contacted and existing configmap doesn't exist.
 				glog.Errorf("Failed to checkpoint configMaps for pod %s: %v", id, err)
+				c.events.checkpointFailed(pod, err)
+				continue
+			}
+
+			_, err = c.checkpointProjectedVolumes(pod)
+			if err != nil {
+				glog.Errorf("Failed to checkpoint projected and downwardAPI volumes for pod %s: %v", id, err)
+				c.events.checkpointFailed(pod, err)
 				continue
 			}
+
+			if c.lastWrites == nil {
+				c.lastWrites = make(map[string]time.Time)
+			}
+			c.lastWrites[id] = c.clock.Now()
 		}
 	}
 
 	// If the secrets/manifests were checked update the lastCheckpoint
 	if needsCheckpointUpdate {
-		lastCheckpoint = time.Now()
+		c.lastCheckpoint = c.clock.Now()
 	}
 }
 
-func handleRemove(remove []string) {
+// handleStop deactivates checkpoints by removing their active manifests. Their decrypted secrets
+// are removed by removeTerminated once their pods terminated.
+func (c *checkpointer) handleStop(stop []string, pods map[string]*v1.Pod) {
+	now := c.clock.Now()
+	for _, id := range stop {
+		glog.Infof("Stopping active checkpoint: %s", id)
+		p := podFullNameToActiveCheckpointPath(id)
+		if err := os.Remove(p); err != nil {
+			if os.IsNotExist(err) { // Sanity check (it's fine - just want to surface this if it's occurring)
+				glog.Warningf("Attempted to remove active checkpoint, but manifest no longer exists: %s", p)
+			} else {
+				glog.Errorf("Failed to stop active checkpoint %s: %v", p, err)
+			}
+			continue
+		}
+		c.checkpoints.addTerminating(id, now.Add(terminationGracePeriod(pods[id])), false)
+	}
+}
+
+// handleRemove garbage collects checkpoints. The active and inactive manifests are removed first,
+// and the secrets, configMaps and volumes of a checkpoint whose pod may still be running are only
+// removed by removeTerminated once the pod terminated, so that a pod that is shutting down does
+// not lose its mounted credentials.
+//
+// The checkpointer cannot wait for its own termination, so its own checkpoint is removed last and
+// in the opposite order: its files before its active manifest, so that it can clean up before it
+// exits. The files of terminating checkpoints are removed along with it, as nothing would remove
+// them later.
+func (c *checkpointer) handleRemove(remove []string, pods map[string]*v1.Pod) {
+	var self string
+	now := c.clock.Now()
 	for _, id := range remove {
 		glog.Infof("Removing checkpoint of: %s", id)
-
-		// Remove Secrets
-		p := podFullNameToSecretPath(id)
-		if err := os.RemoveAll(p); err != nil {
-			glog.Errorf("Failed to remove pod secrets from %s: %s", p, err)
+		if pod := pods[id]; pod != nil && isPodCheckpointer(pod, c.checkpointerPod) {
+			self = id
+			continue
 		}
-
-		// Remove ConfipMaps
-		p = podFullNameToConfigMapPath(id)
-		if err := os.RemoveAll(p); err != nil {
-			glog.Errorf("Failed to remove pod configMaps from %s: %s", p, err)
+		p := podFullNameToActiveCheckpointPath(id)
+		if err := os.Remove(p); err != nil {
+			if !os.IsNotExist(err) {
+				// The checkpoint may still be running, so keep its files.
+				glog.Errorf("Failed to remove active checkpoint %s: %v", p, err)
+				continue
+			}
+			if _, ok := c.checkpoints.terminating[id]; !ok {
+				// The checkpoint was neither active nor stopping, so no pod uses its files.
+				c.removeFiles(id, true)
+				continue
+			}
 		}
-
-		// Remove inactive checkpoints
+		// Remove the inactive manifest right away, so that the checkpoint is not restored from it.
 		p = podFullNameToInactiveCheckpointPath(id)
 		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
 			glog.Errorf("Failed to remove inactive checkpoint %s: %v", p, err)
-			continue
 		}
+		c.checkpoints.addTerminating(id, now.Add(terminationGracePeriod(pods[id])), true)
+	}
 
-		// Remove active checkpoints.
-		// We do this as the last step because we want to clean up
-		// resources before the checkpointer itself exits.
-		//
-		// TODO(yifan): Removing the pods after removing the secrets/configmaps
-		// might disturb other pods since they might want to use the configmap
-		// or secrets during termination.
-		//
-		// However, since we are not waiting for them to terminate anyway, so it's
-		// ok to just leave as is for now. We can handle this more gracefully later.
-		p = podFullNameToActiveCheckpointPath(id)
+	if self != "" {
+		for id, t := range c.checkpoints.terminating {
+			c.removeFiles(id, t.removed)
+			delete(c.checkpoints.terminating, id)
+		}
+		c.removeFiles(self, true)
+		p := podFullNameToActiveCheckpointPath(self)
 		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
 			glog.Errorf("Failed to remove active checkpoint %s: %v", p, err)
-			continue
 		}
 	}
 }
 
-func handleStop(stop []string) {
-	for _, id := range stop {
-		glog.Infof("Stopping active checkpoint: %s", id)
-		p := podFullNameToActiveCheckpointPath(id)
-		if err := os.Remove(p); err != nil {
-			if os.IsNotExist(err) { // Sanity check (it's fine - just want to surface this if it's occurring)
-				glog.Warningf("Attempted to remove active checkpoint, but manifest no longer exists: %s", p)
-			} else {
-				glog.Errorf("Failed to stop active checkpoint %s: %v", p, err)
-			}
+// removeTerminated removes the files of stopped and removed checkpoints once the container runtime
+// no longer reports their pods, or their deadlines passed. A nil localRunningPods means that the
+// runtime could not be queried, which is no evidence that the pods terminated.
+func (c *checkpointer) removeTerminated(localRunningPods map[string]*v1.Pod) {
+	now := c.clock.Now()
+	for id, t := range c.checkpoints.terminating {
+		name := checkpointPodFullName(id, c.checkpointerPod.NodeName)
+		cp := c.checkpoints.checkpoints[id]
+		switch {
+		case cp != nil && cp.state.action() == start:
+			// The checkpoint was started again, and its pod uses the files.
+			delete(c.checkpoints.terminating, id)
+			continue
+		case cp != nil && t.removed:
+			// The parent pod was scheduled again, and its files belong to the new checkpoint. Its
+			// decrypted secrets are still removed once the old pod terminated.
+			glog.Infof("Checkpoint %s was created again while its pod %s was terminating", id, name)
+			t.removed = false
+			c.checkpoints.terminating[id] = t
+			continue
+		case localRunningPods != nil && localRunningPods[name] == nil:
+		case !now.Before(t.deadline):
+			glog.Warningf("Pod %s did not terminate within its termination grace period, removing its checkpointed files", name)
+		default:
+			continue
 		}
+		c.removeFiles(id, t.removed)
+		delete(c.checkpoints.terminating, id)
+	}
+}
+
+// removeFiles removes the decrypted secrets of a checkpoint, and all its files if it was removed.
+func (c *checkpointer) removeFiles(id string, removed bool) {
+	if removed {
+		removeCheckpointFiles(id)
+	}
+	if c.secrets != nil {
+		c.secrets.removeDecrypted([]string{id})
+	}
+}
+
+// removeCheckpointFiles removes the checkpointed secrets, configMaps and volumes, and the inactive
+// manifest of a checkpoint.
+func removeCheckpointFiles(id string) {
+	// Remove Secrets
+	p := podFullNameToSecretPath(id)
+	if err := os.RemoveAll(p); err != nil {
+		glog.Errorf("Failed to remove pod secrets from %s: %s", p, err)
+	}
+
+	// Remove ConfipMaps
+	p = podFullNameToConfigMapPath(id)
+	if err := os.RemoveAll(p); err != nil {
+		glog.Errorf("Failed to remove pod configMaps from %s: %s", p, err)
 	}
+
+	// Remove projected and downwardAPI volumes
+	p = podFullNameToVolumePath(id)
+	if err := os.RemoveAll(p); err != nil {
+		glog.Errorf("Failed to remove pod volumes from %s: %s", p, err)
+	}
+
+	// Remove inactive checkpoints
+	p = podFullNameToInactiveCheckpointPath(id)
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Failed to remove inactive checkpoint %s: %v", p, err)
+	}
+}
+
+// terminationGracePeriod returns how long to wait for the pod of a removed checkpoint to terminate.
+func terminationGracePeriod(pod *v1.Pod) time.Duration {
+	seconds := int64(v1.DefaultTerminationGracePeriodSeconds)
+	if pod != nil && pod.Spec.TerminationGracePeriodSeconds != nil {
+		seconds = *pod.Spec.TerminationGracePeriodSeconds
+	}
+	d := time.Duration(seconds) * time.Second
+	if d > maxTerminationWait {
+		d = maxTerminationWait
+	}
+	return d
+}
+
+// checkpointPodFullName returns the namespace/name that the kubelet runs the static pod of an
+// active checkpoint as, which has the node name as suffix.
+func checkpointPodFullName(id, nodeName string) string {
+	return id + "-" + strings.ToLower(nodeName)
 }
 
 func handleStart(start []string) {
@@ -24,6 +24,7 @@ func TestProcess(t *testing.T) {
 		expectGraceStop     []string
 		expectGraceRemove   []string
 		podName             string
+		partitioned         bool
 	}
 
 	cases := []testCase{
@@ -32,6 +33,11 @@ func TestProcess(t *testing.T) {
 			inactiveCheckpoints: map[string]*v1.Pod{"AA": {}},
 			expectStart:         []string{"AA"},
 		},
+		{
+			desc:                "Inactive checkpoint, no local running and apiserver partitioned: no change",
+			inactiveCheckpoints: map[string]*v1.Pod{"AA": {}},
+			partitioned:         true,
+		},
 		{
 			desc:                "Inactive checkpoint and local running: no change",
 			inactiveCheckpoints: map[string]*v1.Pod{"AA": {}},
@@ -330,12 +336,12 @@ func TestProcess(t *testing.T) {
 		// Run test now.
 		now := time.Time{}
 		c.update(tc.localRunning, tc.localParents, tc.apiParents, tc.activeCheckpoints, tc.inactiveCheckpoints, cp)
-		gotStart, gotStop, gotRemove := c.process(now, tc.apiParents != nil, tc.localRunning, tc.localParents, tc.apiParents)
+		gotStart, gotStop, gotRemove := c.process(now, tc.apiParents != nil, tc.partitioned, tc.localRunning, tc.localParents, tc.apiParents)
 
 		// Advance past grace period and test again.
 		now = now.Add(checkpointGracePeriod)
 		c.update(tc.localRunning, tc.localParents, tc.apiParents, tc.activeCheckpoints, tc.inactiveCheckpoints, cp)
-		gotGraceStart, gotGraceStop, gotGraceRemove := c.process(now, tc.apiParents != nil, tc.localRunning, tc.localParents, tc.apiParents)
+		gotGraceStart, gotGraceStop, gotGraceRemove := c.process(now, tc.apiParents != nil, tc.partitioned, tc.localRunning, tc.localParents, tc.apiParents)
 		if !reflect.DeepEqual(tc.expectStart, gotStart) ||
 			!reflect.DeepEqual(tc.expectStop, gotStop) ||
 			!reflect.DeepEqual(tc.expectRemove, gotRemove) ||
@@ -346,3 +352,20 @@ func TestProcess(t *testing.T) {
 		}
 	}
 }
+
+func TestTerminationGracePeriod(t *testing.T) {
+	seconds := func(s int64) *int64 { return &s }
+	for _, tc := range []struct {
+		pod  *v1.Pod
+		want time.Duration
+	}{
+		{pod: nil, want: 30 * time.Second},
+		{pod: &v1.Pod{}, want: 30 * time.Second},
+		{pod: &v1.Pod{Spec: v1.PodSpec{TerminationGracePeriodSeconds: seconds(5)}}, want: 5 * time.Second},
+		{pod: &v1.Pod{Spec: v1.PodSpec{TerminationGracePeriodSeconds: seconds(3600)}}, want: maxTerminationWait},
+	} {
+		if got := terminationGracePeriod(tc.pod); got != tc.want {
+			t.Errorf("terminationGracePeriod(%v) = %v, want: %v", tc.pod, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+)
+
+const recordingVersion = 1
+
+// recordingHeader is the first line of a recording. It holds the configuration of the checkpointer
+// and the checkpoints it restored when it started.
+type recordingHeader struct {
+	Version               int                `json:"version"`
+	CheckpointerPod       CheckpointerPod    `json:"checkpointerPod"`
+	CheckpointGracePeriod time.Duration      `json:"checkpointGracePeriod"`
+	ActiveCheckpoints     map[string]*v1.Pod `json:"activeCheckpoints"`
+	InactiveCheckpoints   map[string]*v1.Pod `json:"inactiveCheckpoints"`
+	Checkpoints           []journalEntry     `json:"checkpoints"`
+}
+
+// recordedSync is a line of a recording after the header. It holds the inputs of an iteration of
+// the checkpointer, and the actions it decided on.
+type recordedSync struct {
+	Time            time.Time          `json:"time"`
+	LocalParentPods map[string]*v1.Pod `json:"localParentPods"`
+	// LocalRunningPods is null if the container runtime could not be queried.
+	LocalRunningPods map[string]*v1.Pod `json:"localRunningPods"`
+	APIAvailable     bool               `json:"apiAvailable"`
+	Partitioned      bool               `json:"partitioned,omitempty"`
+	APIParentPods    map[string]*v1.Pod `json:"apiParentPods"`
+	Start            []string           `json:"start,omitempty"`
+	Stop             []string           `json:"stop,omitempty"`
+	Remove           []string           `json:"remove,omitempty"`
+}
+
+// inputRecorder records the inputs of every iteration of the checkpointer as JSON lines, so that
+// they can be replayed in a simulation. Recordings hold the specs of the parent pods, but not the
+// contents of their secrets and configMaps.
+type inputRecorder struct {
+	path string
+	enc  *json.Encoder
+}
+
+// newInputRecorder returns an inputRecorder that replaces the file at path.
+func newInputRecorder(path string) (*inputRecorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &inputRecorder{path: path, enc: json.NewEncoder(f)}, nil
+}
+
+// recordStart records the header of the recording, after the checkpointer restored its checkpoints.
+func (r *inputRecorder) recordStart(c *checkpointer) {
+	r.write(recordingHeader{
+		Version:               recordingVersion,
+		CheckpointerPod:       c.checkpointerPod,
+		CheckpointGracePeriod: checkpointGracePeriod,
+		ActiveCheckpoints:     c.activeManifests.pods,
+		InactiveCheckpoints:   c.inactiveManifests.pods,
+		Checkpoints:           journalEntries(&c.checkpoints),
+	})
+}
+
+// recordSync records an iteration.
+func (r *inputRecorder) recordSync(s recordedSync) {
+	r.write(s)
+}
+
+func (r *inputRecorder) write(v interface{}) {
+	if err := r.enc.Encode(v); err != nil {
+		glog.Errorf("Failed to record checkpointer inputs to %s: %v", r.path, err)
+	}
+}
+
+// readRecording reads a recording written by inputRecorder.
+func readRecording(r io.Reader) (*recordingHeader, []recordedSync, error) {
+	s := bufio.NewScanner(r)
+	// Lines hold all parent pods, which may exceed the default limit of bufio.Scanner.
+	s.Buffer(nil, 64*1024*1024)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("recording is empty")
+	}
+	var header recordingHeader
+	if err := json.Unmarshal(s.Bytes(), &header); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode recording header: %v", err)
+	}
+	if header.Version != recordingVersion {
+		return nil, nil, fmt.Errorf("unsupported recording version %d", header.Version)
+	}
+	var syncs []recordedSync
+	for s.Scan() {
+		var sync recordedSync
+		if err := json.Unmarshal(s.Bytes(), &sync); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode iteration %d of recording: %v", len(syncs)+1, err)
+		}
+		syncs = append(syncs, sync)
+	}
+	return &header, syncs, s.Err()
+}
@@ -2,13 +2,22 @@ package checkpoint
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
+	runtimev1 "github.com/kubernetes-sigs/bootkube/pkg/checkpoint/cri/v1"
 	"github.com/kubernetes-sigs/bootkube/pkg/checkpoint/cri/v1alpha1"
 	"github.com/kubernetes-sigs/bootkube/pkg/checkpoint/cri/v1alpha2"
 	"github.com/kubernetes-sigs/bootkube/pkg/checkpoint/internal"
@@ -23,30 +32,230 @@ const (
 	kubernetesContainerTypeLabel = "io.kubernetes.container.type"
 )
 
+// CRI API versions, in the order in which they are negotiated.
+const (
+	runtimeAPIV1       = "v1"
+	runtimeAPIV1alpha2 = "v1alpha2"
+	runtimeAPIV1alpha1 = "v1alpha1"
+)
+
+// defaultRuntimeEndpoints are the endpoints that are tried, in order, if no runtime endpoint is
+// configured.
+var defaultRuntimeEndpoints = []string{
+	"unix:///var/run/containerd/containerd.sock",
+	"unix:///var/run/crio/crio.sock",
+	"unix:///var/run/cri-dockerd.sock",
+	"unix:///var/run/dockershim.sock",
+}
+
+// Bounds of the delay between re-dials of the runtime endpoint while requests keep failing.
+const (
+	minRuntimeReconnectDelay = 1 * time.Second
+	maxRuntimeReconnectDelay = 30 * time.Second
+)
+
+// runtimeConfig configures a remoteRuntimeService.
+type runtimeConfig struct {
+	// endpoint is the CRI endpoint. It is detected among defaultRuntimeEndpoints if it is empty.
+	endpoint string
+	timeout  time.Duration
+	// tls is the client TLS configuration of tcp endpoints. Connections are insecure if it is nil.
+	tls *tls.Config
+}
+
+// newRuntimeConfig returns the configuration of the runtime service for the checkpointer options.
+func newRuntimeConfig(opts Options) (runtimeConfig, error) {
+	rc := runtimeConfig{
+		endpoint: opts.RemoteRuntimeEndpoint,
+		timeout:  opts.RuntimeRequestTimeout,
+	}
+	if opts.RuntimeTLSCAFile == "" && opts.RuntimeTLSCertFile == "" && opts.RuntimeTLSKeyFile == "" {
+		return rc, nil
+	}
+	if !strings.HasPrefix(rc.endpoint, "tcp://") {
+		return rc, fmt.Errorf("TLS is only supported for tcp runtime endpoints, not %q", rc.endpoint)
+	}
+
+	rc.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.RuntimeTLSCAFile != "" {
+		ca, err := ioutil.ReadFile(opts.RuntimeTLSCAFile)
+		if err != nil {
+			return rc, fmt.Errorf("failed to read runtime CA: %v", err)
+		}
+		rc.tls.RootCAs = x509.NewCertPool()
+		if !rc.tls.RootCAs.AppendCertsFromPEM(ca) {
+			return rc, fmt.Errorf("no certificates found in runtime CA %s", opts.RuntimeTLSCAFile)
+		}
+	}
+	if opts.RuntimeTLSCertFile != "" || opts.RuntimeTLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.RuntimeTLSCertFile, opts.RuntimeTLSKeyFile)
+		if err != nil {
+			return rc, fmt.Errorf("failed to load runtime client certificate: %v", err)
+		}
+		rc.tls.Certificates = []tls.Certificate{cert}
+	}
+	return rc, nil
+}
+
+// remoteRuntimeService is a minimal CRI client. gRPC reconnects to a restarted runtime by itself;
+// if requests keep failing, the endpoint is detected and dialed again, see requestFailed.
 type remoteRuntimeService struct {
-	timeout        time.Duration
-	v1alpha1Client v1alpha1.RuntimeServiceClient
+	config         runtimeConfig
+	conn           *grpc.ClientConn
+	v1Client       runtimev1.RuntimeServiceClient
 	v1alpha2Client v1alpha2.RuntimeServiceClient
+	v1alpha1Client v1alpha1.RuntimeServiceClient
+	// apiVersion is the negotiated CRI API version, or empty if it has not been negotiated yet.
+	apiVersion string
+	// reconnectDelay is the delay before the next re-dial, and nextReconnect when it is due. Both
+	// are zero while requests succeed.
+	reconnectDelay time.Duration
+	nextReconnect  time.Time
+}
+
+func newRemoteRuntimeService(config runtimeConfig) (*remoteRuntimeService, error) {
+	r := &remoteRuntimeService{config: config}
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
-func newRemoteRuntimeService(endpoint string, connectionTimeout time.Duration) (*remoteRuntimeService, error) {
+// connect dials the runtime endpoint, replacing the current connection. The connection is
+// established lazily, by the first request.
+func (r *remoteRuntimeService) connect() error {
+	endpoint := r.config.endpoint
+	if endpoint == "" {
+		var err error
+		if endpoint, err = detectRuntimeEndpoint(defaultRuntimeEndpoints); err != nil {
+			return err
+		}
+	}
+
 	glog.Infof("Connecting to runtime service %s", endpoint)
 	addr, dialer, err := internal.GetAddressAndDialer(endpoint)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	conn, err := grpc.Dial(addr, grpc.WithInsecure(), grpc.WithTimeout(connectionTimeout), grpc.WithDialer(dialer))
+	creds := grpc.WithInsecure()
+	if r.config.tls != nil {
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(r.config.tls))
+	}
+	conn, err := grpc.Dial(addr, creds, grpc.WithDialer(dialer), grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff: backoff.Config{
+			BaseDelay:  minRuntimeReconnectDelay,
+			Multiplier: backoff.DefaultConfig.Multiplier,
+			Jitter:     backoff.DefaultConfig.Jitter,
+			MaxDelay:   maxRuntimeReconnectDelay,
+		},
+		MinConnectTimeout: r.config.timeout,
+	}))
 	if err != nil {
 		glog.Errorf("Connect remote runtime %s failed: %v", addr, err)
-		return nil, err
+		return err
+	}
+
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.conn = conn
+	r.v1Client = runtimev1.NewRuntimeServiceClient(conn)
+	r.v1alpha2Client = v1alpha2.NewRuntimeServiceClient(conn)
+	r.v1alpha1Client = v1alpha1.NewRuntimeServiceClient(conn)
+	r.resetAPIVersion()
+	return nil
+}
+
+// requestFailed handles a failed request. gRPC's connection backoff is reset, so that a restarted
+// runtime is reached by the next request rather than after the backoff. If requests keep failing,
+// e.g. because the runtime was replaced by one at another detected endpoint, the endpoint is
+// dialed again with an exponential backoff of up to maxRuntimeReconnectDelay.
+func (r *remoteRuntimeService) requestFailed() {
+	r.resetAPIVersion()
+	r.conn.ResetConnectBackoff()
+
+	now := time.Now()
+	if r.reconnectDelay == 0 {
+		r.reconnectDelay = minRuntimeReconnectDelay
+		r.nextReconnect = now.Add(r.reconnectDelay)
+		return
+	}
+	if now.Before(r.nextReconnect) {
+		return
+	}
+	glog.Warningf("Requests to the container runtime keep failing, reconnecting")
+	if err := r.connect(); err != nil {
+		glog.Errorf("Failed to reconnect to the container runtime: %v", err)
 	}
+	if r.reconnectDelay *= 2; r.reconnectDelay > maxRuntimeReconnectDelay {
+		r.reconnectDelay = maxRuntimeReconnectDelay
+	}
+	r.nextReconnect = now.Add(r.reconnectDelay)
+}
 
-	return &remoteRuntimeService{
-		timeout:        connectionTimeout,
-		v1alpha1Client: v1alpha1.NewRuntimeServiceClient(conn),
-		v1alpha2Client: v1alpha2.NewRuntimeServiceClient(conn),
-	}, nil
+// requestSucceeded resets the reconnect backoff after a successful request.
+func (r *remoteRuntimeService) requestSucceeded() {
+	r.reconnectDelay = 0
+	r.nextReconnect = time.Time{}
+}
+
+// detectRuntimeEndpoint returns the first of endpoints whose unix socket exists.
+func detectRuntimeEndpoint(endpoints []string) (string, error) {
+	for _, endpoint := range endpoints {
+		addr, _, err := internal.GetAddressAndDialer(endpoint)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			glog.Infof("Detected runtime endpoint %s", endpoint)
+			return endpoint, nil
+		}
+	}
+	return "", fmt.Errorf("no container runtime found at %s, use --container-runtime-endpoint to set one", strings.Join(endpoints, ", "))
+}
+
+// negotiateAPIVersion returns the newest CRI API version that the runtime serves: v1, v1alpha2 or
+// v1alpha1. The version is remembered until resetAPIVersion is called.
+func (r *remoteRuntimeService) negotiateAPIVersion(ctx context.Context) (string, error) {
+	if r.apiVersion != "" {
+		return r.apiVersion, nil
+	}
+
+	var errs []string
+	v1Resp, err := r.v1Client.Version(ctx, &runtimev1.VersionRequest{})
+	if err == nil {
+		r.setAPIVersion(runtimeAPIV1, v1Resp.RuntimeName, v1Resp.RuntimeVersion)
+		return r.apiVersion, nil
+	}
+	errs = append(errs, fmt.Sprintf("%s: %v", runtimeAPIV1, err))
+
+	v1alpha2Resp, err := r.v1alpha2Client.Version(ctx, &v1alpha2.VersionRequest{})
+	if err == nil {
+		r.setAPIVersion(runtimeAPIV1alpha2, v1alpha2Resp.RuntimeName, v1alpha2Resp.RuntimeVersion)
+		return r.apiVersion, nil
+	}
+	errs = append(errs, fmt.Sprintf("%s: %v", runtimeAPIV1alpha2, err))
+
+	v1alpha1Resp, err := r.v1alpha1Client.Version(ctx, &v1alpha1.VersionRequest{})
+	if err == nil {
+		r.setAPIVersion(runtimeAPIV1alpha1, v1alpha1Resp.RuntimeName, v1alpha1Resp.RuntimeVersion)
+		return r.apiVersion, nil
+	}
+	errs = append(errs, fmt.Sprintf("%s: %v", runtimeAPIV1alpha1, err))
+
+	return "", fmt.Errorf("failed to negotiate CRI API version: %s", strings.Join(errs, "; "))
+}
+
+func (r *remoteRuntimeService) setAPIVersion(version, runtimeName, runtimeVersion string) {
+	glog.Infof("Using CRI API %s of runtime %s %s", version, runtimeName, runtimeVersion)
+	r.apiVersion = version
+}
+
+// resetAPIVersion forgets the negotiated CRI API version after a failed request or a reconnect, in
+// case the runtime was replaced by one that serves a different version.
+func (r *remoteRuntimeService) resetAPIVersion() {
+	r.apiVersion = ""
 }
 
 // localRunningPods uses the CRI shim to retrieve the local container runtime pod state
@@ -57,6 +266,8 @@ func (r *remoteRuntimeService) localRunningPods() map[string]*v1.Pod {
 	sandboxes, err := r.getRunningKubeletSandboxes()
 	if err != nil {
 		glog.Errorf("failed to list running sandboxes: %v", err)
+		recordError(errorSourceCRI)
+		r.requestFailed()
 		return nil
 	}
 
@@ -76,8 +287,11 @@ func (r *remoteRuntimeService) localRunningPods() map[string]*v1.Pod {
 	containers, err := r.getRunningKubeletContainers()
 	if err != nil {
 		glog.Errorf("failed to list running containers: %v", err)
+		recordError(errorSourceCRI)
+		r.requestFailed()
 		return nil
 	}
+	r.requestSucceeded()
 
 	// Add all pods that containers are apart of
 	for _, c := range containers {
@@ -108,11 +322,57 @@ type criSandbox struct {
 }
 
 func (r *remoteRuntimeService) getRunningKubeletContainers() ([]criContainer, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.timeout)
 	defer cancel()
 
+	version, err := r.negotiateAPIVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var containers []criContainer
-	if _, err := r.v1alpha1Client.Version(ctx, &v1alpha1.VersionRequest{}); err == nil {
+	switch version {
+	case runtimeAPIV1:
+		resp, err := r.v1Client.ListContainers(ctx, &runtimev1.ListContainersRequest{
+			Filter: &runtimev1.ContainerFilter{
+				State: &runtimev1.ContainerStateValue{
+					// Filter out non-running containers
+					State: runtimev1.ContainerState_CONTAINER_RUNNING,
+				},
+			},
+		})
+		if err != nil {
+			glog.Errorf("ListContainers with filter from runtime service failed: %v", err)
+			return nil, err
+		}
+		for _, c := range resp.Containers {
+			if c.Metadata == nil {
+				glog.V(4).Infof("Container does not have metadata: %+v", c)
+				continue
+			}
+			containers = append(containers, criContainer{Labels: c.Labels})
+		}
+	case runtimeAPIV1alpha2:
+		resp, err := r.v1alpha2Client.ListContainers(ctx, &v1alpha2.ListContainersRequest{
+			Filter: &v1alpha2.ContainerFilter{
+				State: &v1alpha2.ContainerStateValue{
+					// Filter out non-running containers
+					State: v1alpha2.ContainerState_CONTAINER_RUNNING,
+				},
+			},
+		})
+		if err != nil {
+			glog.Errorf("ListContainers with filter from runtime service failed: %v", err)
+			return nil, err
+		}
+		for _, c := range resp.Containers {
+			if c.Metadata == nil {
+				glog.V(4).Infof("Container does not have metadata: %+v", c)
+				continue
+			}
+			containers = append(containers, criContainer{Labels: c.Labels})
+		}
+	case runtimeAPIV1alpha1:
 		resp, err := r.v1alpha1Client.ListContainers(ctx, &v1alpha1.ListContainersRequest{
 			Filter: &v1alpha1.ContainerFilter{
 				State: &v1alpha1.ContainerStateValue{
@@ -132,38 +392,72 @@ func (r *remoteRuntimeService) getRunningKubeletContainers() ([]criContainer, er
 			}
 			containers = append(containers, criContainer{Labels: c.Labels})
 		}
-		return containers, nil
-	}
-
-	// Try v1alpha2
-	resp, err := r.v1alpha2Client.ListContainers(ctx, &v1alpha2.ListContainersRequest{
-		Filter: &v1alpha2.ContainerFilter{
-			State: &v1alpha2.ContainerStateValue{
-				// Filter out non-running containers
-				State: v1alpha2.ContainerState_CONTAINER_RUNNING,
-			},
-		},
-	})
-	if err != nil {
-		glog.Errorf("ListContainers with filter from runtime service failed: %v", err)
-		return nil, err
-	}
-	for _, c := range resp.Containers {
-		if c.Metadata == nil {
-			glog.V(4).Infof("Container does not have metadata: %+v", c)
-			continue
-		}
-		containers = append(containers, criContainer{Labels: c.Labels})
 	}
 	return containers, nil
 }
 
 func (r *remoteRuntimeService) getRunningKubeletSandboxes() ([]criSandbox, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.timeout)
 	defer cancel()
 
+	version, err := r.negotiateAPIVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var sandboxes []criSandbox
-	if _, err := r.v1alpha1Client.Version(ctx, &v1alpha1.VersionRequest{}); err == nil {
+	switch version {
+	case runtimeAPIV1:
+		resp, err := r.v1Client.ListPodSandbox(ctx, &runtimev1.ListPodSandboxRequest{
+			Filter: &runtimev1.PodSandboxFilter{
+				// Filter out non-running sandboxes
+				State: &runtimev1.PodSandboxStateValue{
+					State: runtimev1.PodSandboxState_SANDBOX_READY,
+				},
+			},
+		})
+		if err != nil {
+			glog.Errorf("ListPodSandbox with filter from runtime service failed: %v", err)
+			return nil, err
+		}
+		for _, c := range resp.Items {
+			if c.Metadata == nil {
+				glog.V(4).Infof("Sandbox does not have metadata: %+v", c)
+				continue
+			}
+			sandboxes = append(sandboxes, criSandbox{
+				Uid:       c.Metadata.Uid,
+				Name:      c.Metadata.Name,
+				Namespace: c.Metadata.Namespace,
+				Labels:    c.Labels,
+			})
+		}
+	case runtimeAPIV1alpha2:
+		resp, err := r.v1alpha2Client.ListPodSandbox(ctx, &v1alpha2.ListPodSandboxRequest{
+			Filter: &v1alpha2.PodSandboxFilter{
+				// Filter out non-running sandboxes
+				State: &v1alpha2.PodSandboxStateValue{
+					State: v1alpha2.PodSandboxState_SANDBOX_READY,
+				},
+			},
+		})
+		if err != nil {
+			glog.Errorf("ListPodSandbox with filter from runtime service failed: %v", err)
+			return nil, err
+		}
+		for _, c := range resp.Items {
+			if c.Metadata == nil {
+				glog.V(4).Infof("Sandbox does not have metadata: %+v", c)
+				continue
+			}
+			sandboxes = append(sandboxes, criSandbox{
+				Uid:       c.Metadata.Uid,
+				Name:      c.Metadata.Name,
+				Namespace: c.Metadata.Namespace,
+				Labels:    c.Labels,
+			})
+		}
+	case runtimeAPIV1alpha1:
 		resp, err := r.v1alpha1Client.ListPodSandbox(ctx, &v1alpha1.ListPodSandboxRequest{
 			Filter: &v1alpha1.PodSandboxFilter{
 				// Filter out non-running sandboxes
@@ -188,31 +482,6 @@ func (r *remoteRuntimeService) getRunningKubeletSandboxes() ([]criSandbox, error
 				Labels:    c.Labels,
 			})
 		}
-		return sandboxes, nil
-	}
-	resp, err := r.v1alpha2Client.ListPodSandbox(ctx, &v1alpha2.ListPodSandboxRequest{
-		Filter: &v1alpha2.PodSandboxFilter{
-			// Filter out non-running sandboxes
-			State: &v1alpha2.PodSandboxStateValue{
-				State: v1alpha2.PodSandboxState_SANDBOX_READY,
-			},
-		},
-	})
-	if err != nil {
-		glog.Errorf("ListPodSandbox with filter from runtime service failed: %v", err)
-		return nil, err
-	}
-	for _, c := range resp.Items {
-		if c.Metadata == nil {
-			glog.V(4).Infof("Sandbox does not have metadata: %+v", c)
-			continue
-		}
-		sandboxes = append(sandboxes, criSandbox{
-			Uid:       c.Metadata.Uid,
-			Name:      c.Metadata.Name,
-			Namespace: c.Metadata.Namespace,
-			Labels:    c.Labels,
-		})
 	}
 	return sandboxes, nil
 }
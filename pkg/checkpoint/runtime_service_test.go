@@ -0,0 +1,362 @@
+package checkpoint
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	runtimev1 "github.com/kubernetes-sigs/bootkube/pkg/checkpoint/cri/v1"
+	"github.com/kubernetes-sigs/bootkube/pkg/checkpoint/cri/v1alpha2"
+)
+
+// fakeV1Runtime is a runtime that serves the v1 CRI API.
+type fakeV1Runtime struct {
+	runtimev1.UnimplementedRuntimeServiceServer
+}
+
+func (fakeV1Runtime) Version(context.Context, *runtimev1.VersionRequest) (*runtimev1.VersionResponse, error) {
+	return &runtimev1.VersionResponse{RuntimeName: "fake", RuntimeVersion: "1.0.0"}, nil
+}
+
+func (fakeV1Runtime) ListPodSandbox(context.Context, *runtimev1.ListPodSandboxRequest) (*runtimev1.ListPodSandboxResponse, error) {
+	return &runtimev1.ListPodSandboxResponse{Items: []*runtimev1.PodSandbox{{
+		Metadata: &runtimev1.PodSandboxMetadata{Name: "kube-apiserver", Namespace: "kube-system", Uid: "1"},
+	}}}, nil
+}
+
+func (fakeV1Runtime) ListContainers(context.Context, *runtimev1.ListContainersRequest) (*runtimev1.ListContainersResponse, error) {
+	return &runtimev1.ListContainersResponse{Containers: []*runtimev1.Container{{
+		Metadata: &runtimev1.ContainerMetadata{Name: "kube-scheduler"},
+		Labels: map[string]string{
+			kubernetesPodNameLabel:      "kube-scheduler",
+			kubernetesPodNamespaceLabel: "kube-system",
+			kubernetesPodUIDLabel:       "2",
+		},
+	}}}, nil
+}
+
+// fakeV1alpha2Runtime is a runtime that serves the v1alpha2 CRI API.
+type fakeV1alpha2Runtime struct {
+	v1alpha2.UnimplementedRuntimeServiceServer
+}
+
+func (fakeV1alpha2Runtime) Version(context.Context, *v1alpha2.VersionRequest) (*v1alpha2.VersionResponse, error) {
+	return &v1alpha2.VersionResponse{RuntimeName: "fake", RuntimeVersion: "0.1.0"}, nil
+}
+
+func (fakeV1alpha2Runtime) ListPodSandbox(context.Context, *v1alpha2.ListPodSandboxRequest) (*v1alpha2.ListPodSandboxResponse, error) {
+	return &v1alpha2.ListPodSandboxResponse{Items: []*v1alpha2.PodSandbox{{
+		Metadata: &v1alpha2.PodSandboxMetadata{Name: "kube-apiserver", Namespace: "kube-system", Uid: "1"},
+	}}}, nil
+}
+
+func (fakeV1alpha2Runtime) ListContainers(context.Context, *v1alpha2.ListContainersRequest) (*v1alpha2.ListContainersResponse, error) {
+	return &v1alpha2.ListContainersResponse{}, nil
+}
+
+// startFakeRuntime serves the given CRI API versions on a unix socket in dir, and returns its
+// endpoint.
+func startFakeRuntime(t *testing.T, dir string, versions ...string) (string, func()) {
+	t.Helper()
+	socket := filepath.Join(dir, "runtime.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "unix://" + socket, serveFakeRuntime(l, nil, versions...)
+}
+
+// serveFakeRuntime serves the given CRI API versions on l, and returns a function that stops it.
+func serveFakeRuntime(l net.Listener, opts []grpc.ServerOption, versions ...string) func() {
+	s := grpc.NewServer(opts...)
+	for _, v := range versions {
+		switch v {
+		case runtimeAPIV1:
+			runtimev1.RegisterRuntimeServiceServer(s, &fakeV1Runtime{})
+		case runtimeAPIV1alpha2:
+			v1alpha2.RegisterRuntimeServiceServer(s, &fakeV1alpha2Runtime{})
+		}
+	}
+	go s.Serve(l)
+	return s.Stop
+}
+
+func TestRemoteRuntimeServiceNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		desc        string
+		served      []string
+		wantVersion string
+		wantPods    []string
+	}{
+		{
+			desc:        "v1",
+			served:      []string{runtimeAPIV1},
+			wantVersion: runtimeAPIV1,
+			wantPods:    []string{"kube-system/kube-apiserver", "kube-system/kube-scheduler"},
+		},
+		{
+			desc:        "v1alpha2",
+			served:      []string{runtimeAPIV1alpha2},
+			wantVersion: runtimeAPIV1alpha2,
+			wantPods:    []string{"kube-system/kube-apiserver"},
+		},
+		{
+			desc:        "v1 is preferred",
+			served:      []string{runtimeAPIV1alpha2, runtimeAPIV1},
+			wantVersion: runtimeAPIV1,
+			wantPods:    []string{"kube-system/kube-apiserver", "kube-system/kube-scheduler"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "runtime")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			endpoint, stop := startFakeRuntime(t, dir, tc.served...)
+			defer stop()
+
+			r, err := newRemoteRuntimeService(runtimeConfig{endpoint: endpoint, timeout: 5 * time.Second})
+			if err != nil {
+				t.Fatalf("newRemoteRuntimeService() = %v, want: nil", err)
+			}
+			pods := r.localRunningPods()
+			if r.apiVersion != tc.wantVersion {
+				t.Errorf("negotiated version = %q, want: %q", r.apiVersion, tc.wantVersion)
+			}
+			if len(pods) != len(tc.wantPods) {
+				t.Errorf("localRunningPods() = %v, want: %v", pods, tc.wantPods)
+			}
+			for _, name := range tc.wantPods {
+				if _, ok := pods[name]; !ok {
+					t.Errorf("localRunningPods() = %v, want: %v", pods, tc.wantPods)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteRuntimeServiceNoVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	endpoint, stop := startFakeRuntime(t, dir)
+	defer stop()
+
+	r, err := newRemoteRuntimeService(runtimeConfig{endpoint: endpoint, timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("newRemoteRuntimeService() = %v, want: nil", err)
+	}
+	if _, err := r.negotiateAPIVersion(context.Background()); err == nil {
+		t.Errorf("negotiateAPIVersion() with a runtime that serves no known version = nil, want: non-nil")
+	}
+}
+
+func TestDetectRuntimeEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	endpoint, stop := startFakeRuntime(t, dir)
+	defer stop()
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.sock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := "unix://" + filepath.Join(dir, "missing.sock")
+	file := "unix://" + filepath.Join(dir, "file.sock")
+	got, err := detectRuntimeEndpoint([]string{missing, file, endpoint})
+	if err != nil || got != endpoint {
+		t.Errorf("detectRuntimeEndpoint() = %q, %v, want: %q, nil", got, err, endpoint)
+	}
+	if _, err := detectRuntimeEndpoint([]string{missing, file}); err == nil {
+		t.Errorf("detectRuntimeEndpoint() without a socket = nil, want: non-nil")
+	}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key to dir. It is used as
+// the CA, the serving certificate and the client certificate.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "runtime"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "runtime.crt"), filepath.Join(dir, "runtime.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestRemoteRuntimeServiceTCP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	// The TLS runtime requires a client certificate signed by the test CA.
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(ca)
+	serverCreds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+
+	listen := func() net.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	plain := listen()
+	defer serveFakeRuntime(plain, nil, runtimeAPIV1)()
+	secure := listen()
+	defer serveFakeRuntime(secure, []grpc.ServerOption{grpc.Creds(serverCreds)}, runtimeAPIV1)()
+
+	for _, tc := range []struct {
+		desc     string
+		endpoint string
+		caFile   string
+		certFile string
+		keyFile  string
+		wantErr  bool
+		wantPods bool
+	}{
+		{desc: "insecure", endpoint: "tcp://" + plain.Addr().String(), wantPods: true},
+		{desc: "TLS", endpoint: "tcp://" + secure.Addr().String(), caFile: certFile, certFile: certFile, keyFile: keyFile, wantPods: true},
+		{desc: "TLS without client certificate", endpoint: "tcp://" + secure.Addr().String(), caFile: certFile, wantPods: false},
+		{desc: "TLS on a unix socket", endpoint: "unix://" + filepath.Join(dir, "runtime.sock"), caFile: certFile, wantErr: true},
+		{desc: "missing client key", endpoint: "tcp://" + secure.Addr().String(), certFile: certFile, wantErr: true},
+	} {
+		config, err := newRuntimeConfig(Options{
+			RemoteRuntimeEndpoint: tc.endpoint,
+			RuntimeRequestTimeout: 5 * time.Second,
+			RuntimeTLSCAFile:      tc.caFile,
+			RuntimeTLSCertFile:    tc.certFile,
+			RuntimeTLSKeyFile:     tc.keyFile,
+		})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: newRuntimeConfig() = %v, want error: %t", tc.desc, err, tc.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		r, err := newRemoteRuntimeService(config)
+		if err != nil {
+			t.Fatalf("%s: newRemoteRuntimeService() = %v, want: nil", tc.desc, err)
+		}
+		if pods := r.localRunningPods(); (pods != nil) != tc.wantPods {
+			t.Errorf("%s: localRunningPods() = %v, want pods: %t", tc.desc, pods, tc.wantPods)
+		}
+		r.conn.Close()
+	}
+}
+
+func TestRemoteRuntimeServiceReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	endpoint, stop := startFakeRuntime(t, dir, runtimeAPIV1)
+
+	// The endpoint is auto-detected, so that it is detected again when the runtime moves.
+	moved := filepath.Join(dir, "moved")
+	if err := os.Mkdir(moved, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer func(endpoints []string) { defaultRuntimeEndpoints = endpoints }(defaultRuntimeEndpoints)
+	defaultRuntimeEndpoints = []string{endpoint, "unix://" + filepath.Join(moved, "runtime.sock")}
+
+	r, err := newRemoteRuntimeService(runtimeConfig{timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("newRemoteRuntimeService() = %v, want: nil", err)
+	}
+	if pods := r.localRunningPods(); pods == nil {
+		t.Fatalf("localRunningPods() = nil, want: pods")
+	}
+
+	// waitForPods polls the runtime until it answers.
+	waitForPods := func(desc string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for r.localRunningPods() == nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: localRunningPods() = nil after 10s, want: pods", desc)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	stop()
+	if pods := r.localRunningPods(); pods != nil {
+		t.Errorf("localRunningPods() of a stopped runtime = %v, want: nil", pods)
+	}
+	if r.reconnectDelay == 0 {
+		t.Errorf("reconnect delay after a failed request = 0, want: non-zero")
+	}
+	_, stop = startFakeRuntime(t, dir, runtimeAPIV1)
+	waitForPods("restarted runtime")
+	if r.reconnectDelay != 0 {
+		t.Errorf("reconnect delay after a successful request = %v, want: 0", r.reconnectDelay)
+	}
+
+	// The runtime moves to the second endpoint, which is only found by detecting it again.
+	stop()
+	_, stop = startFakeRuntime(t, moved, runtimeAPIV1)
+	defer stop()
+	waitForPods("moved runtime")
+}
@@ -1,14 +1,11 @@
 package checkpoint
 
 import (
-	"context"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // checkpointSecretVolumes ensures that all pod secrets are checkpointed locally, then converts the secret volume to a hostpath.
@@ -24,41 +21,20 @@ func (c *checkpointer) checkpointSecretVolumes(pod *corev1.Pod) (*corev1.Pod, er
 			continue
 		}
 
-		_, err := c.checkpointSecret(pod.Namespace, pod.Name, v.Secret.SecretName, uid, gid)
+		files, err := c.secretFiles(pod.Namespace, v.Secret.SecretName, v.Secret.Items, v.Secret.DefaultMode, v.Secret.Optional)
 		if err != nil {
 			return nil, fmt.Errorf("failed to checkpoint secret for pod %s/%s: %v", pod.Namespace, pod.Name, err)
 		}
+		if err := writeVolumeFiles(secretVolumePath(pod.Namespace, pod.Name, v), files, uid, gid, c.secrets); err != nil {
+			return nil, fmt.Errorf("failed to checkpoint secret for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
 	}
 	return pod, nil
 }
 
-// checkpointSecret will locally store secret data.
-// The path to the secret data becomes: checkpointSecretPath/namespace/podname/secretName/secret.file
+// secretPath returns the path that a secret volume without items is checkpointed to:
+// checkpointSecretPath/namespace/podname/secretName/secret.file
 // Where each "secret.file" is a key from the secret.Data field.
-func (c *checkpointer) checkpointSecret(namespace, podName, secretName string, uid, gid int) (string, error) {
-	secret, err := c.apiserver.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
-	if err != nil {
-		return "", fmt.Errorf("failed to retrieve secret %s/%s: %v", namespace, secretName, err)
-	}
-
-	basePath := secretPath(namespace, podName, secretName)
-	if err := os.MkdirAll(basePath, 0700); err != nil {
-		return "", fmt.Errorf("failed to create secret checkpoint path %s: %v", basePath, err)
-	}
-	if err := os.Chown(basePath, uid, gid); err != nil {
-		return "", fmt.Errorf("failed to chown secret checkpoint path %s: %v", basePath, err)
-	}
-
-	// TODO(aaron): No need to store if already exists
-	for f, d := range secret.Data {
-		if err := writeAndAtomicRename(filepath.Join(basePath, f), d, uid, gid, 0600); err != nil {
-			return "", fmt.Errorf("failed to write secret %s: %v", secret.Name, err)
-		}
-	}
-
-	return basePath, nil
-}
-
 func secretPath(namespace, podName, secretName string) string {
 	return filepath.Join(checkpointSecretPath, namespace, podName, secretName)
 }
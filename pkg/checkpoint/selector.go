@@ -0,0 +1,80 @@
+package checkpoint
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// parentSelector selects the parent pods that are checkpointed, by namespace and labels. The pod of
+// the checkpointer itself is always selected, so that it keeps checkpointing itself. The zero value
+// selects all parent pods.
+type parentSelector struct {
+	// namespaces are the selected namespaces, or nil for all namespaces.
+	namespaces map[string]bool
+	// labels is the label selector that parent pods must match, or nil for all labels.
+	labels labels.Selector
+	// self is the namespace/name of the checkpointer's pod.
+	self string
+}
+
+// newParentSelector returns the parentSelector for the checkpointer options. Unless AllNamespaces
+// is set, the namespace of the checkpointer is always selected.
+func newParentSelector(opts Options) (parentSelector, error) {
+	s := parentSelector{self: opts.CheckpointerPod.PodNamespace + "/" + opts.CheckpointerPod.PodName}
+	if !opts.AllNamespaces {
+		s.namespaces = map[string]bool{opts.CheckpointerPod.PodNamespace: true}
+		for _, ns := range opts.Namespaces {
+			s.namespaces[ns] = true
+		}
+	}
+	if opts.ParentSelector != "" {
+		var err error
+		if s.labels, err = labels.Parse(opts.ParentSelector); err != nil {
+			return s, fmt.Errorf("invalid parent selector %q: %v", opts.ParentSelector, err)
+		}
+	}
+	return s, nil
+}
+
+// watchedNamespaces returns the namespaces that parent pods are watched in, which is NamespaceAll
+// if all namespaces are selected.
+func (s parentSelector) watchedNamespaces() []string {
+	if s.namespaces == nil {
+		return []string{metav1.NamespaceAll}
+	}
+	var namespaces []string
+	for ns := range s.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// matches returns true if pod is selected.
+func (s parentSelector) matches(pod *corev1.Pod) bool {
+	if podFullName(pod) == s.self {
+		return true
+	}
+	if s.namespaces != nil && !s.namespaces[pod.Namespace] {
+		return false
+	}
+	return s.labels == nil || s.labels.Matches(labels.Set(pod.Labels))
+}
+
+// filter returns the selected pods. It returns nil if pods is nil.
+func (s parentSelector) filter(pods map[string]*corev1.Pod) map[string]*corev1.Pod {
+	if pods == nil {
+		return nil
+	}
+	selected := make(map[string]*corev1.Pod, len(pods))
+	for id, pod := range pods {
+		if s.matches(pod) {
+			selected[id] = pod
+		}
+	}
+	return selected
+}
@@ -0,0 +1,79 @@
+package checkpoint
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParentSelector(t *testing.T) {
+	self := CheckpointerPod{NodeName: "node1", PodName: "pod-checkpointer-abcde", PodNamespace: "kube-system"}
+	pod := func(namespace, name string, labels map[string]string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+	}
+	pods := map[string]*v1.Pod{
+		"kube-system/pod-checkpointer-abcde": pod("kube-system", "pod-checkpointer-abcde", nil),
+		"kube-system/kube-apiserver":         pod("kube-system", "kube-apiserver", map[string]string{"tier": "control-plane"}),
+		"kube-system/kube-proxy":             pod("kube-system", "kube-proxy", nil),
+		"monitoring/prometheus":              pod("monitoring", "prometheus", map[string]string{"tier": "control-plane"}),
+		"other/app":                          pod("other", "app", nil),
+	}
+
+	for _, tc := range []struct {
+		desc           string
+		opts           Options
+		wantNamespaces []string
+		want           []string
+	}{
+		{
+			desc:           "own namespace",
+			opts:           Options{},
+			wantNamespaces: []string{"kube-system"},
+			want:           []string{"kube-system/pod-checkpointer-abcde", "kube-system/kube-apiserver", "kube-system/kube-proxy"},
+		},
+		{
+			desc:           "additional namespace",
+			opts:           Options{Namespaces: []string{"monitoring"}},
+			wantNamespaces: []string{"kube-system", "monitoring"},
+			want:           []string{"kube-system/pod-checkpointer-abcde", "kube-system/kube-apiserver", "kube-system/kube-proxy", "monitoring/prometheus"},
+		},
+		{
+			desc:           "all namespaces",
+			opts:           Options{AllNamespaces: true},
+			wantNamespaces: []string{metav1.NamespaceAll},
+			want:           []string{"kube-system/pod-checkpointer-abcde", "kube-system/kube-apiserver", "kube-system/kube-proxy", "monitoring/prometheus", "other/app"},
+		},
+		{
+			desc:           "all namespaces with a label selector",
+			opts:           Options{AllNamespaces: true, ParentSelector: "tier=control-plane"},
+			wantNamespaces: []string{metav1.NamespaceAll},
+			// The checkpointer is selected regardless of its labels.
+			want: []string{"kube-system/pod-checkpointer-abcde", "kube-system/kube-apiserver", "monitoring/prometheus"},
+		},
+	} {
+		tc.opts.CheckpointerPod = self
+		s, err := newParentSelector(tc.opts)
+		if err != nil {
+			t.Fatalf("%s: newParentSelector() = %v, want: nil", tc.desc, err)
+		}
+		if got := s.watchedNamespaces(); !reflect.DeepEqual(got, tc.wantNamespaces) {
+			t.Errorf("%s: watchedNamespaces() = %q, want: %q", tc.desc, got, tc.wantNamespaces)
+		}
+		want := make(map[string]*v1.Pod)
+		for _, id := range tc.want {
+			want[id] = pods[id]
+		}
+		if got := s.filter(pods); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: filter() = %v, want: %v", tc.desc, got, want)
+		}
+	}
+
+	if _, err := newParentSelector(Options{ParentSelector: "tier in (control-plane"}); err == nil {
+		t.Errorf("newParentSelector() with an invalid selector = nil, want: non-nil")
+	}
+	if got := (parentSelector{}).filter(pods); !reflect.DeepEqual(got, pods) {
+		t.Errorf("filter() of the zero parentSelector = %v, want: %v", got, pods)
+	}
+}
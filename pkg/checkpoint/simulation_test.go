@@ -0,0 +1,591 @@
+package checkpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var replayFiles = flag.String("replay", "", "Comma separated recordings of --record-inputs to replay in TestReplay.")
+
+// simulatedNode runs a checkpointer against a model of a node and its apiserver, with a virtual
+// clock and the checkpoint paths redirected to a sandbox directory. It implements the kubelet, CRI
+// and apiserver sources of the checkpointer:
+//
+//   - The apiserver schedules the parent pods in scheduled, and serves secrets from its cache while
+//     it is down, like apiWatcher.
+//   - The kubelet runs the parent pods that it last saw in the apiserver, and the active
+//     checkpoints as static pods. It forgets the parent pods when the node reboots.
+//
+// When replay is set, the sources return the recorded inputs instead.
+type simulatedNode struct {
+	t      *testing.T
+	root   string
+	clock  *clock.FakeClock
+	client *fake.Clientset
+	pod    CheckpointerPod
+
+	apiAvailable     bool
+	runtimeAvailable bool
+	apiPartitioned   bool
+	// terminationDelay is how long the pod of a checkpoint keeps running after its active manifest
+	// was removed. stopping holds the pods that are terminating, and active the pods of the active
+	// manifests in the last query of the runtime.
+	terminationDelay time.Duration
+	stopping         map[string]time.Time
+	active           map[string]bool
+	// secretStore encrypts the checkpointed secrets if it is set, see encryptSecrets.
+	secretStore *secretStore
+	scheduled   map[string]*v1.Pod
+	kubeletPods map[string]*v1.Pod
+	secrets     map[string]*v1.Secret
+	replay      *recordedSync
+
+	c *checkpointer
+	// recording is the recording of c. dec decodes the iterations of c as they are recorded.
+	recording *bytes.Buffer
+	dec       *json.Decoder
+	// syncs are the iterations of all checkpointers that ran on the node.
+	syncs []recordedSync
+}
+
+// newSimulatedNode returns a simulatedNode with an available apiserver and container runtime and no
+// pods. Call start to start its checkpointer.
+func newSimulatedNode(t *testing.T, pod CheckpointerPod) *simulatedNode {
+	root, err := ioutil.TempDir("", "simulation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	for _, p := range []*string{&activeCheckpointPath, &inactiveCheckpointPath, &checkpointSecretPath, &checkpointConfigMapPath, &checkpointVolumePath} {
+		p, orig := p, *p
+		*p = filepath.Join(root, orig)
+		t.Cleanup(func() { *p = orig })
+	}
+	if err := os.MkdirAll(activeCheckpointPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	gracePeriod := checkpointGracePeriod
+	t.Cleanup(func() { checkpointGracePeriod = gracePeriod })
+
+	return &simulatedNode{
+		t:                t,
+		root:             root,
+		clock:            clock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+		client:           fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: pod.NodeName}}),
+		pod:              pod,
+		apiAvailable:     true,
+		runtimeAvailable: true,
+		scheduled:        make(map[string]*v1.Pod),
+		kubeletPods:      make(map[string]*v1.Pod),
+		secrets:          make(map[string]*v1.Secret),
+	}
+}
+
+// start starts a new checkpointer, which restores its state from the sandbox.
+func (n *simulatedNode) start() {
+	n.recording = &bytes.Buffer{}
+	pending := &bytes.Buffer{}
+	n.dec = json.NewDecoder(pending)
+	n.c = &checkpointer{
+		apiserver:         n.client,
+		kubelet:           n,
+		cri:               n,
+		watcher:           n,
+		peers:             n,
+		clock:             n.clock,
+		checkpointerPod:   n.pod,
+		resyncPeriod:      defaultResyncPeriod,
+		health:            &health{},
+		secrets:           n.secretStore,
+		events:            newEventRecorder(n.client, n.pod.NodeName),
+		statusNamespace:   n.pod.PodNamespace,
+		journal:           &checkpointJournal{path: filepath.Join(n.root, checkpointJournalPath)},
+		activeManifests:   &manifestDir{path: activeCheckpointPath},
+		inactiveManifests: &manifestDir{path: inactiveCheckpointPath},
+		recorder:          &inputRecorder{path: "simulation", enc: json.NewEncoder(io.MultiWriter(n.recording, pending))},
+	}
+	if err := n.c.start(); err != nil {
+		n.t.Fatal(err)
+	}
+	var header recordingHeader
+	if err := n.dec.Decode(&header); err != nil {
+		n.t.Fatalf("Failed to decode recording header: %v", err)
+	}
+}
+
+// sync runs an iteration of the checkpointer, and returns its inputs and actions.
+func (n *simulatedNode) sync() recordedSync {
+	if n.apiAvailable && n.replay == nil {
+		n.kubeletPods = copyPods(n.scheduled)
+	}
+	n.c.sync()
+	var s recordedSync
+	if err := n.dec.Decode(&s); err != nil {
+		n.t.Fatalf("Failed to decode recorded iteration: %v", err)
+	}
+	n.syncs = append(n.syncs, s)
+	return s
+}
+
+// run advances the virtual clock by d, running an iteration every resync period.
+func (n *simulatedNode) run(d time.Duration) {
+	end := n.clock.Now().Add(d)
+	for n.clock.Now().Before(end) {
+		n.clock.Step(defaultResyncPeriod)
+		n.sync()
+	}
+}
+
+// reboot reboots the node: the kubelet forgets the parent pods until it reaches the apiserver, and
+// a new checkpointer starts.
+func (n *simulatedNode) reboot() {
+	n.kubeletPods = make(map[string]*v1.Pod)
+	n.stopping, n.active = nil, nil
+	n.start()
+}
+
+// schedule schedules a parent pod to the node, with a secret volume of a secret with its name.
+func (n *simulatedNode) schedule(name string) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "kube-system",
+			UID:         types.UID(name + "-uid"),
+			Annotations: map[string]string{shouldCheckpointAnnotation: shouldCheckpoint},
+		},
+		Spec: v1.PodSpec{
+			NodeName:   n.pod.NodeName,
+			Containers: []v1.Container{{Name: name, Image: "hyperkube"}},
+			Volumes:    []v1.Volume{{Name: "secrets", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: name}}}},
+		},
+	}
+	n.scheduled[podFullName(pod)] = pod
+	n.secrets[podFullName(pod)] = &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system"},
+		Data:       map[string][]byte{"key": []byte(name)},
+	}
+}
+
+// encryptSecrets enables the encryption of checkpointed secrets, which are decrypted to a
+// directory of the sandbox.
+func (n *simulatedNode) encryptSecrets() {
+	s, err := newSecretStoreWithKey(make([]byte, 32), filepath.Join(n.root, "decrypted"))
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	n.secretStore = s
+	n.c.secrets = s
+}
+
+// runUntil runs iterations until the checkpointer decided on action, e.g. "remove <checkpoint>".
+func (n *simulatedNode) runUntil(action string) {
+	for i := 0; i < 1000; i++ {
+		for _, a := range n.actions() {
+			if a == action {
+				return
+			}
+		}
+		n.run(defaultResyncPeriod)
+	}
+	n.t.Fatalf("The checkpointer did not decide on %q", action)
+}
+
+// exists returns true if the sandboxed file at p exists.
+func (n *simulatedNode) exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// actions returns the actions of all iterations on the node, as "<action> <checkpoint>".
+func (n *simulatedNode) actions() []string {
+	return syncActions(n.syncs)
+}
+
+func (n *simulatedNode) localParentPods() map[string]*v1.Pod {
+	if n.replay != nil {
+		return n.replay.LocalParentPods
+	}
+	return copyPods(n.kubeletPods)
+}
+
+func (n *simulatedNode) localRunningPods() map[string]*v1.Pod {
+	if n.replay != nil {
+		return n.replay.LocalRunningPods
+	}
+	if !n.runtimeAvailable {
+		return nil
+	}
+	pods := copyPods(n.kubeletPods)
+	active, err := getFileCheckpoints(activeCheckpointPath)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	now := n.clock.Now()
+	wasActive := n.active
+	n.active = make(map[string]bool)
+	for id := range active {
+		name := checkpointPodFullName(id, n.pod.NodeName)
+		pods[name] = &v1.Pod{}
+		n.active[name] = true
+		delete(n.stopping, name)
+	}
+	if n.stopping == nil {
+		n.stopping = make(map[string]time.Time)
+	}
+	for name := range wasActive {
+		if !n.active[name] {
+			n.stopping[name] = now.Add(n.terminationDelay)
+		}
+	}
+	for name, end := range n.stopping {
+		if now.Before(end) {
+			pods[name] = &v1.Pod{}
+		} else {
+			delete(n.stopping, name)
+		}
+	}
+	return pods
+}
+
+func (n *simulatedNode) parentPods() (bool, map[string]*v1.Pod) {
+	if n.replay != nil {
+		return n.replay.APIAvailable, n.replay.APIParentPods
+	}
+	if !n.apiAvailable {
+		return false, nil
+	}
+	return true, copyPods(n.scheduled)
+}
+
+func (n *simulatedNode) partitioned(apiAvailable bool) bool {
+	if n.replay != nil {
+		return n.replay.Partitioned
+	}
+	return !apiAvailable && n.apiPartitioned
+}
+
+func (n *simulatedNode) watchObjects(keys map[objectKey]bool) {}
+
+func (n *simulatedNode) takeChangedObjects() map[objectKey]bool {
+	return nil
+}
+
+func (n *simulatedNode) getSecret(namespace, name string) (*v1.Secret, error) {
+	if n.replay != nil {
+		// Recordings do not hold the contents of secrets.
+		return &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}, nil
+	}
+	secret, ok := n.secrets[namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(v1.Resource("secrets"), name)
+	}
+	return secret, nil
+}
+
+func (n *simulatedNode) getConfigMap(namespace, name string) (*v1.ConfigMap, error) {
+	return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}, nil
+}
+
+// copyPods returns copies of the parent pods in pods, as the kubelet and apiserver sources do.
+func copyPods(pods map[string]*v1.Pod) map[string]*v1.Pod {
+	pl := &v1.PodList{}
+	for _, pod := range pods {
+		pl.Items = append(pl.Items, *pod.DeepCopy())
+	}
+	return podListToParentPods(pl)
+}
+
+// syncActions returns the actions of iterations as "<action> <checkpoint>", in a stable order.
+func syncActions(syncs []recordedSync) []string {
+	var actions []string
+	for _, s := range syncs {
+		var sync []string
+		for action, ids := range map[string][]string{"start": s.Start, "stop": s.Stop, "remove": s.Remove} {
+			for _, id := range ids {
+				sync = append(sync, action+" "+id)
+			}
+		}
+		sort.Strings(sync)
+		actions = append(actions, sync...)
+	}
+	return actions
+}
+
+// replay replays the iterations of a recording in a sandbox, and returns the iterations of the
+// replay.
+func replay(t *testing.T, header *recordingHeader, syncs []recordedSync) []recordedSync {
+	n := newSimulatedNode(t, header.CheckpointerPod)
+	checkpointGracePeriod = header.CheckpointGracePeriod
+	for _, dir := range []struct {
+		pods    map[string]*v1.Pod
+		pathOf  func(string) string
+		dirPath string
+	}{
+		{header.InactiveCheckpoints, podFullNameToInactiveCheckpointPath, inactiveCheckpointPath},
+		{header.ActiveCheckpoints, podFullNameToActiveCheckpointPath, activeCheckpointPath},
+	} {
+		if err := os.MkdirAll(dir.dirPath, 0700); err != nil {
+			t.Fatal(err)
+		}
+		for id, pod := range dir.pods {
+			buf := &bytes.Buffer{}
+			if err := podSerializer.Encode(pod, buf); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(dir.pathOf(id), buf.Bytes(), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	journal, err := json.Marshal(journalFile{Version: journalVersion, Checkpoints: header.Checkpoints})
+	if err != nil {
+		t.Fatal(err)
+	}
+	journalPath := filepath.Join(n.root, checkpointJournalPath)
+	if err := os.MkdirAll(filepath.Dir(journalPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(journalPath, journal, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	n.start()
+	for i := range syncs {
+		n.replay = &syncs[i]
+		n.clock.SetTime(syncs[i].Time)
+		n.sync()
+	}
+	return n.syncs
+}
+
+// checkReplay replays the recording of a node, and checks that the replay decides on the same
+// actions.
+func checkReplay(t *testing.T, recording []byte) {
+	header, syncs, err := readRecording(bytes.NewReader(recording))
+	if err != nil {
+		t.Fatalf("readRecording() = %v, want: nil", err)
+	}
+	for i, got := range replay(t, header, syncs) {
+		if want := syncs[i]; !reflect.DeepEqual(syncActions([]recordedSync{got}), syncActions([]recordedSync{want})) {
+			t.Errorf("replayed iteration %d at %s: actions = %q, recorded: %q", i+1, want.Time, syncActions([]recordedSync{got}), syncActions([]recordedSync{want}))
+		}
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	const apiserver = "kube-system/kube-apiserver"
+	checkpointerPod := CheckpointerPod{NodeName: "node1", PodName: "pod-checkpointer-abcde", PodNamespace: "kube-system"}
+	secretFile := func() string {
+		return filepath.Join(secretPath("kube-system", "kube-apiserver", "kube-apiserver"), "key")
+	}
+	secretExists := func(n *simulatedNode) bool {
+		return n.exists(secretFile())
+	}
+	decryptedSecretExists := func(n *simulatedNode) bool {
+		p, _ := n.secretStore.decryptedPathOf(secretFile())
+		return n.exists(p)
+	}
+
+	for _, tc := range []struct {
+		desc     string
+		scenario func(n *simulatedNode)
+		want     []string
+		// wantActive and wantInactive are whether the active and inactive manifests of the
+		// apiserver checkpoint exist at the end.
+		wantActive, wantInactive bool
+		wantSecrets              bool
+	}{{
+		desc: "apiserver down, node reboot, apiserver back",
+		scenario: func(n *simulatedNode) {
+			n.run(time.Minute)
+			n.apiAvailable = false
+			n.run(10 * time.Minute)
+			n.reboot()
+			n.run(time.Minute)
+			n.apiAvailable = true
+			n.run(time.Minute)
+		},
+		want:         []string{"stop " + apiserver, "start " + apiserver, "stop " + apiserver},
+		wantInactive: true,
+		wantSecrets:  true,
+	}, {
+		desc: "apiserver down 10 minutes, parent deleted",
+		scenario: func(n *simulatedNode) {
+			n.run(time.Minute)
+			n.apiAvailable = false
+			n.run(10 * time.Minute)
+			n.apiAvailable = true
+			delete(n.scheduled, apiserver)
+			n.run(checkpointGracePeriod + time.Minute)
+		},
+		want: []string{"stop " + apiserver, "remove " + apiserver},
+	}, {
+		desc: "node reboot with apiserver down, parent deleted",
+		scenario: func(n *simulatedNode) {
+			n.run(time.Minute)
+			n.apiAvailable = false
+			n.reboot()
+			n.run(10 * time.Minute)
+			n.apiAvailable = true
+			delete(n.scheduled, apiserver)
+			n.run(checkpointGracePeriod + time.Minute)
+		},
+		want: []string{"stop " + apiserver, "start " + apiserver, "remove " + apiserver},
+	}, {
+		desc: "container runtime down, node reboot",
+		scenario: func(n *simulatedNode) {
+			n.run(time.Minute)
+			n.apiAvailable = false
+			n.runtimeAvailable = false
+			n.reboot()
+			n.run(time.Minute)
+		},
+		// The checkpoint is started because the runtime does not report the parent as running.
+		want:         []string{"stop " + apiserver, "start " + apiserver},
+		wantActive:   true,
+		wantInactive: true,
+		wantSecrets:  true,
+	}, {
+		desc: "apiserver unreachable from node only, node reboot, apiserver down",
+		scenario: func(n *simulatedNode) {
+			n.run(time.Minute)
+			n.apiAvailable = false
+			n.apiPartitioned = true
+			n.reboot()
+			n.run(10 * time.Minute)
+			n.apiPartitioned = false
+			n.run(time.Minute)
+		},
+		// The checkpoint is only started once the peers confirm that the apiserver is down.
+		want:         []string{"stop " + apiserver, "start " + apiserver},
+		wantActive:   true,
+		wantInactive: true,
+		wantSecrets:  true,
+	}, {
+		desc: "active checkpoint removed, pod terminates slowly",
+		scenario: func(n *simulatedNode) {
+			n.run(time.Minute)
+			n.apiAvailable = false
+			n.reboot()
+			n.run(time.Minute)
+			n.apiAvailable = true
+			delete(n.scheduled, apiserver)
+			n.terminationDelay = 20 * time.Second
+			n.runUntil("remove " + apiserver)
+			// The pod keeps its secrets while it terminates, but the checkpoint is gone.
+			n.run(15 * time.Second)
+			if !secretExists(n) {
+				n.t.Errorf("checkpointed secret removed while the pod is terminating")
+			}
+			if n.exists(podFullNameToInactiveCheckpointPath(apiserver)) {
+				n.t.Errorf("inactive manifest exists while the pod is terminating")
+			}
+			n.run(15 * time.Second)
+		},
+		want: []string{"stop " + apiserver, "start " + apiserver, "remove " + apiserver},
+	}, {
+		desc: "active checkpoint removed, pod does not terminate",
+		scenario: func(n *simulatedNode) {
+			n.run(time.Minute)
+			n.apiAvailable = false
+			n.reboot()
+			n.run(time.Minute)
+			n.apiAvailable = true
+			delete(n.scheduled, apiserver)
+			n.terminationDelay = time.Hour
+			n.runUntil("remove " + apiserver)
+			n.run(20 * time.Second)
+			if !secretExists(n) {
+				n.t.Errorf("checkpointed secret removed before the termination grace period passed")
+			}
+			// The files are removed once the default termination grace period of 30s passed.
+			n.run(15 * time.Second)
+		},
+		want: []string{"stop " + apiserver, "start " + apiserver, "remove " + apiserver},
+	}, {
+		desc: "encrypted checkpoint stopped, pod terminates slowly",
+		scenario: func(n *simulatedNode) {
+			n.encryptSecrets()
+			n.run(time.Minute)
+			n.apiAvailable = false
+			n.reboot()
+			n.run(time.Minute)
+			if !decryptedSecretExists(n) {
+				n.t.Errorf("decrypted secret of the active checkpoint does not exist")
+			}
+			// The kubelet runs the parent pod again, so the checkpoint is stopped.
+			n.apiAvailable = true
+			n.terminationDelay = 20 * time.Second
+			n.run(15 * time.Second)
+			if !decryptedSecretExists(n) {
+				n.t.Errorf("decrypted secret removed while the pod is terminating")
+			}
+			n.run(15 * time.Second)
+			if decryptedSecretExists(n) {
+				n.t.Errorf("decrypted secret exists after the pod terminated")
+			}
+		},
+		want:         []string{"stop " + apiserver, "start " + apiserver, "stop " + apiserver},
+		wantInactive: true,
+		wantSecrets:  true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			checkpointGracePeriod = time.Minute
+			n := newSimulatedNode(t, checkpointerPod)
+			n.schedule("kube-apiserver")
+			n.start()
+			tc.scenario(n)
+
+			if got := n.actions(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("actions = %q, want: %q", got, tc.want)
+			}
+			if got := n.exists(podFullNameToActiveCheckpointPath(apiserver)); got != tc.wantActive {
+				t.Errorf("active manifest exists = %t, want: %t", got, tc.wantActive)
+			}
+			if got := n.exists(podFullNameToInactiveCheckpointPath(apiserver)); got != tc.wantInactive {
+				t.Errorf("inactive manifest exists = %t, want: %t", got, tc.wantInactive)
+			}
+			if got := secretExists(n); got != tc.wantSecrets {
+				t.Errorf("checkpointed secret exists = %t, want: %t", got, tc.wantSecrets)
+			}
+
+			// The recording of the last checkpointer replays to the same actions.
+			checkReplay(t, n.recording.Bytes())
+		})
+	}
+}
+
+// TestReplay replays the recordings of --record-inputs given with -replay, e.g. from a bug report:
+//
+//	go test ./pkg/checkpoint -run TestReplay -replay=/path/to/recording
+func TestReplay(t *testing.T) {
+	if *replayFiles == "" {
+		t.Skip("no recordings given with -replay")
+	}
+	for _, f := range strings.Split(*replayFiles, ",") {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkReplay(t, data)
+		})
+	}
+}
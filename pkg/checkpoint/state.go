@@ -17,11 +17,14 @@ type apiCondition struct {
 	localRunning bool
 	// localParent is true if the kubelet parent pod exists.
 	localParent bool
+	// partitioned is true if the apiserver is unavailable to this node but peer checkpointers do
+	// not confirm the outage, so checkpoints must not be activated.
+	partitioned bool
 }
 
 // String() implements fmt.Stringer.String().
 func (a apiCondition) String() string {
-	return fmt.Sprintf("apiAvailable=%t, apiParent=%t, localRunning=%t, localParent=%t", a.apiAvailable, a.apiParent, a.localRunning, a.localParent)
+	return fmt.Sprintf("apiAvailable=%t, apiParent=%t, localRunning=%t, localParent=%t, partitioned=%t", a.apiAvailable, a.apiParent, a.localRunning, a.localParent, a.partitioned)
 }
 
 // action represents the action to be taken based on the state of a checkpoint.
@@ -135,6 +138,11 @@ func (s stateInactive) transition(now time.Time, apis apiCondition) checkpointSt
 			return s
 		}
 
+		// The apiserver is unavailable only to this node, remain in stateInactive.
+		if apis.partitioned {
+			return s
+		}
+
 		// The apiserver is unavailable and the local pod is not running, transition to stateActive.
 		return stateActive{}
 	}
@@ -183,6 +191,11 @@ func (s stateInactiveGracePeriod) transition(now time.Time, apis apiCondition) c
 			return s.checkGracePeriod(now, apis)
 		}
 
+		// The apiserver is unavailable only to this node, remain in stateInactiveGracePeriod.
+		if apis.partitioned {
+			return s.checkGracePeriod(now, apis)
+		}
+
 		// The apiserver is unavailable and the local pod is not running, transition to stateActive.
 		return stateActive{}
 	}
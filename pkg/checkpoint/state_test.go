@@ -18,9 +18,11 @@ func init() {
 		for _, apiParent := range bools {
 			for _, localRunning := range bools {
 				for _, localParent := range bools {
-					allAPIConditions = append(allAPIConditions, apiCondition{
-						apiAvailable, apiParent, localRunning, localParent,
-					})
+					for _, partitioned := range bools {
+						allAPIConditions = append(allAPIConditions, apiCondition{
+							apiAvailable, apiParent, localRunning, localParent, partitioned,
+						})
+					}
 				}
 			}
 		}
@@ -0,0 +1,194 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// StatusLabel labels the configMaps that the checkpointers publish their status in.
+	StatusLabel = "checkpointer.alpha.coreos.com/status"
+	// statusConfigMapPrefix is the prefix of the name of the status configMap of a node, which is
+	// followed by the node name.
+	statusConfigMapPrefix = "pod-checkpointer-status-"
+	// statusKey is the key of the status configMaps that holds the JSON encoded NodeStatus.
+	statusKey = "status.json"
+
+	// statusRefreshPeriod is the interval at which an unchanged status is published again, so that
+	// readers can tell stale statuses of nodes whose checkpointer stopped.
+	statusRefreshPeriod = 1 * time.Minute
+)
+
+// NodeStatus is the status of the checkpoints on a node, published by its checkpointer.
+type NodeStatus struct {
+	// NodeName is the name of the node.
+	NodeName string `json:"nodeName"`
+	// UpdateTime is the time the checkpointer last published the status.
+	UpdateTime time.Time `json:"updateTime"`
+	// Checkpoints are the checkpoints on the node, sorted by parent.
+	Checkpoints []CheckpointStatus `json:"checkpoints"`
+}
+
+// CheckpointStatus is the status of a checkpoint.
+type CheckpointStatus struct {
+	// Parent is the namespace/name of the parent pod.
+	Parent string `json:"parent"`
+	// State is the state of the checkpoint, e.g. "inactive" or "active".
+	State string `json:"state"`
+	// LastWriteTime is the time the checkpoint and its secrets and configMaps were last written.
+	LastWriteTime *time.Time `json:"lastWriteTime,omitempty"`
+	// Secrets are the names of the secrets that the checkpoint references.
+	Secrets []string `json:"secrets,omitempty"`
+	// ConfigMaps are the names of the configMaps that the checkpoint references.
+	ConfigMaps []string `json:"configMaps,omitempty"`
+}
+
+// Problems returns the problems of the checkpoints of a node at now: statuses that were not updated
+// within staleAfter, because the checkpointer is not running or cannot reach the apiserver, and
+// active checkpoints, whose parent pods are not running.
+func (s NodeStatus) Problems(now time.Time, staleAfter time.Duration) []string {
+	var problems []string
+	if age := now.Sub(s.UpdateTime); age > staleAfter {
+		problems = append(problems, fmt.Sprintf("status was last updated %s ago", age.Round(time.Second)))
+	}
+	for _, cp := range s.Checkpoints {
+		if strings.HasPrefix(cp.State, stateActive{}.String()) {
+			problems = append(problems, fmt.Sprintf("checkpoint of %s is %s", cp.Parent, cp.State))
+		}
+	}
+	return problems
+}
+
+// ListNodeStatuses returns the statuses that the checkpointers published in namespace, sorted by
+// node name.
+func ListNodeStatuses(ctx context.Context, client kubernetes.Interface, namespace string) ([]NodeStatus, error) {
+	cms, err := client.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: StatusLabel + "=true"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list checkpointer statuses: %v", err)
+	}
+	var statuses []NodeStatus
+	for _, cm := range cms.Items {
+		var s NodeStatus
+		if err := json.Unmarshal([]byte(cm.Data[statusKey]), &s); err != nil {
+			return nil, fmt.Errorf("failed to decode checkpointer status %s/%s: %v", cm.Namespace, cm.Name, err)
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].NodeName < statuses[j].NodeName })
+	return statuses, nil
+}
+
+// nodeStatus returns the status of the checkpoints.
+func (c *checkpointer) nodeStatus() NodeStatus {
+	status := NodeStatus{NodeName: c.checkpointerPod.NodeName, Checkpoints: []CheckpointStatus{}}
+	cps := make([]*checkpoint, 0, len(c.checkpoints.checkpoints)+1)
+	for _, cp := range c.checkpoints.checkpoints {
+		cps = append(cps, cp)
+	}
+	if c.checkpoints.selfCheckpoint != nil {
+		cps = append(cps, c.checkpoints.selfCheckpoint)
+	}
+
+	for _, cp := range cps {
+		cs := CheckpointStatus{Parent: cp.name, State: fmt.Sprint(cp.state)}
+		if t := c.lastWriteTime(cp.name); !t.IsZero() {
+			cs.LastWriteTime = &t
+		}
+		if cp.pod != nil {
+			for key := range referencedObjects([]*corev1.Pod{cp.pod}) {
+				switch key.kind {
+				case secretKind:
+					cs.Secrets = append(cs.Secrets, key.name)
+				case configMapKind:
+					cs.ConfigMaps = append(cs.ConfigMaps, key.name)
+				}
+			}
+			sort.Strings(cs.Secrets)
+			sort.Strings(cs.ConfigMaps)
+		}
+		status.Checkpoints = append(status.Checkpoints, cs)
+	}
+	sort.Slice(status.Checkpoints, func(i, j int) bool { return status.Checkpoints[i].Parent < status.Checkpoints[j].Parent })
+
+	// Forget the write times of checkpoints that were removed.
+	for id := range c.lastWrites {
+		if _, ok := c.checkpoints.checkpoints[id]; !ok && (c.checkpoints.selfCheckpoint == nil || c.checkpoints.selfCheckpoint.name != id) {
+			delete(c.lastWrites, id)
+		}
+	}
+	return status
+}
+
+// lastWriteTime returns the time a checkpoint was last written. Checkpoints that were not written
+// since the checkpointer started use the modification time of their inactive manifest.
+func (c *checkpointer) lastWriteTime(id string) time.Time {
+	if t, ok := c.lastWrites[id]; ok {
+		return t
+	}
+	fi, err := os.Stat(podFullNameToInactiveCheckpointPath(id))
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// publishStatus publishes the status of the checkpoints in a configMap in the status namespace, if
+// it changed or was last published more than statusRefreshPeriod ago. The configMap is owned by the
+// node, so that the status of a removed or renamed node is garbage collected instead of being
+// reported as stale forever.
+func (c *checkpointer) publishStatus(now time.Time) {
+	status := c.nodeStatus()
+	published := c.publishedStatus
+	if published != nil && reflect.DeepEqual(published.Checkpoints, status.Checkpoints) && now.Sub(published.UpdateTime) < statusRefreshPeriod {
+		return
+	}
+	status.UpdateTime = now
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		glog.Errorf("Failed to encode checkpointer status: %v", err)
+		return
+	}
+	if c.statusOwner == nil {
+		node, err := c.apiserver.CoreV1().Nodes().Get(context.TODO(), c.checkpointerPod.NodeName, metav1.GetOptions{})
+		if err != nil {
+			recordError(errorSourceAPIServer)
+			glog.Errorf("Failed to get node %s to publish checkpointer status: %v", c.checkpointerPod.NodeName, err)
+			return
+		}
+		c.statusOwner = &metav1.OwnerReference{APIVersion: "v1", Kind: "Node", Name: node.Name, UID: node.UID}
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            statusConfigMapPrefix + c.checkpointerPod.NodeName,
+			Namespace:       c.statusNamespace,
+			Labels:          map[string]string{StatusLabel: "true"},
+			OwnerReferences: []metav1.OwnerReference{*c.statusOwner},
+		},
+		Data: map[string]string{statusKey: string(data)},
+	}
+
+	configMaps := c.apiserver.CoreV1().ConfigMaps(cm.Namespace)
+	_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+	}
+	if err != nil {
+		recordError(errorSourceAPIServer)
+		glog.Errorf("Failed to publish checkpointer status %s/%s: %v", cm.Namespace, cm.Name, err)
+		return
+	}
+	c.publishedStatus = &status
+}
@@ -0,0 +1,108 @@
+package checkpoint
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPublishStatus(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "node1-uid"}}
+	client := fake.NewSimpleClientset()
+	written := time.Unix(1600000000, 0)
+	c := &checkpointer{
+		apiserver:       client,
+		checkpointerPod: CheckpointerPod{NodeName: "node1", PodName: "pod-checkpointer-node1", PodNamespace: "kube-system"},
+		statusNamespace: "pod-checkpointer-status",
+		checkpoints: checkpoints{checkpoints: map[string]*checkpoint{
+			"kube-system/kube-apiserver": {
+				name:  "kube-system/kube-apiserver",
+				state: stateActive{},
+				pod: &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "kube-system"},
+					Spec: v1.PodSpec{Volumes: []v1.Volume{
+						{Name: "secrets", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "kube-apiserver"}}},
+						{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "kube-apiserver-config"}}}},
+					}},
+				},
+			},
+		}},
+		lastWrites: map[string]time.Time{"kube-system/kube-apiserver": written, "kube-system/removed": written},
+	}
+
+	// The status is not published until the node can be referenced as its owner.
+	now := written.Add(time.Minute)
+	c.publishStatus(now)
+	if statuses, err := ListNodeStatuses(context.TODO(), client, "pod-checkpointer-status"); err != nil || len(statuses) != 0 {
+		t.Fatalf("ListNodeStatuses() without node = %+v, %v, want: none", statuses, err)
+	}
+	if _, err := client.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.publishStatus(now)
+	statuses, err := ListNodeStatuses(context.TODO(), client, "pod-checkpointer-status")
+	if err != nil {
+		t.Fatalf("ListNodeStatuses() = %v, want: nil", err)
+	}
+	want := []NodeStatus{{
+		NodeName:   "node1",
+		UpdateTime: now,
+		Checkpoints: []CheckpointStatus{{
+			Parent:        "kube-system/kube-apiserver",
+			State:         "active",
+			LastWriteTime: &written,
+			Secrets:       []string{"kube-apiserver"},
+			ConfigMaps:    []string{"kube-apiserver-config"},
+		}},
+	}}
+	if len(statuses) != 1 || !statuses[0].UpdateTime.Equal(now) || !statuses[0].Checkpoints[0].LastWriteTime.Equal(written) {
+		t.Fatalf("ListNodeStatuses() = %+v, want: %+v", statuses, want)
+	}
+	// Compare the rest without the time zones of the decoded times.
+	statuses[0].UpdateTime, statuses[0].Checkpoints[0].LastWriteTime = now, &written
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("ListNodeStatuses() = %+v, want: %+v", statuses, want)
+	}
+	cm, err := client.CoreV1().ConfigMaps("pod-checkpointer-status").Get(context.TODO(), "pod-checkpointer-status-node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOwners := []metav1.OwnerReference{{APIVersion: "v1", Kind: "Node", Name: "node1", UID: "node1-uid"}}
+	if !reflect.DeepEqual(cm.OwnerReferences, wantOwners) {
+		t.Errorf("owner references = %+v, want: %+v", cm.OwnerReferences, wantOwners)
+	}
+	if _, ok := c.lastWrites["kube-system/removed"]; ok {
+		t.Errorf("write time of a removed checkpoint was not forgotten")
+	}
+
+	// Unchanged statuses are only published again after statusRefreshPeriod.
+	for _, tc := range []struct {
+		now         time.Time
+		wantUpdated time.Time
+	}{
+		{now.Add(statusRefreshPeriod / 2), now},
+		{now.Add(statusRefreshPeriod), now.Add(statusRefreshPeriod)},
+	} {
+		c.publishStatus(tc.now)
+		statuses, err := ListNodeStatuses(context.TODO(), client, "pod-checkpointer-status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !statuses[0].UpdateTime.Equal(tc.wantUpdated) {
+			t.Errorf("status published at %v was updated at %v, want: %v", tc.now, statuses[0].UpdateTime, tc.wantUpdated)
+		}
+	}
+
+	if got := statuses[0].Problems(now, 5*time.Minute); len(got) != 1 {
+		t.Errorf("Problems() = %q, want: the active checkpoint", got)
+	}
+	if got := statuses[0].Problems(now.Add(10*time.Minute), 5*time.Minute); len(got) != 2 {
+		t.Errorf("Problems() of a stale status = %q, want: the stale status and the active checkpoint", got)
+	}
+}
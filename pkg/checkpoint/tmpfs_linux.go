@@ -0,0 +1,21 @@
+package checkpoint
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// tmpfsMagic is the filesystem type of a tmpfs, see statfs(2).
+const tmpfsMagic = 0x01021994
+
+// checkTmpfs returns an error if path is not on a tmpfs.
+func checkTmpfs(path string) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return err
+	}
+	if st.Type != tmpfsMagic {
+		return fmt.Errorf("not a tmpfs")
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package checkpoint
+
+import "fmt"
+
+// checkTmpfs returns an error, as tmpfs can only be detected on Linux.
+func checkTmpfs(path string) error {
+	return fmt.Errorf("cannot verify that %s is a tmpfs on this platform", path)
+}
@@ -0,0 +1,85 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// defaultTokenExpirationSeconds is the lifetime of service account tokens of projections that
+	// do not set one, like the kubelet.
+	defaultTokenExpirationSeconds = 3600
+	// tokenRefreshFraction is the fraction of the lifetime of a token after which it is refreshed.
+	tokenRefreshFraction = 0.8
+)
+
+// serviceAccountToken returns a token for a serviceAccountToken projection of a parent pod. The
+// token checkpointed at tokenPath is reused until tokenRefreshFraction of its lifetime has passed.
+//
+// Tokens are requested for the service account of the parent pod with the TokenRequest API, but
+// are not bound to the parent pod: a checkpoint runs when the parent pod is gone, and tokens bound
+// to it would be rejected. If a token cannot be requested, for example because the apiserver is
+// unavailable, the checkpointed token is kept until the next attempt.
+func (c *checkpointer) serviceAccountToken(pod *corev1.Pod, projection *corev1.ServiceAccountTokenProjection, tokenPath string) ([]byte, error) {
+	existing, err := c.secrets.readFile(tokenPath)
+	if err == nil && !tokenNeedsRefresh(existing, c.clock.Now()) {
+		return existing, nil
+	}
+
+	serviceAccount := pod.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	expirationSeconds := int64(defaultTokenExpirationSeconds)
+	if projection.ExpirationSeconds != nil {
+		expirationSeconds = *projection.ExpirationSeconds
+	}
+	tr := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expirationSeconds},
+	}
+	if projection.Audience != "" {
+		tr.Spec.Audiences = []string{projection.Audience}
+	}
+	tr, err = c.apiserver.CoreV1().ServiceAccounts(pod.Namespace).CreateToken(context.TODO(), serviceAccount, tr, metav1.CreateOptions{})
+	if err != nil {
+		recordError(errorSourceAPIServer)
+		if len(existing) > 0 {
+			glog.Warningf("Failed to refresh token of service account %s/%s, keeping the checkpointed token: %v", pod.Namespace, serviceAccount, err)
+			return existing, nil
+		}
+		return nil, fmt.Errorf("failed to request token of service account %s/%s: %v", pod.Namespace, serviceAccount, err)
+	}
+	return []byte(tr.Status.Token), nil
+}
+
+// tokenNeedsRefresh returns true if token is not a JWT with an issue and expiry time, or if
+// tokenRefreshFraction of its lifetime has passed at now.
+func tokenNeedsRefresh(token []byte, now time.Time) bool {
+	parts := strings.Split(string(token), ".")
+	if len(parts) != 3 {
+		return true
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return true
+	}
+	var claims struct {
+		IssuedAt  int64 `json:"iat"`
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.IssuedAt == 0 || claims.ExpiresAt <= claims.IssuedAt {
+		return true
+	}
+	lifetime := time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second
+	refreshAt := time.Unix(claims.IssuedAt, 0).Add(time.Duration(float64(lifetime) * tokenRefreshFraction))
+	return !now.Before(refreshAt)
+}
@@ -0,0 +1,253 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-sigs/bootkube/pkg/projection"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// checkpointProjectedVolumes ensures that the projected and downwardAPI volumes of a pod are
+// rendered locally. Each volume is rendered to its own directory, see volumePath().
+func (c *checkpointer) checkpointProjectedVolumes(pod *corev1.Pod) (*corev1.Pod, error) {
+	uid, gid, err := podUserAndGroup(pod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checkpoint volumes for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+
+	for i := range pod.Spec.Volumes {
+		v := &pod.Spec.Volumes[i]
+		var files map[string]projection.File
+		switch {
+		case v.Projected != nil:
+			files, err = c.projectedFiles(pod, v.Name, v.Projected)
+		case v.DownwardAPI != nil:
+			files, err = projection.DownwardAPI(pod, v.DownwardAPI.Items, v.DownwardAPI.DefaultMode, c.nodeAllocatable)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to checkpoint volume %s for pod %s/%s: %v", v.Name, pod.Namespace, pod.Name, err)
+		}
+		var s *secretStore
+		if isEncryptedVolume(v) {
+			s = c.secrets
+		}
+		if err := writeVolumeFiles(volumePath(pod.Namespace, pod.Name, v.Name), files, uid, gid, s); err != nil {
+			return nil, fmt.Errorf("failed to checkpoint volume %s for pod %s/%s: %v", v.Name, pod.Namespace, pod.Name, err)
+		}
+	}
+	return pod, nil
+}
+
+// projectedFiles returns the files of a projected volume.
+func (c *checkpointer) projectedFiles(pod *corev1.Pod, volumeName string, projected *corev1.ProjectedVolumeSource) (map[string]projection.File, error) {
+	files := make(map[string]projection.File)
+	for _, source := range projected.Sources {
+		var sourceFiles map[string]projection.File
+		var err error
+		switch {
+		case source.Secret != nil:
+			sourceFiles, err = c.secretFiles(pod.Namespace, source.Secret.Name, source.Secret.Items, projected.DefaultMode, source.Secret.Optional)
+		case source.ConfigMap != nil:
+			sourceFiles, err = c.configMapFiles(pod.Namespace, source.ConfigMap.Name, source.ConfigMap.Items, projected.DefaultMode, source.ConfigMap.Optional)
+		case source.DownwardAPI != nil:
+			sourceFiles, err = projection.DownwardAPI(pod, source.DownwardAPI.Items, projected.DefaultMode, c.nodeAllocatable)
+		case source.ServiceAccountToken != nil:
+			tokenPath := filepath.Join(volumePath(pod.Namespace, pod.Name, volumeName), source.ServiceAccountToken.Path)
+			var token []byte
+			token, err = c.serviceAccountToken(pod, source.ServiceAccountToken, tokenPath)
+			sourceFiles = map[string]projection.File{source.ServiceAccountToken.Path: {Data: token, Mode: projection.FileMode(nil, projected.DefaultMode)}}
+		default:
+			err = fmt.Errorf("unsupported projected volume source")
+		}
+		if err != nil {
+			return nil, err
+		}
+		for p, data := range sourceFiles {
+			files[p] = data
+		}
+	}
+	return files, nil
+}
+
+// secretFiles returns the files of a secret volume or projection. Missing optional secrets have no
+// files.
+func (c *checkpointer) secretFiles(namespace, name string, items []corev1.KeyToPath, defaultMode *int32, optional *bool) (map[string]projection.File, error) {
+	secret, err := c.watcher.getSecret(namespace, name)
+	if apierrors.IsNotFound(err) && projection.IsOptional(optional) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve secret %s/%s: %v", namespace, name, err)
+	}
+	files, err := projection.Keys(secret.Data, items, defaultMode, projection.IsOptional(optional))
+	if err != nil {
+		return nil, fmt.Errorf("secret %s/%s: %v", namespace, name, err)
+	}
+	return files, nil
+}
+
+// configMapFiles returns the files of a configMap volume or projection. Missing optional
+// configMaps have no files.
+func (c *checkpointer) configMapFiles(namespace, name string, items []corev1.KeyToPath, defaultMode *int32, optional *bool) (map[string]projection.File, error) {
+	configMap, err := c.watcher.getConfigMap(namespace, name)
+	if apierrors.IsNotFound(err) && projection.IsOptional(optional) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve configMap %s/%s: %v", namespace, name, err)
+	}
+	files, err := projection.Keys(projection.ConfigMapData(configMap), items, defaultMode, projection.IsOptional(optional))
+	if err != nil {
+		return nil, fmt.Errorf("configMap %s/%s: %v", namespace, name, err)
+	}
+	return files, nil
+}
+
+// nodeAllocatable returns the allocatable resources of the node, which limits that are not set
+// default to in downwardAPI files.
+func (c *checkpointer) nodeAllocatable() (corev1.ResourceList, error) {
+	node, err := c.apiserver.CoreV1().Nodes().Get(context.TODO(), c.checkpointerPod.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve node %s: %v", c.checkpointerPod.NodeName, err)
+	}
+	return node.Status.Allocatable, nil
+}
+
+// writeVolumeFiles updates the files of a volume below basePath, encrypting them if s is not nil.
+// Only files whose content changed are rewritten, and files that are not in files, like keys that
+// were deleted from a secret, are removed. Files without a mode are only readable by their owner.
+func writeVolumeFiles(basePath string, files map[string]projection.File, uid, gid int, s *secretStore) error {
+	if err := os.MkdirAll(basePath, 0700); err != nil {
+		return fmt.Errorf("failed to create checkpoint path %s: %v", basePath, err)
+	}
+	if err := os.Chown(basePath, uid, gid); err != nil {
+		return fmt.Errorf("failed to chown checkpoint path %s: %v", basePath, err)
+	}
+	keep := make(map[string]bool, len(files))
+	for p, file := range files {
+		// The apiserver validates paths, but they must never escape basePath.
+		clean := path.Clean(p)
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("invalid path %q", p)
+		}
+		keep[clean] = true
+		f := filepath.Join(basePath, filepath.FromSlash(clean))
+		if dir := filepath.Dir(f); dir != basePath {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return fmt.Errorf("failed to create checkpoint path %s: %v", dir, err)
+			}
+			if err := os.Chown(dir, uid, gid); err != nil {
+				return fmt.Errorf("failed to chown checkpoint path %s: %v", dir, err)
+			}
+		}
+		mode := file.Mode
+		if mode == 0 {
+			mode = 0600
+		}
+		data := file.Data
+		if existing, err := ioutil.ReadFile(f); err == nil && s.sameContent(existing, data) {
+			glog.V(4).Infof("Checkpointed file %s is unchanged. Skipping", f)
+			// The owner changes with the securityContext of the parent pod, and the mode with the
+			// volume.
+			if err := os.Chown(f, uid, gid); err != nil {
+				return fmt.Errorf("failed to chown %s: %v", f, err)
+			}
+			if err := os.Chmod(f, mode); err != nil {
+				return fmt.Errorf("failed to chmod %s: %v", f, err)
+			}
+			continue
+		}
+		if s != nil {
+			var err error
+			if data, err = s.encrypt(data); err != nil {
+				return fmt.Errorf("failed to encrypt %s: %v", f, err)
+			}
+		}
+		glog.Infof("Writing checkpointed file %s", f)
+		if err := writeAndAtomicRename(f, data, uid, gid, mode); err != nil {
+			return fmt.Errorf("failed to write %s: %v", f, err)
+		}
+	}
+	return removeStaleFiles(basePath, func(rel string) bool { return keep[filepath.ToSlash(rel)] })
+}
+
+// removeStaleFiles removes the files below basePath for which keep returns false, and the
+// directories that are left empty. keep is called with paths relative to basePath.
+func removeStaleFiles(basePath string, keep func(rel string) bool) error {
+	var dirs []string
+	err := filepath.Walk(basePath, func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p == basePath {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p == basePath {
+			return nil
+		}
+		if fi.IsDir() {
+			dirs = append(dirs, p)
+			return nil
+		}
+		rel, err := filepath.Rel(basePath, p)
+		if err != nil {
+			return err
+		}
+		if keep(rel) {
+			return nil
+		}
+		glog.Infof("Removing stale checkpointed file %s", p)
+		return os.Remove(p)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove stale files of %s: %v", basePath, err)
+	}
+	// Walk visits directories before their contents, so nested directories are removed first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if entries, err := ioutil.ReadDir(dirs[i]); err == nil && len(entries) == 0 {
+			if err := os.Remove(dirs[i]); err != nil {
+				return fmt.Errorf("failed to remove stale directory %s: %v", dirs[i], err)
+			}
+		}
+	}
+	return nil
+}
+
+// secretVolumePath returns the path that a secret volume is checkpointed to. Volumes that select
+// keys with items are checkpointed per volume, as they may differ from other volumes of the same
+// secret.
+func secretVolumePath(namespace, podName string, v *corev1.Volume) string {
+	if len(v.Secret.Items) > 0 {
+		return volumePath(namespace, podName, v.Name)
+	}
+	return secretPath(namespace, podName, v.Secret.SecretName)
+}
+
+// configMapVolumePath returns the path that a configMap volume is checkpointed to, see
+// secretVolumePath().
+func configMapVolumePath(namespace, podName string, v *corev1.Volume) string {
+	if len(v.ConfigMap.Items) > 0 {
+		return volumePath(namespace, podName, v.Name)
+	}
+	return configMapPath(namespace, podName, v.ConfigMap.Name)
+}
+
+func volumePath(namespace, podName, volumeName string) string {
+	return filepath.Join(checkpointVolumePath, namespace, podName, volumeName)
+}
+
+func podFullNameToVolumePath(id string) string {
+	namespace, podname := path.Split(id)
+	return filepath.Join(checkpointVolumePath, namespace, podname)
+}
@@ -0,0 +1,195 @@
+package checkpoint
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+
+	"github.com/kubernetes-sigs/bootkube/pkg/projection"
+)
+
+func TestProjectedFiles(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "ca"},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node"},
+			Status:     v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceMemory: resource.MustParse("4Gi")}},
+		},
+	)
+	var gotRequest *authenticationv1.TokenRequest
+	client.PrependReactor("create", "serviceaccounts", func(action ktesting.Action) (bool, runtime.Object, error) {
+		create := action.(ktesting.CreateAction)
+		if create.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		gotRequest = create.GetObject().(*authenticationv1.TokenRequest)
+		return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{Token: "token"}}, nil
+	})
+	c := &checkpointer{
+		apiserver:       client,
+		watcher:         newAPIWatcher(client, "", nil),
+		clock:           clock.RealClock{},
+		checkpointerPod: CheckpointerPod{NodeName: "node"},
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-checkpointer", Namespace: "kube-system"},
+		Spec: v1.PodSpec{
+			ServiceAccountName: "pod-checkpointer",
+			Containers:         []v1.Container{{Name: "checkpoint"}},
+		},
+	}
+	expiration := int64(3607)
+	defaultMode, caMode := int32(0644), int32(0444)
+	projected := &v1.ProjectedVolumeSource{Sources: []v1.VolumeProjection{
+		{ServiceAccountToken: &v1.ServiceAccountTokenProjection{Path: "token", ExpirationSeconds: &expiration}},
+		{ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: "kube-root-ca.crt"}, Items: []v1.KeyToPath{{Key: "ca.crt", Path: "ca.crt", Mode: &caMode}}}},
+		{DownwardAPI: &v1.DownwardAPIProjection{Items: []v1.DownwardAPIVolumeFile{
+			{Path: "namespace", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+			// The limit is not set, so it is the allocatable memory of the node.
+			{Path: "memory", ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "checkpoint", Resource: "limits.memory", Divisor: resource.MustParse("1Mi")}},
+		}}},
+	}, DefaultMode: &defaultMode}
+	got, err := c.projectedFiles(pod, "kube-api-access", projected)
+	if err != nil {
+		t.Fatalf("projectedFiles() = %v, want: nil", err)
+	}
+	want := map[string]projection.File{
+		"token":     {Data: []byte("token"), Mode: 0644},
+		"ca.crt":    {Data: []byte("ca"), Mode: 0444},
+		"namespace": {Data: []byte("kube-system"), Mode: 0644},
+		"memory":    {Data: []byte("4096"), Mode: 0644},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("projectedFiles() = %q, want: %q", got, want)
+	}
+	if gotRequest == nil || *gotRequest.Spec.ExpirationSeconds != expiration || gotRequest.Spec.BoundObjectRef != nil {
+		t.Errorf("token request = %+v, want: an unbound token that expires after %d seconds", gotRequest, expiration)
+	}
+
+	// Missing optional secrets are empty.
+	optional := true
+	projected = &v1.ProjectedVolumeSource{Sources: []v1.VolumeProjection{
+		{Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: "missing"}, Optional: &optional}},
+	}}
+	if got, err := c.projectedFiles(pod, "optional", projected); err != nil || len(got) != 0 {
+		t.Errorf("projectedFiles() of a missing optional secret = %v, %v, want: no files, nil", got, err)
+	}
+}
+
+func TestWriteVolumeFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newTestSecretStore(t)
+
+	for _, tc := range []struct {
+		desc string
+		s    *secretStore
+	}{
+		{desc: "plaintext"},
+		{desc: "encrypted", s: s},
+	} {
+		basePath := filepath.Join(dir, tc.desc)
+		stat := func(p string) os.FileInfo {
+			fi, err := os.Stat(filepath.Join(basePath, p))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fi
+		}
+
+		files := map[string]projection.File{"a": {Data: []byte("1"), Mode: 0644}, "b": {Data: []byte("2")}, "dir/c": {Data: []byte("3")}}
+		if err := writeVolumeFiles(basePath, files, os.Getuid(), os.Getgid(), tc.s); err != nil {
+			t.Fatalf("%s: writeVolumeFiles() = %v, want: nil", tc.desc, err)
+		}
+		a, b := stat("a"), stat("b")
+
+		// b changes, the mode of a changes, and c is deleted upstream.
+		files = map[string]projection.File{"a": {Data: []byte("1"), Mode: 0440}, "b": {Data: []byte("4")}}
+		if err := writeVolumeFiles(basePath, files, os.Getuid(), os.Getgid(), tc.s); err != nil {
+			t.Fatalf("%s: writeVolumeFiles() = %v, want: nil", tc.desc, err)
+		}
+		for p, want := range files {
+			got, err := s.readFile(filepath.Join(basePath, p))
+			if err != nil || string(got) != string(want.Data) {
+				t.Errorf("%s: file %s = %q, %v, want: %q, nil", tc.desc, p, got, err, want.Data)
+			}
+		}
+		// Files without a mode are only readable by their owner.
+		if got := stat("a").Mode().Perm(); got != 0440 {
+			t.Errorf("%s: mode of file a = %v, want: %v", tc.desc, got, os.FileMode(0440))
+		}
+		if got := stat("b").Mode().Perm(); got != 0600 {
+			t.Errorf("%s: mode of file b = %v, want: %v", tc.desc, got, os.FileMode(0600))
+		}
+		if !os.SameFile(a, stat("a")) {
+			t.Errorf("%s: unchanged file a was rewritten", tc.desc)
+		}
+		if os.SameFile(b, stat("b")) {
+			t.Errorf("%s: changed file b was not rewritten", tc.desc)
+		}
+		if _, err := os.Stat(filepath.Join(basePath, "dir")); !os.IsNotExist(err) {
+			t.Errorf("%s: stale file dir/c was not removed: %v", tc.desc, err)
+		}
+	}
+
+	// Plaintext files are encrypted once encryption is enabled.
+	plaintext := filepath.Join(dir, "plaintext")
+	if err := writeVolumeFiles(plaintext, map[string]projection.File{"a": {Data: []byte("1")}}, os.Getuid(), os.Getgid(), s); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(plaintext, "a")); err != nil || string(data) == "1" {
+		t.Errorf("file a = %q, %v, want: encrypted", data, err)
+	}
+
+	for _, p := range []string{"../escape", "/abs", "dir/../../escape"} {
+		if err := writeVolumeFiles(dir, map[string]projection.File{p: {}}, os.Getuid(), os.Getgid(), nil); err == nil {
+			t.Errorf("writeVolumeFiles() with path %q = nil, want: non-nil", p)
+		}
+	}
+}
+
+func TestTokenNeedsRefresh(t *testing.T) {
+	issued := time.Unix(1600000000, 0)
+	token := func(iat, exp int64) []byte {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"iat":%d,"exp":%d}`, iat, exp)))
+		return []byte("header." + payload + ".signature")
+	}
+	valid := token(issued.Unix(), issued.Add(time.Hour).Unix())
+	for _, tc := range []struct {
+		desc  string
+		token []byte
+		now   time.Time
+		want  bool
+	}{
+		{desc: "fresh token", token: valid, now: issued.Add(time.Minute), want: false},
+		{desc: "token at 80% of its lifetime", token: valid, now: issued.Add(48 * time.Minute), want: true},
+		{desc: "expired token", token: valid, now: issued.Add(2 * time.Hour), want: true},
+		{desc: "not a JWT", token: []byte("token"), now: issued, want: true},
+		{desc: "no expiry", token: token(issued.Unix(), 0), now: issued, want: true},
+	} {
+		if got := tokenNeedsRefresh(tc.token, tc.now); got != tc.want {
+			t.Errorf("%s: tokenNeedsRefresh() = %t, want: %t", tc.desc, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package checkpoint
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	secretKind    = "secret"
+	configMapKind = "configmap"
+
+	// apiProbeTimeout is the timeout of the request that checks whether the apiserver is available.
+	apiProbeTimeout = 5 * time.Second
+)
+
+// objectKey identifies a secret or configMap that is referenced by a parent pod.
+type objectKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// String() implements fmt.Stringer.String().
+func (k objectKey) String() string {
+	return k.kind + "/" + k.namespace + "/" + k.name
+}
+
+// objectWatch is a watch on a single secret or configMap.
+type objectWatch struct {
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+}
+
+// apiWatcher watches the parent pods scheduled to this node and the secrets and configMaps that
+// they reference, so that the checkpointer does not need to poll the apiserver. It signals changed
+// whenever one of them changes.
+type apiWatcher struct {
+	client kubernetes.Interface
+	// pods holds a pod informer per watched namespace.
+	pods    []cache.SharedIndexInformer
+	changed chan struct{}
+	// probe returns an error if the apiserver is not available. The informer caches keep serving
+	// the last known state while the apiserver is down, so they cannot be used to detect this.
+	probe func(context.Context) error
+
+	mu             sync.Mutex
+	objects        map[objectKey]*objectWatch
+	changedObjects map[objectKey]bool
+}
+
+// newAPIWatcher returns an apiWatcher for the parent pods on nodeName in the given namespaces,
+// which may be metav1.NamespaceAll.
+func newAPIWatcher(client kubernetes.Interface, nodeName string, namespaces []string) *apiWatcher {
+	w := &apiWatcher{
+		client:         client,
+		changed:        make(chan struct{}, 1),
+		objects:        make(map[objectKey]*objectWatch),
+		changedObjects: make(map[objectKey]bool),
+	}
+	w.probe = func(ctx context.Context) error {
+		return client.CoreV1().RESTClient().Get().AbsPath("/healthz").Do(ctx).Error()
+	}
+	for _, namespace := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+			}))
+		pods := factory.Core().V1().Pods().Informer()
+		pods.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { w.notify() },
+			UpdateFunc: func(interface{}, interface{}) { w.notify() },
+			DeleteFunc: func(interface{}) { w.notify() },
+		})
+		w.pods = append(w.pods, pods)
+	}
+	return w
+}
+
+// start starts the pod watches. They stop when stop is closed.
+func (w *apiWatcher) start(stop <-chan struct{}) {
+	for _, pods := range w.pods {
+		go pods.Run(stop)
+	}
+}
+
+// podsSynced returns true once the pods of all watched namespaces have been listed.
+func (w *apiWatcher) podsSynced() bool {
+	for _, pods := range w.pods {
+		if !pods.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// notify signals changed without blocking. Notifications that arrive while the checkpointer is
+// busy are coalesced.
+func (w *apiWatcher) notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// parentPods returns the parent pods on this node from the apiserver. It returns false if the
+// apiserver is not available or the pods have not been listed yet.
+func (w *apiWatcher) parentPods() (bool, map[string]*v1.Pod) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiProbeTimeout)
+	defer cancel()
+	if err := w.probe(ctx); err != nil {
+		glog.Warningf("Unable to contact APIServer, skipping garbage collection: %v", err)
+		recordError(errorSourceAPIServer)
+		return false, nil
+	}
+	if !w.podsSynced() {
+		glog.Warningf("Pods have not been listed from the APIServer yet, skipping garbage collection")
+		return false, nil
+	}
+
+	// The cached pods are shared and must not be modified.
+	podList := &v1.PodList{}
+	for _, pods := range w.pods {
+		for _, obj := range pods.GetStore().List() {
+			podList.Items = append(podList.Items, *obj.(*v1.Pod).DeepCopy())
+		}
+	}
+	return true, podListToParentPods(podList)
+}
+
+// watchObjects watches the given secrets and configMaps, and stops watching all others.
+func (w *apiWatcher) watchObjects(keys map[objectKey]bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, ow := range w.objects {
+		if !keys[key] {
+			glog.V(4).Infof("Stopping watch of %s", key)
+			close(ow.stop)
+			delete(w.objects, key)
+			delete(w.changedObjects, key)
+		}
+	}
+	for key := range keys {
+		if _, ok := w.objects[key]; ok {
+			continue
+		}
+		glog.V(4).Infof("Starting watch of %s", key)
+		ow := &objectWatch{
+			informer: cache.NewSharedIndexInformer(w.listWatch(key), newObject(key.kind), 0, cache.Indexers{}),
+			stop:     make(chan struct{}),
+		}
+		key := key
+		ow.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { w.objectChanged(key) },
+			UpdateFunc: func(interface{}, interface{}) { w.objectChanged(key) },
+			DeleteFunc: func(interface{}) { w.objectChanged(key) },
+		})
+		w.objects[key] = ow
+		go ow.informer.Run(ow.stop)
+	}
+}
+
+// listWatch returns a ListWatch for the single object identified by key.
+func (w *apiWatcher) listWatch(key objectKey) *cache.ListWatch {
+	selector := fields.OneTermEqualSelector("metadata.name", key.name).String()
+	if key.kind == secretKind {
+		secrets := w.client.CoreV1().Secrets(key.namespace)
+		return &cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				opts.FieldSelector = selector
+				return secrets.List(context.TODO(), opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				opts.FieldSelector = selector
+				return secrets.Watch(context.TODO(), opts)
+			},
+		}
+	}
+	configMaps := w.client.CoreV1().ConfigMaps(key.namespace)
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = selector
+			return configMaps.List(context.TODO(), opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = selector
+			return configMaps.Watch(context.TODO(), opts)
+		},
+	}
+}
+
+func newObject(kind string) runtime.Object {
+	if kind == secretKind {
+		return &v1.Secret{}
+	}
+	return &v1.ConfigMap{}
+}
+
+// objectChanged records that a watched object changed and signals changed.
+func (w *apiWatcher) objectChanged(key objectKey) {
+	w.mu.Lock()
+	w.changedObjects[key] = true
+	w.mu.Unlock()
+	w.notify()
+}
+
+// takeChangedObjects returns the watched objects that changed since the last call.
+func (w *apiWatcher) takeChangedObjects() map[objectKey]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	changed := w.changedObjects
+	w.changedObjects = make(map[objectKey]bool)
+	return changed
+}
+
+// object returns the watched object identified by key. It returns false if the object is not
+// watched or has not been listed yet, and nil if it does not exist. The watch is authoritative once
+// it has listed the object, so the apiserver is only queried for objects that are not watched yet.
+func (w *apiWatcher) object(key objectKey) (interface{}, bool) {
+	w.mu.Lock()
+	ow, ok := w.objects[key]
+	w.mu.Unlock()
+	if !ok || !ow.informer.HasSynced() {
+		return nil, false
+	}
+	obj, exists, err := ow.informer.GetStore().GetByKey(key.namespace + "/" + key.name)
+	if err != nil || !exists {
+		return nil, true
+	}
+	return obj, true
+}
+
+// getSecret returns a secret from its watch if possible, or from the apiserver otherwise.
+func (w *apiWatcher) getSecret(namespace, name string) (*v1.Secret, error) {
+	if obj, ok := w.object(objectKey{secretKind, namespace, name}); ok {
+		if obj == nil {
+			return nil, apierrors.NewNotFound(v1.Resource("secrets"), name)
+		}
+		return obj.(*v1.Secret).DeepCopy(), nil
+	}
+	secret, err := w.client.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		recordError(errorSourceAPIServer)
+	}
+	return secret, err
+}
+
+// getConfigMap returns a configMap from its watch if possible, or from the apiserver otherwise.
+func (w *apiWatcher) getConfigMap(namespace, name string) (*v1.ConfigMap, error) {
+	if obj, ok := w.object(objectKey{configMapKind, namespace, name}); ok {
+		if obj == nil {
+			return nil, apierrors.NewNotFound(v1.Resource("configmaps"), name)
+		}
+		return obj.(*v1.ConfigMap).DeepCopy(), nil
+	}
+	configMap, err := w.client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		recordError(errorSourceAPIServer)
+	}
+	return configMap, err
+}
+
+// referencedObjects returns the secrets and configMaps that are mounted by the given pods, directly
+// or through projected volumes.
+func referencedObjects(pods []*v1.Pod) map[objectKey]bool {
+	keys := make(map[objectKey]bool)
+	for _, pod := range pods {
+		for _, v := range pod.Spec.Volumes {
+			switch {
+			case v.Secret != nil:
+				keys[objectKey{secretKind, pod.Namespace, v.Secret.SecretName}] = true
+			case v.ConfigMap != nil:
+				keys[objectKey{configMapKind, pod.Namespace, v.ConfigMap.Name}] = true
+			case v.Projected != nil:
+				for _, source := range v.Projected.Sources {
+					switch {
+					case source.Secret != nil:
+						keys[objectKey{secretKind, pod.Namespace, source.Secret.Name}] = true
+					case source.ConfigMap != nil:
+						keys[objectKey{configMapKind, pod.Namespace, source.ConfigMap.Name}] = true
+					}
+				}
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,150 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestWatcher(objects ...runtime.Object) (*apiWatcher, *fake.Clientset) {
+	client := fake.NewSimpleClientset(objects...)
+	w := newAPIWatcher(client, "node1", []string{"kube-system"})
+	w.probe = func(context.Context) error { return nil }
+	return w, client
+}
+
+func TestAPIWatcherParentPods(t *testing.T) {
+	parent := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "kube-apiserver",
+			Namespace:   "kube-system",
+			Annotations: map[string]string{shouldCheckpointAnnotation: shouldCheckpoint},
+		},
+		Spec: v1.PodSpec{NodeName: "node1"},
+	}
+	other := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "kube-system"},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+	}
+	w, client := newTestWatcher(parent, other)
+	stop := make(chan struct{})
+	defer close(stop)
+	w.start(stop)
+
+	waitChanged(t, w)
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) { return w.podsSynced(), nil }); err != nil {
+		t.Fatalf("pods were not listed: %v", err)
+	}
+	available, pods := w.parentPods()
+	if !available {
+		t.Fatalf("parentPods() available = false, want: true")
+	}
+	if _, ok := pods["kube-system/kube-apiserver"]; !ok || len(pods) != 1 {
+		t.Errorf("parentPods() = %v, want: [kube-system/kube-apiserver]", pods)
+	}
+
+	// Changes to pods are signaled.
+	updated := parent.DeepCopy()
+	updated.Spec.Containers = []v1.Container{{Name: "kube-apiserver"}}
+	if _, err := client.CoreV1().Pods("kube-system").Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitChanged(t, w)
+
+	// The cache is not used if the apiserver is unavailable.
+	w.probe = func(context.Context) error { return fmt.Errorf("connection refused") }
+	if available, _ := w.parentPods(); available {
+		t.Errorf("parentPods() with an unavailable apiserver available = true, want: false")
+	}
+}
+
+func TestAPIWatcherObjects(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "kube-system"},
+		Data:       map[string][]byte{"token": []byte("a")},
+	}
+	w, client := newTestWatcher(secret)
+	key := objectKey{secretKind, "kube-system", "kube-apiserver"}
+
+	// Objects that are not watched are retrieved from the apiserver.
+	got, err := w.getSecret("kube-system", "kube-apiserver")
+	if err != nil || !reflect.DeepEqual(got.Data, secret.Data) {
+		t.Fatalf("getSecret() = %v, %v, want: %v, nil", got, err, secret)
+	}
+
+	w.watchObjects(map[objectKey]bool{key: true})
+	defer w.watchObjects(nil)
+	waitChanged(t, w)
+	if changed := w.takeChangedObjects(); !changed[key] {
+		t.Errorf("takeChangedObjects() = %v, want: %v", changed, key)
+	}
+
+	updated := secret.DeepCopy()
+	updated.Data["token"] = []byte("b")
+	if _, err := client.CoreV1().Secrets("kube-system").Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitChanged(t, w)
+	if changed := w.takeChangedObjects(); !changed[key] {
+		t.Errorf("takeChangedObjects() after an update = %v, want: %v", changed, key)
+	}
+	got, err = w.getSecret("kube-system", "kube-apiserver")
+	if err != nil || !reflect.DeepEqual(got.Data, updated.Data) {
+		t.Errorf("getSecret() after an update = %v, %v, want: %v, nil", got, err, updated)
+	}
+
+	// Deleted objects are not found in the watch, without querying the apiserver.
+	if err := client.CoreV1().Secrets("kube-system").Delete(context.TODO(), "kube-apiserver", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitChanged(t, w)
+	client.ClearActions()
+	if _, err := w.getSecret("kube-system", "kube-apiserver"); !apierrors.IsNotFound(err) {
+		t.Errorf("getSecret() after a delete = %v, want: not found", err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("getSecret() of a watched object sent requests %v, want: none", actions)
+	}
+
+	w.watchObjects(nil)
+	if len(w.objects) != 0 {
+		t.Errorf("watchObjects(nil) left %d watches running, want: 0", len(w.objects))
+	}
+}
+
+func TestReferencedObjects(t *testing.T) {
+	pods := []*v1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "kube-system"},
+		Spec: v1.PodSpec{Volumes: []v1.Volume{
+			{Name: "secrets", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "kube-apiserver"}}},
+			{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "kube-apiserver"}}}},
+			{Name: "host", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/etc/kubernetes"}}},
+		}},
+	}}
+	want := map[objectKey]bool{
+		{secretKind, "kube-system", "kube-apiserver"}:    true,
+		{configMapKind, "kube-system", "kube-apiserver"}: true,
+	}
+	if got := referencedObjects(pods); !reflect.DeepEqual(got, want) {
+		t.Errorf("referencedObjects() = %v, want: %v", got, want)
+	}
+}
+
+// waitChanged waits until w signals a change.
+func waitChanged(t *testing.T, w *apiWatcher) {
+	t.Helper()
+	select {
+	case <-w.changed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a change")
+	}
+}
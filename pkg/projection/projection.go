@@ -0,0 +1,194 @@
+// Package projection renders the files of secret, configMap, downwardAPI and projected volumes the
+// same way as the kubelet. It is shared by the checkpointer and by recovery, which both render such
+// volumes without a kubelet.
+package projection
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// File is a file of a volume.
+type File struct {
+	Data []byte
+	// Mode is the mode of the item, or the default mode of the volume. It is 0 if neither is set.
+	Mode os.FileMode
+}
+
+// AllocatableFunc returns the allocatable resources of the node that a pod runs on. It is only
+// called for limits that are not set, which default to them.
+type AllocatableFunc func() (v1.ResourceList, error)
+
+// Keys maps the keys of a secret or configMap to files. Without items every key is a file named
+// after it. Keys that are missing from data are an error unless optional is set.
+func Keys(data map[string][]byte, items []v1.KeyToPath, defaultMode *int32, optional bool) (map[string]File, error) {
+	files := make(map[string]File)
+	if len(items) == 0 {
+		for k, v := range data {
+			files[k] = File{Data: v, Mode: FileMode(nil, defaultMode)}
+		}
+		return files, nil
+	}
+	for _, item := range items {
+		v, ok := data[item.Key]
+		if !ok {
+			if optional {
+				continue
+			}
+			return nil, fmt.Errorf("key %q not found", item.Key)
+		}
+		files[item.Path] = File{Data: v, Mode: FileMode(item.Mode, defaultMode)}
+	}
+	return files, nil
+}
+
+// ConfigMapData returns the data and binary data of a configMap.
+func ConfigMapData(configMap *v1.ConfigMap) map[string][]byte {
+	data := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+	for k, v := range configMap.BinaryData {
+		data[k] = v
+	}
+	for k, v := range configMap.Data {
+		data[k] = []byte(v)
+	}
+	return data
+}
+
+// DownwardAPI renders the files of a downwardAPI volume or projection of pod. allocatable may be
+// nil if the node is not known, in which case limits that are not set are an error.
+func DownwardAPI(pod *v1.Pod, items []v1.DownwardAPIVolumeFile, defaultMode *int32, allocatable AllocatableFunc) (map[string]File, error) {
+	files := make(map[string]File)
+	for _, item := range items {
+		var value string
+		var err error
+		switch {
+		case item.FieldRef != nil:
+			value, err = FieldValue(pod, item.FieldRef.FieldPath)
+		case item.ResourceFieldRef != nil:
+			value, err = ResourceValue(pod, item.ResourceFieldRef, allocatable)
+		default:
+			err = fmt.Errorf("no fieldRef or resourceFieldRef")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("downwardAPI file %s: %v", item.Path, err)
+		}
+		files[item.Path] = File{Data: []byte(value), Mode: FileMode(item.Mode, defaultMode)}
+	}
+	return files, nil
+}
+
+// FieldValue returns the value of a metadata field of a pod, formatted like the kubelet does.
+// These are the only fields that downwardAPI volumes support.
+func FieldValue(pod *v1.Pod, fieldPath string) (string, error) {
+	if p := strings.TrimSuffix(fieldPath, "']"); p != fieldPath {
+		switch {
+		case strings.HasPrefix(p, "metadata.labels['"):
+			return pod.Labels[strings.TrimPrefix(p, "metadata.labels['")], nil
+		case strings.HasPrefix(p, "metadata.annotations['"):
+			return pod.Annotations[strings.TrimPrefix(p, "metadata.annotations['")], nil
+		}
+	}
+	switch fieldPath {
+	case "metadata.name":
+		return pod.Name, nil
+	case "metadata.namespace":
+		return pod.Namespace, nil
+	case "metadata.uid":
+		// Pods that were rendered from a template, like bootstrap pods, have no UID yet.
+		if pod.UID == "" {
+			return "", fmt.Errorf("the UID of pod %s is not known", pod.Name)
+		}
+		return string(pod.UID), nil
+	case "metadata.labels":
+		return FormatMap(pod.Labels), nil
+	case "metadata.annotations":
+		return FormatMap(pod.Annotations), nil
+	}
+	return "", fmt.Errorf("unsupported fieldPath %q", fieldPath)
+}
+
+// FormatMap formats labels or annotations as key="value" lines, sorted by key.
+func FormatMap(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, k := range keys {
+		lines = append(lines, k+"="+strconv.Quote(m[k]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ResourceValue returns the value of a resource request or limit of a container, divided by the
+// divisor and rounded up. Like the kubelet, limits that are not set default to the allocatable
+// resources of the node, and requests that are not set are 0.
+func ResourceValue(pod *v1.Pod, ref *v1.ResourceFieldSelector, allocatable AllocatableFunc) (string, error) {
+	var container *v1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == ref.ContainerName {
+			container = &pod.Spec.Containers[i]
+		}
+	}
+	if container == nil {
+		return "", fmt.Errorf("container %q not found", ref.ContainerName)
+	}
+
+	var q resource.Quantity
+	var name v1.ResourceName
+	switch {
+	case strings.HasPrefix(ref.Resource, "limits."):
+		name = v1.ResourceName(strings.TrimPrefix(ref.Resource, "limits."))
+		var ok bool
+		if q, ok = container.Resources.Limits[name]; !ok {
+			if allocatable == nil {
+				return "", fmt.Errorf("%s of container %q is not set and the node is not known", ref.Resource, ref.ContainerName)
+			}
+			nodeAllocatable, err := allocatable()
+			if err != nil {
+				return "", err
+			}
+			if q, ok = nodeAllocatable[name]; !ok {
+				return "", fmt.Errorf("%s of container %q is not set and the node has no allocatable %s", ref.Resource, ref.ContainerName, name)
+			}
+		}
+	case strings.HasPrefix(ref.Resource, "requests."):
+		name = v1.ResourceName(strings.TrimPrefix(ref.Resource, "requests."))
+		q = container.Resources.Requests[name]
+	default:
+		return "", fmt.Errorf("unsupported resource %q", ref.Resource)
+	}
+
+	divisor := ref.Divisor
+	if divisor.IsZero() {
+		divisor = resource.MustParse("1")
+	}
+	if name == v1.ResourceCPU {
+		return strconv.FormatInt(int64(math.Ceil(float64(q.MilliValue())/float64(divisor.MilliValue()))), 10), nil
+	}
+	return strconv.FormatInt(int64(math.Ceil(float64(q.Value())/float64(divisor.Value()))), 10), nil
+}
+
+// FileMode returns mode if set, otherwise defaultMode, or 0 if neither is set.
+func FileMode(mode, defaultMode *int32) os.FileMode {
+	if mode != nil {
+		return os.FileMode(*mode)
+	}
+	if defaultMode != nil {
+		return os.FileMode(*defaultMode)
+	}
+	return 0
+}
+
+// IsOptional reports whether a secret or configMap source is optional.
+func IsOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
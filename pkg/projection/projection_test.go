@@ -0,0 +1,200 @@
+package projection
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestKeys(t *testing.T) {
+	data := map[string][]byte{"a": []byte("1"), "b": []byte("2")}
+	defaultMode := int32(0644)
+	mode := int32(0400)
+	for _, tc := range []struct {
+		desc     string
+		items    []v1.KeyToPath
+		optional bool
+		want     map[string]File
+		wantErr  bool
+	}{
+		{
+			desc: "all keys",
+			want: map[string]File{"a": {Data: []byte("1"), Mode: 0644}, "b": {Data: []byte("2"), Mode: 0644}},
+		},
+		{
+			desc:  "items with modes",
+			items: []v1.KeyToPath{{Key: "a", Path: "dir/a.txt", Mode: &mode}, {Key: "b", Path: "b.txt"}},
+			want:  map[string]File{"dir/a.txt": {Data: []byte("1"), Mode: 0400}, "b.txt": {Data: []byte("2"), Mode: 0644}},
+		},
+		{
+			desc:    "missing key",
+			items:   []v1.KeyToPath{{Key: "c", Path: "c"}},
+			wantErr: true,
+		},
+		{
+			desc:     "missing optional key",
+			items:    []v1.KeyToPath{{Key: "a", Path: "a"}, {Key: "c", Path: "c"}},
+			optional: true,
+			want:     map[string]File{"a": {Data: []byte("1"), Mode: 0644}},
+		},
+	} {
+		got, err := Keys(data, tc.items, &defaultMode, tc.optional)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: Keys() = %v, want error: %t", tc.desc, err, tc.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Keys() = %v, want: %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestConfigMapData(t *testing.T) {
+	configMap := &v1.ConfigMap{
+		Data:       map[string]string{"a": "1"},
+		BinaryData: map[string][]byte{"b": {2}},
+	}
+	want := map[string][]byte{"a": []byte("1"), "b": {2}}
+	if got := ConfigMapData(configMap); !reflect.DeepEqual(got, want) {
+		t.Errorf("ConfigMapData() = %v, want: %v", got, want)
+	}
+}
+
+func TestDownwardAPI(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "kube-apiserver",
+			Namespace:   "kube-system",
+			UID:         "uid",
+			Labels:      map[string]string{"tier": "control-plane", "k8s-app": "kube-apiserver"},
+			Annotations: map[string]string{"checkpointer.alpha.coreos.com/checkpoint": "true"},
+		},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name: "kube-apiserver",
+			Resources: v1.ResourceRequirements{
+				Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("1500m")},
+				Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+		}}},
+	}
+	allocatable := func() (v1.ResourceList, error) {
+		return v1.ResourceList{v1.ResourceMemory: resource.MustParse("4Gi")}, nil
+	}
+	failing := func() (v1.ResourceList, error) {
+		return nil, errors.New("node not found")
+	}
+	for _, tc := range []struct {
+		desc        string
+		item        v1.DownwardAPIVolumeFile
+		allocatable AllocatableFunc
+		want        string
+		wantErr     bool
+	}{
+		{
+			desc: "namespace",
+			item: v1.DownwardAPIVolumeFile{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+			want: "kube-system",
+		},
+		{
+			desc: "labels",
+			item: v1.DownwardAPIVolumeFile{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.labels"}},
+			want: "k8s-app=\"kube-apiserver\"\ntier=\"control-plane\"",
+		},
+		{
+			desc: "annotation",
+			item: v1.DownwardAPIVolumeFile{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.annotations['checkpointer.alpha.coreos.com/checkpoint']"}},
+			want: "true",
+		},
+		{
+			desc: "uid",
+			item: v1.DownwardAPIVolumeFile{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.uid"}},
+			want: "uid",
+		},
+		{
+			desc: "cpu limit",
+			item: v1.DownwardAPIVolumeFile{ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "limits.cpu"}},
+			want: "2",
+		},
+		{
+			desc: "memory request with divisor",
+			item: v1.DownwardAPIVolumeFile{ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "requests.memory", Divisor: resource.MustParse("1Mi")}},
+			want: "1024",
+		},
+		{
+			desc: "unset request",
+			item: v1.DownwardAPIVolumeFile{ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "requests.cpu"}},
+			want: "0",
+		},
+		{
+			desc:        "unset limit",
+			item:        v1.DownwardAPIVolumeFile{ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "limits.memory", Divisor: resource.MustParse("1Mi")}},
+			allocatable: allocatable,
+			want:        "4096",
+		},
+		{
+			desc:    "unset limit without node",
+			item:    v1.DownwardAPIVolumeFile{ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "limits.memory"}},
+			wantErr: true,
+		},
+		{
+			desc:        "unset limit without allocatable resource",
+			item:        v1.DownwardAPIVolumeFile{ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "limits.ephemeral-storage"}},
+			allocatable: allocatable,
+			wantErr:     true,
+		},
+		{
+			desc:        "unset limit with failing node lookup",
+			item:        v1.DownwardAPIVolumeFile{ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "limits.memory"}},
+			allocatable: failing,
+			wantErr:     true,
+		},
+		{
+			desc:    "unknown container",
+			item:    v1.DownwardAPIVolumeFile{ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "etcd", Resource: "requests.cpu"}},
+			wantErr: true,
+		},
+		{
+			desc:    "unsupported field",
+			item:    v1.DownwardAPIVolumeFile{FieldRef: &v1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+			wantErr: true,
+		},
+	} {
+		tc.item.Path = "file"
+		got, err := DownwardAPI(pod, []v1.DownwardAPIVolumeFile{tc.item}, nil, tc.allocatable)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: DownwardAPI() = %v, want error: %t", tc.desc, err, tc.wantErr)
+			continue
+		}
+		if err == nil && string(got["file"].Data) != tc.want {
+			t.Errorf("%s: DownwardAPI() = %q, want: %q", tc.desc, got["file"].Data, tc.want)
+		}
+	}
+
+	// Bootstrap pods are rendered from templates and have no UID.
+	pod.UID = ""
+	uid := []v1.DownwardAPIVolumeFile{{Path: "uid", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.uid"}}}
+	if got, err := DownwardAPI(pod, uid, nil, nil); err == nil {
+		t.Errorf("DownwardAPI() of a pod without UID = %v, nil, want: non-nil", got)
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	mode, defaultMode := int32(0400), int32(0644)
+	for _, tc := range []struct {
+		mode, defaultMode *int32
+		want              os.FileMode
+	}{
+		{nil, nil, 0},
+		{nil, &defaultMode, 0644},
+		{&mode, &defaultMode, 0400},
+	} {
+		if got := FileMode(tc.mode, tc.defaultMode); got != tc.want {
+			t.Errorf("FileMode(%v, %v) = %v, want: %v", tc.mode, tc.defaultMode, got, tc.want)
+		}
+	}
+}
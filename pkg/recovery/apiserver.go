@@ -2,20 +2,26 @@ package recovery
 
 import (
 	"context"
+	"fmt"
+	"os"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// apiServerPageSize is the number of objects that are read from the API server per request.
+const apiServerPageSize = 500
+
 type apiServerBackend struct {
-	client *kubernetes.Clientset
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
 }
 
 // NewAPIServerBackend constructs a new backend to talk to the API server using the given
 // kubeConfig.
-//
-// TODO(diegs): support using a service account instead of a kubeconfig.
 func NewAPIServerBackend(kubeConfigPath string) (Backend, error) {
 	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeConfigPath},
@@ -24,37 +30,127 @@ func NewAPIServerBackend(kubeConfigPath string) (Backend, error) {
 	if err != nil {
 		return nil, err
 	}
+	return NewAPIServerBackendForConfig(config)
+}
+
+// NewAPIServerBackendForConfig constructs a new backend to talk to the API server using the given
+// client config, such as one returned by rest.InClusterConfig() or ServiceAccountConfig().
+func NewAPIServerBackendForConfig(config *rest.Config) (Backend, error) {
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
 	return &apiServerBackend{
-		client: client,
+		client:  client,
+		dynamic: dynamicClient,
+	}, nil
+}
+
+// ServiceAccountConfig returns a client config that authenticates to the API server at server
+// using the service account token in tokenFile, and verifies the API server using the CA
+// certificate in caFile. The token file is re-read as needed, so rotated tokens are picked up.
+func ServiceAccountConfig(server, tokenFile, caFile string) (*rest.Config, error) {
+	for _, f := range []string{tokenFile, caFile} {
+		if _, err := os.Stat(f); err != nil {
+			return nil, fmt.Errorf("failed to read service account credentials: %v", err)
+		}
+	}
+	return &rest.Config{
+		Host:            server,
+		BearerTokenFile: tokenFile,
+		TLSClientConfig: rest.TLSClientConfig{CAFile: caFile},
 	}, nil
 }
 
 // read implements Backend.read().
-func (b *apiServerBackend) read(ctx context.Context) (*controlPlane, error) {
+func (b *apiServerBackend) read(ctx context.Context, scope Scope) (*controlPlane, error) {
 	cp := &controlPlane{}
-	configMaps, err := b.client.CoreV1().ConfigMaps("kube-system").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+	for _, r := range scope.resources() {
+		for _, ns := range scope.namespacesOf(r) {
+			if err := b.list(ctx, cp, r, ns); err != nil {
+				return nil, fmt.Errorf("failed to list %s: %v", describeResource(r, ns), err)
+			}
+		}
 	}
-	cp.configMaps = *configMaps
-	deployments, err := b.client.AppsV1().Deployments("kube-system").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
-	}
-	cp.deployments = *deployments
-	daemonSets, err := b.client.AppsV1().DaemonSets("kube-system").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+	return cp, nil
+}
+
+// list adds the objects of resource r in namespace ns, or of a cluster-scoped r, to cp, apiServerPageSize objects at a time.
+// Resources that are not needed for recovery are read using the dynamic client.
+func (b *apiServerBackend) list(ctx context.Context, cp *controlPlane, r Resource, ns string) error {
+	var listPage func(metav1.ListOptions) (string, error)
+	switch r.groupResource() {
+	case configMapsResource.groupResource():
+		listPage = func(opts metav1.ListOptions) (string, error) {
+			l, err := b.client.CoreV1().ConfigMaps(ns).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			cp.configMaps.Items = append(cp.configMaps.Items, l.Items...)
+			return l.Continue, nil
+		}
+	case daemonSetsResource.groupResource():
+		listPage = func(opts metav1.ListOptions) (string, error) {
+			l, err := b.client.AppsV1().DaemonSets(ns).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			cp.daemonSets.Items = append(cp.daemonSets.Items, l.Items...)
+			return l.Continue, nil
+		}
+	case deploymentsResource.groupResource():
+		listPage = func(opts metav1.ListOptions) (string, error) {
+			l, err := b.client.AppsV1().Deployments(ns).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			cp.deployments.Items = append(cp.deployments.Items, l.Items...)
+			return l.Continue, nil
+		}
+	case secretsResource.groupResource():
+		listPage = func(opts metav1.ListOptions) (string, error) {
+			l, err := b.client.CoreV1().Secrets(ns).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			cp.secrets.Items = append(cp.secrets.Items, l.Items...)
+			return l.Continue, nil
+		}
+	case nodesResource.groupResource():
+		listPage = func(opts metav1.ListOptions) (string, error) {
+			l, err := b.client.CoreV1().Nodes().List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			cp.nodes.Items = append(cp.nodes.Items, l.Items...)
+			return l.Continue, nil
+		}
+	default:
+		listPage = func(opts metav1.ListOptions) (string, error) {
+			l, err := b.dynamic.Resource(r.GroupVersionResource()).Namespace(ns).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, obj := range l.Items {
+				cp.addObject(r, obj)
+			}
+			return l.GetContinue(), nil
+		}
 	}
-	cp.daemonSets = *daemonSets
-	secrets, err := b.client.CoreV1().Secrets("kube-system").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+
+	opts := metav1.ListOptions{Limit: apiServerPageSize}
+	for {
+		next, err := listPage(opts)
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Continue = next
 	}
-	cp.secrets = *secrets
-	return cp, nil
 }
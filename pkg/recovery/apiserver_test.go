@@ -0,0 +1,35 @@
+package recovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServiceAccountConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recovery-sa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile, caFile := filepath.Join(dir, "token"), filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(tokenFile, []byte("token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ServiceAccountConfig("https://10.0.0.1:6443", tokenFile, caFile); err == nil {
+		t.Errorf("ServiceAccountConfig() with missing CA file = nil, want: non-nil")
+	}
+
+	if err := ioutil.WriteFile(caFile, []byte("ca"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ServiceAccountConfig("https://10.0.0.1:6443", tokenFile, caFile)
+	if err != nil {
+		t.Fatalf("ServiceAccountConfig() = %v, want: nil", err)
+	}
+	if config.Host != "https://10.0.0.1:6443" || config.BearerTokenFile != tokenFile || config.CAFile != caFile {
+		t.Errorf("ServiceAccountConfig() = %+v, want host, token file and CA file set", config)
+	}
+}
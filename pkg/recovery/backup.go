@@ -0,0 +1,340 @@
+package recovery
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/kubernetes-sigs/bootkube/pkg/version"
+	etcdsnapshot "go.etcd.io/etcd/clientv3/snapshot"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// BackupManifestName is the name of the manifest in a backup archive. It is always the first
+	// file in the archive.
+	BackupManifestName = "MANIFEST.json"
+	// BackupSnapshotName is the name of the etcd snapshot in a backup archive, if any.
+	BackupSnapshotName = "etcd/snapshot.db"
+)
+
+// BackupManifest describes the contents of a backup archive.
+type BackupManifest struct {
+	// Version is the version of bootkube that created the backup.
+	Version string `json:"version"`
+	// Created is the time at which the backup was created.
+	Created time.Time `json:"created"`
+	// Namespaces are the namespaces that objects were read from.
+	Namespaces []string `json:"namespaces"`
+	// Resources are the resources that objects were read for.
+	Resources []Resource `json:"resources"`
+	// SnapshotRevision is the etcd revision of the snapshot, if any. The objects were read at the
+	// same revision.
+	SnapshotRevision int64 `json:"snapshotRevision,omitempty"`
+	// Files are the other files in the archive.
+	Files []BackupFile `json:"files"`
+}
+
+// BackupFile describes a single file in a backup archive.
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupFileName returns the name of the file in a backup archive that holds the objects of r.
+func backupFileName(r Resource) string {
+	return path.Join("objects", r.Group, r.Version, r.Resource+".json")
+}
+
+// Backup writes a gzip compressed tar archive to w that contains the objects in scope read from
+// backend and, if snapshot is non-nil, an etcd snapshot. The nodes are always included, so that
+// bootstrap pods can be rendered for a specific node from the backup. The archive starts with a
+// manifest that lists the checksums of all other files.
+//
+// With a snapshot, the objects are read at the revision of the snapshot, so that they match it even
+// if the control plane changed since the snapshot was taken. This requires an etcd backend.
+func Backup(ctx context.Context, backend Backend, scope Scope, snapshot io.Reader, w io.Writer) (*BackupManifest, error) {
+	rr, ok := backend.(revisionReader)
+	if snapshot != nil && !ok {
+		return nil, errors.New("an etcd snapshot can only be backed up with the objects of an etcd backend")
+	}
+	scope.Nodes = true
+	manifest := &BackupManifest{
+		Version:    version.Version,
+		Created:    time.Now().UTC(),
+		Namespaces: scope.namespaces(),
+		Resources:  scope.resources(),
+	}
+
+	// The snapshot may be large, so spool it to disk to compute its checksum and revision before the
+	// manifest is written.
+	var snapshotFile *os.File
+	var snapshotFileInfo BackupFile
+	if snapshot != nil {
+		var err error
+		if snapshotFile, err = ioutil.TempFile("", "bootkube-snapshot"); err != nil {
+			return nil, err
+		}
+		defer os.Remove(snapshotFile.Name())
+		defer snapshotFile.Close()
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(snapshotFile, h), snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read etcd snapshot: %v", err)
+		}
+		if _, err := snapshotFile.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		snapshotFileInfo = BackupFile{Name: BackupSnapshotName, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+		status, err := etcdsnapshot.NewV3(nil).Status(snapshotFile.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read etcd snapshot: %v", err)
+		}
+		manifest.SnapshotRevision = status.Revision
+	}
+
+	var cp *controlPlane
+	var err error
+	if snapshot != nil {
+		cp, err = rr.readAt(ctx, scope, manifest.SnapshotRevision)
+	} else {
+		cp, err = backend.read(ctx, scope)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var objects [][]byte
+	for _, r := range manifest.Resources {
+		var obj interface{} = cp.typedList(r)
+		if obj == nil {
+			var items []map[string]interface{}
+			for _, u := range cp.objects[r.GroupVersionResource()] {
+				items = append(items, u.Object)
+			}
+			obj = items
+		}
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, BackupFile{Name: backupFileName(r), Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+		objects = append(objects, data)
+	}
+
+	if snapshotFile != nil {
+		manifest.Files = append(manifest.Files, snapshotFileInfo)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	writeFile := func(name string, size int64, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    size,
+			ModTime: manifest.Created,
+		}); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	}
+	if err := writeFile(BackupManifestName, int64(len(manifestData)), bytes.NewReader(manifestData)); err != nil {
+		return nil, err
+	}
+	for i, data := range objects {
+		if err := writeFile(manifest.Files[i].Name, int64(len(data)), bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	if snapshotFile != nil {
+		f := manifest.Files[len(manifest.Files)-1]
+		if err := writeFile(f.Name, f.Size, snapshotFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// backupBackend is a backend that extracts a controlPlane from a backup archive.
+type backupBackend struct {
+	manifest *BackupManifest
+	cp       *controlPlane
+}
+
+// NewBackupBackend constructs a new backend that reads the control plane from a backup archive
+// written by Backup. The checksums of all files in the archive are verified against the manifest.
+func NewBackupBackend(r io.Reader) (Backend, error) {
+	manifest, cp, err := readBackup(r)
+	if err != nil {
+		return nil, err
+	}
+	return &backupBackend{manifest: manifest, cp: cp}, nil
+}
+
+// read implements Backend.read(). It returns the objects of the backup in scope, and an error if
+// the backup does not cover the scope.
+func (b *backupBackend) read(_ context.Context, scope Scope) (*controlPlane, error) {
+	namespaces := make(map[string]bool)
+	for _, ns := range b.manifest.Namespaces {
+		namespaces[ns] = true
+	}
+	for _, ns := range scope.namespaces() {
+		if !namespaces[ns] {
+			return nil, fmt.Errorf("backup does not contain namespace %s", ns)
+		}
+	}
+	resources := make(map[schema.GroupVersionResource]bool)
+	for _, r := range b.manifest.Resources {
+		resources[r.GroupVersionResource()] = true
+	}
+	for _, r := range scope.resources() {
+		if !resources[r.GroupVersionResource()] {
+			return nil, fmt.Errorf("backup does not contain %s", r.GroupVersionResource().GroupResource())
+		}
+	}
+
+	// Cluster-scoped objects are always in scope.
+	inScope := map[string]bool{"": true}
+	for _, ns := range scope.namespaces() {
+		inScope[ns] = true
+	}
+	cp := &controlPlane{}
+	for _, r := range scope.resources() {
+		if list := b.cp.typedList(r); list != nil {
+			items, err := meta.ExtractList(list)
+			if err != nil {
+				return nil, err
+			}
+			var filtered []runtime.Object
+			for _, item := range items {
+				if obj, err := meta.Accessor(item); err == nil && inScope[obj.GetNamespace()] {
+					filtered = append(filtered, item)
+				}
+			}
+			if err := meta.SetList(cp.typedList(r), filtered); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, obj := range b.cp.objects[r.GroupVersionResource()] {
+			if inScope[obj.GetNamespace()] {
+				cp.addObject(r, obj)
+			}
+		}
+	}
+	return cp, nil
+}
+
+// readBackup reads and verifies a backup archive, returning its manifest and the control plane
+// objects it contains.
+func readBackup(r io.Reader) (*BackupManifest, *controlPlane, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup: %v", err)
+	}
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup: %v", err)
+	}
+	if hdr.Name != BackupManifestName {
+		return nil, nil, fmt.Errorf("backup does not start with %s", BackupManifestName)
+	}
+	var manifest BackupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %v", BackupManifestName, err)
+	}
+	want := make(map[string]BackupFile)
+	for _, f := range manifest.Files {
+		want[f.Name] = f
+	}
+
+	cp := &controlPlane{}
+	objects := make(map[string]Resource)
+	for _, r := range manifest.Resources {
+		objects[backupFileName(r)] = r
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read backup: %v", err)
+		}
+		f, ok := want[hdr.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("backup contains %s, which is not in %s", hdr.Name, BackupManifestName)
+		}
+		delete(want, hdr.Name)
+
+		// Only the objects are kept in memory, the snapshot is just verified.
+		var buf bytes.Buffer
+		h := sha256.New()
+		dst := io.Writer(h)
+		if _, isObject := objects[hdr.Name]; isObject {
+			dst = io.MultiWriter(h, &buf)
+		}
+		n, err := io.Copy(dst, tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s from backup: %v", hdr.Name, err)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); n != f.Size || sum != f.SHA256 {
+			return nil, nil, fmt.Errorf("checksum mismatch for %s: got %d bytes with sha256 %s, want %d bytes with sha256 %s", hdr.Name, n, sum, f.Size, f.SHA256)
+		}
+		if r, isObject := objects[hdr.Name]; isObject {
+			if err := cp.unmarshalObjects(r, buf.Bytes()); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse %s: %v", hdr.Name, err)
+			}
+		}
+	}
+	for name := range want {
+		return nil, nil, fmt.Errorf("backup is missing %s", name)
+	}
+	return &manifest, cp, nil
+}
+
+// unmarshalObjects adds the objects of r, as written by Backup, to cp.
+func (cp *controlPlane) unmarshalObjects(r Resource, data []byte) error {
+	if list := cp.typedList(r); list != nil {
+		return json.Unmarshal(data, list)
+	}
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		cp.addObject(r, unstructured.Unstructured{Object: item})
+	}
+	return nil
+}
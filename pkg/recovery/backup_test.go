@@ -0,0 +1,218 @@
+package recovery
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// etcdSnapshot returns a snapshot of the etcd server of client.
+func etcdSnapshot(t *testing.T, client *clientv3.Client) []byte {
+	r, err := client.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	client, stop := startEtcd(t)
+	defer stop()
+	var buf bytes.Buffer
+	manifest, err := Backup(context.Background(), fakeBackend{cp}, Scope{}, bytes.NewReader(etcdSnapshot(t, client)), &buf)
+	if err != nil {
+		t.Fatalf("Backup() = %v, want: nil", err)
+	}
+	var names []string
+	for _, f := range manifest.Files {
+		names = append(names, f.Name)
+	}
+	wantNames := []string{
+		"objects/v1/configmaps.json",
+		"objects/apps/v1/daemonsets.json",
+		"objects/apps/v1/deployments.json",
+		"objects/v1/secrets.json",
+		"objects/v1/nodes.json",
+		"etcd/snapshot.db",
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("Backup() files = %v, want: %v", names, wantNames)
+	}
+
+	gotManifest, got, err := readBackup(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("readBackup() = %v, want: nil", err)
+	}
+	if !reflect.DeepEqual(gotManifest.Files, manifest.Files) {
+		t.Errorf("readBackup() manifest = %v, want: %v", gotManifest.Files, manifest.Files)
+	}
+	if !reflect.DeepEqual(got.daemonSets, cp.daemonSets) || !reflect.DeepEqual(got.secrets, cp.secrets) {
+		t.Errorf("readBackup() control plane does not match the backed up one")
+	}
+}
+
+func TestReadBackupCorrupted(t *testing.T) {
+	client, stop := startEtcd(t)
+	defer stop()
+	var buf bytes.Buffer
+	if _, err := Backup(context.Background(), fakeBackend{cp}, Scope{}, bytes.NewReader(etcdSnapshot(t, client)), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite the archive with a modified snapshot.
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == BackupSnapshotName {
+			data[0] ^= 0xff
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gw.Close()
+
+	if _, err := NewBackupBackend(&out); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("NewBackupBackend() of a corrupted backup = %v, want: checksum mismatch", err)
+	}
+}
+
+func TestBackupSnapshotRevision(t *testing.T) {
+	client, stop := startEtcd(t)
+	defer stop()
+	ctx := context.Background()
+
+	put := func(value string) {
+		data, err := json.Marshal(&v1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "kube-system"},
+			Data:       map[string][]byte{"key": []byte(value)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Put(ctx, "/registry/secrets/kube-system/kube-apiserver", string(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("old")
+	snapshot := etcdSnapshot(t, client)
+	resp, err := client.Get(ctx, "/registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotRev := resp.Header.Revision
+	// Changes after the snapshot must not be backed up.
+	put("new")
+
+	var buf bytes.Buffer
+	manifest, err := Backup(ctx, NewEtcdBackend(client, "/registry"), Scope{}, bytes.NewReader(snapshot), &buf)
+	if err != nil {
+		t.Fatalf("Backup() = %v, want: nil", err)
+	}
+	if manifest.SnapshotRevision != snapshotRev {
+		t.Errorf("Backup() snapshot revision = %d, want: %d", manifest.SnapshotRevision, snapshotRev)
+	}
+	_, got, err := readBackup(&buf)
+	if err != nil {
+		t.Fatalf("readBackup() = %v, want: nil", err)
+	}
+	if len(got.secrets.Items) != 1 || string(got.secrets.Items[0].Data["key"]) != "old" {
+		t.Errorf("Backup() secrets = %v, want: the secret at the revision of the snapshot", got.secrets.Items)
+	}
+
+	// Other backends cannot read at the revision of the snapshot.
+	if _, err := Backup(ctx, backupOnlyBackend{cp}, Scope{}, bytes.NewReader(snapshot), ioutil.Discard); err == nil {
+		t.Errorf("Backup() of a snapshot with a backend that does not read from etcd = nil, want: non-nil")
+	}
+}
+
+// backupOnlyBackend is a Backend that cannot read past revisions.
+type backupOnlyBackend struct {
+	cp *controlPlane
+}
+
+// read implements Backend.read().
+func (b backupOnlyBackend) read(context.Context, Scope) (*controlPlane, error) {
+	return b.cp, nil
+}
+
+func TestBackupScope(t *testing.T) {
+	widgets := Resource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	backedUp := &controlPlane{secrets: v1.SecretList{Items: []v1.Secret{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "kube-system"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "control-plane"}},
+	}}}
+	backedUp.addObject(widgets, unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "widget", "namespace": "control-plane"},
+	}})
+	var buf bytes.Buffer
+	scope := Scope{Namespaces: []string{"kube-system", "control-plane"}, Resources: []Resource{widgets}}
+	if _, err := Backup(context.Background(), fakeBackend{backedUp}, scope, nil, &buf); err != nil {
+		t.Fatalf("Backup() = %v, want: nil", err)
+	}
+	backend, err := NewBackupBackend(&buf)
+	if err != nil {
+		t.Fatalf("NewBackupBackend() = %v, want: nil", err)
+	}
+
+	got, err := backend.read(context.Background(), Scope{Namespaces: []string{"control-plane"}, Resources: []Resource{widgets}})
+	if err != nil {
+		t.Fatalf("read() = %v, want: nil", err)
+	}
+	if len(got.secrets.Items) != 1 || got.secrets.Items[0].Name != "b" {
+		t.Errorf("read() secrets = %v, want: [b]", got.secrets.Items)
+	}
+	if w := got.objects[widgets.GroupVersionResource()]; len(w) != 1 || w[0].GetName() != "widget" {
+		t.Errorf("read() widgets = %v, want: [widget]", w)
+	}
+
+	for _, scope := range []Scope{
+		{Namespaces: []string{"other"}},
+		{Resources: []Resource{{Group: "apps", Version: "v1", Resource: "statefulsets"}}},
+	} {
+		if _, err := backend.read(context.Background(), scope); err == nil {
+			t.Errorf("read(%v) of a scope not in the backup = nil, want: non-nil", scope)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package recovery
+
+import (
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Component describes a self-hosted workload that is extracted as a bootstrap pod during recovery.
+type Component struct {
+	// Name identifies the component. Unless Selector is set, workloads are matched by comparing
+	// Name with their "k8s-app" (or legacy "component") label.
+	Name string `json:"name"`
+	// Selector is an optional label selector, e.g. "tier=node,k8s-app=my-proxy", that is used
+	// instead of Name to match workloads with non-default labels.
+	Selector string `json:"selector,omitempty"`
+	// KubeConfigContainers contains the names of the bootstrap containers that need a --kubeconfig
+	// flag to run in non-self-hosted mode.
+	KubeConfigContainers []string `json:"kubeConfigContainers,omitempty"`
+}
+
+// componentsFile is the on-disk format of a components configuration.
+type componentsFile struct {
+	Components []Component `json:"components"`
+}
+
+// DefaultComponents contains the components that are recovered when none are configured. They
+// match the workloads that are output by `bootkube render`.
+var DefaultComponents = []Component{{
+	Name: apiServerContainerName,
+}, {
+	Name:                 "kube-controller-manager",
+	KubeConfigContainers: []string{"kube-controller-manager"},
+}, {
+	Name:                 "kube-scheduler",
+	KubeConfigContainers: []string{"kube-scheduler"},
+}}
+
+// ParseComponents parses a YAML or JSON components configuration, for example:
+//
+//	components:
+//	- name: kube-apiserver
+//	- name: kube-proxy
+//	  selector: tier=node,app=my-proxy
+//	  kubeConfigContainers: [kube-proxy]
+func ParseComponents(data []byte) ([]Component, error) {
+	var f componentsFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse components: %v", err)
+	}
+	if len(f.Components) == 0 {
+		return nil, fmt.Errorf("no components configured")
+	}
+	for _, c := range f.Components {
+		if c.Name == "" {
+			return nil, fmt.Errorf("component is missing a name: %+v", c)
+		}
+		if _, err := labels.Parse(c.Selector); err != nil {
+			return nil, fmt.Errorf("invalid selector for component %s: %v", c.Name, err)
+		}
+	}
+	return f.Components, nil
+}
+
+// matches returns true if a workload with the given labels belongs to this component.
+func (c Component) matches(workloadLabels map[string]string) (bool, error) {
+	if c.Selector != "" {
+		selector, err := labels.Parse(c.Selector)
+		if err != nil {
+			return false, fmt.Errorf("invalid selector for component %s: %v", c.Name, err)
+		}
+		return selector.Matches(labels.Set(workloadLabels)), nil
+	}
+	k8sApp := workloadLabels[k8sAppLabel]
+	if k8sApp == "" {
+		k8sApp = workloadLabels[componentAppLabel]
+	}
+	return k8sApp == c.Name, nil
+}
+
+// kubeConfigContainers returns the set of container names across all components that need a
+// --kubeconfig flag.
+func kubeConfigContainers(components []Component) map[string]struct{} {
+	containers := make(map[string]struct{})
+	for _, c := range components {
+		for _, name := range c.KubeConfigContainers {
+			containers[name] = struct{}{}
+		}
+	}
+	return containers
+}
@@ -0,0 +1,75 @@
+package recovery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseComponents(t *testing.T) {
+	data := []byte(`
+components:
+- name: kube-apiserver
+- name: my-admission-server
+- name: kube-proxy
+  selector: tier=node,app in (my-proxy)
+  kubeConfigContainers: [kube-proxy]
+`)
+	want := []Component{{
+		Name: "kube-apiserver",
+	}, {
+		Name: "my-admission-server",
+	}, {
+		Name:                 "kube-proxy",
+		Selector:             "tier=node,app in (my-proxy)",
+		KubeConfigContainers: []string{"kube-proxy"},
+	}}
+	if got, err := ParseComponents(data); err != nil {
+		t.Errorf("ParseComponents(%s) = %v, want: nil", data, err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseComponents(%s) = %v, want: %v", data, got, want)
+	}
+}
+
+func TestParseComponentsInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte(`components: []`),
+		[]byte(`components: [{selector: "app=foo"}]`),
+		[]byte(`components: [{name: foo, selector: "app in"}]`),
+	} {
+		if got, err := ParseComponents(data); err == nil {
+			t.Errorf("ParseComponents(%s) = %v, nil, want: non-nil", data, got)
+		}
+	}
+}
+
+func TestComponentMatches(t *testing.T) {
+	proxy := Component{Name: "kube-proxy", Selector: "tier=node,app=my-proxy"}
+	for _, tc := range []struct {
+		labels map[string]string
+		want   bool
+	}{{
+		labels: map[string]string{"tier": "node", "app": "my-proxy"},
+		want:   true,
+	}, {
+		labels: map[string]string{"tier": "node", "app": "other-proxy"},
+		want:   false,
+	}, {
+		// The selector replaces matching on the k8s-app label.
+		labels: map[string]string{k8sAppLabel: "kube-proxy"},
+		want:   false,
+	}} {
+		if got, err := proxy.matches(tc.labels); err != nil || got != tc.want {
+			t.Errorf("matches(%v) = %t, %v, want: %t, nil", tc.labels, got, err, tc.want)
+		}
+	}
+}
+
+func TestKubeConfigContainers(t *testing.T) {
+	want := map[string]struct{}{
+		"kube-controller-manager": {},
+		"kube-scheduler":          {},
+	}
+	if got := kubeConfigContainers(DefaultComponents); !reflect.DeepEqual(got, want) {
+		t.Errorf("kubeConfigContainers(%v) = %v, want: %v", DefaultComponents, got, want)
+	}
+}
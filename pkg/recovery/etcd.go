@@ -12,6 +12,7 @@ import (
 
 	"go.etcd.io/etcd/clientv3"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 
@@ -25,12 +26,17 @@ func identityTransformer(value []byte) ([]byte, error) {
 	return value, nil
 }
 
+// etcdPageSize is the number of keys that are read from etcd per request.
+const etcdPageSize = 500
+
 // etcdBackend is a backend that extracts a controlPlane from an etcd instance.
 type etcdBackend struct {
-	client     *clientv3.Client
-	decoder    runtime.Decoder
-	pathPrefix string
-	tranformer TransformerFromStorage
+	client       *clientv3.Client
+	decoder      runtime.Decoder
+	deserializer runtime.Decoder
+	pathPrefix   string
+	tranformer   TransformerFromStorage
+	pageSize     int64
 }
 
 // NewEtcdBackend constructs a new etcdBackend for the given client and pathPrefix.
@@ -41,40 +47,74 @@ func NewEtcdBackend(client *clientv3.Client, pathPrefix string) Backend {
 // NewEtcdBackendWithTransformer constructs a new etcdBackend for the given client, pathPrefix and transformer.
 func NewEtcdBackendWithTransformer(client *clientv3.Client, pathPrefix string, transformer TransformerFromStorage) Backend {
 	return &etcdBackend{
-		client:     client,
-		decoder:    scheme.Codecs.UniversalDecoder(),
-		pathPrefix: pathPrefix,
-		tranformer: transformer,
+		client:       client,
+		decoder:      scheme.Codecs.UniversalDecoder(),
+		deserializer: scheme.Codecs.UniversalDeserializer(),
+		pathPrefix:   pathPrefix,
+		tranformer:   transformer,
+		pageSize:     etcdPageSize,
 	}
 }
 
-// read implements Backend.read().
-func (s *etcdBackend) read(ctx context.Context) (*controlPlane, error) {
+// read implements Backend.read(). All keys are read at the revision of the first request, so the
+// control plane is consistent even if it changes while it is being read.
+func (s *etcdBackend) read(ctx context.Context, scope Scope) (*controlPlane, error) {
+	return s.readAt(ctx, scope, 0)
+}
+
+// readAt implements revisionReader.readAt(). If rev is 0, all keys are read at the revision of the
+// first request.
+func (s *etcdBackend) readAt(ctx context.Context, scope Scope, rev int64) (*controlPlane, error) {
 	cp := &controlPlane{}
-	for _, r := range []struct {
-		etcdKeyName string
-		obj         runtime.Object
-	}{{
-		etcdKeyName: "configmaps",
-		obj:         &cp.configMaps,
-	}, {
-		etcdKeyName: "daemonsets",
-		obj:         &cp.daemonSets,
-	}, {
-		etcdKeyName: "deployments",
-		obj:         &cp.deployments,
-	}, {
-		etcdKeyName: "secrets",
-		obj:         &cp.secrets,
-	}} {
-		if err := s.list(ctx, r.etcdKeyName, r.obj); err != nil {
-			return nil, err
+	for _, r := range scope.resources() {
+		for _, ns := range scope.namespacesOf(r) {
+			elems, err := s.list(ctx, path.Join(r.etcdKey(), ns), &rev)
+			if err != nil {
+				return nil, err
+			}
+			if list := cp.typedList(r); list != nil {
+				listPtr, err := meta.GetItemsPtr(list)
+				if err != nil {
+					return nil, err
+				}
+				if err := decodeList(elems, listPtr, s.decoder); err != nil {
+					return nil, fmt.Errorf("failed to decode %s: %v", describeResource(r, ns), err)
+				}
+				continue
+			}
+			for _, elem := range elems {
+				obj, err := s.decodeUnstructured(elem)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode %s: %v", describeResource(r, ns), err)
+				}
+				cp.addObject(r, *obj)
+			}
 		}
 	}
 
 	return cp, nil
 }
 
+// decodeUnstructured decodes an object of an additional resource. Objects of types that
+// scheme.Codecs does not know, such as custom resources, are stored as JSON and decoded as such.
+func (s *etcdBackend) decodeUnstructured(value []byte) (*unstructured.Unstructured, error) {
+	obj, gvk, err := s.deserializer.Decode(value, nil, nil)
+	if err != nil {
+		u := &unstructured.Unstructured{}
+		if jsonErr := u.UnmarshalJSON(value); jsonErr != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: m}
+	u.SetGroupVersionKind(*gvk)
+	return u, nil
+}
+
 // get fetches a single runtime.Object with key `key` from etcd.
 func (s *etcdBackend) get(ctx context.Context, key string, out runtime.Object, ignoreNotFound bool) error {
 	key = path.Join(s.pathPrefix, key, "kube-system")
@@ -121,30 +161,41 @@ func (s *etcdBackend) getBytes(ctx context.Context, key string) ([]byte, error)
 	return value, nil
 }
 
-// list fetches a list runtime.Object from etcd located at key prefix `key`.
-func (s *etcdBackend) list(ctx context.Context, key string, listObj runtime.Object) error {
-	listPtr, err := meta.GetItemsPtr(listObj)
-	if err != nil {
-		return err
-	}
-	key = path.Join(s.pathPrefix, key, "kube-system")
+// list fetches the values of all keys with prefix `key` from etcd, s.pageSize keys at a time. If
+// *rev is zero it is set to the revision of the first response, and all pages are read at *rev.
+func (s *etcdBackend) list(ctx context.Context, key string, rev *int64) ([][]byte, error) {
+	key = path.Join(s.pathPrefix, key)
 	if !strings.HasSuffix(key, "/") {
 		key += "/"
 	}
-	getResp, err := s.client.KV.Get(ctx, key, clientv3.WithPrefix())
-	if err != nil {
-		return err
-	}
+	end := clientv3.GetPrefixRangeEnd(key)
 
-	elems := make([][]byte, len(getResp.Kvs))
-	for i, kv := range getResp.Kvs {
-		elems[i], err = s.tranformer(kv.Value)
+	var elems [][]byte
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(s.pageSize)}
+		if *rev != 0 {
+			opts = append(opts, clientv3.WithRev(*rev))
+		}
+		getResp, err := s.client.KV.Get(ctx, key, opts...)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if *rev == 0 {
+			*rev = getResp.Header.Revision
 		}
+		for _, kv := range getResp.Kvs {
+			elem, err := s.tranformer(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		if !getResp.More || len(getResp.Kvs) == 0 {
+			return elems, nil
+		}
+		// Continue after the last key of this page.
+		key = string(getResp.Kvs[len(getResp.Kvs)-1].Key) + "\x00"
 	}
-
-	return decodeList(elems, listPtr, s.decoder)
 }
 
 const (
@@ -0,0 +1,161 @@
+package recovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// freeURL returns a URL on localhost with a port that is not in use.
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// startEtcd starts an etcd server on localhost and returns a client for it.
+func startEtcd(t *testing.T) (*clientv3.Client, func()) {
+	dir, err := ioutil.TempDir("", "recovery-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = filepath.Join(dir, "data")
+	cfg.Logger = "zap"
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	<-e.Server.ReadyNotify()
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}})
+	if err != nil {
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return client, func() {
+		client.Close()
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEtcdBackendRead(t *testing.T) {
+	client, stop := startEtcd(t)
+	defer stop()
+	ctx := context.Background()
+
+	put := func(key string, obj interface{}) {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Put(ctx, key, string(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ns := range []string{"kube-system", "control-plane", "other"} {
+		for i := 0; i < 3; i++ {
+			name := fmt.Sprintf("config-%d", i)
+			put(fmt.Sprintf("/registry/configmaps/%s/%s", ns, name), &v1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			})
+		}
+	}
+	// Custom resources are stored as JSON, and are not known to the scheme.
+	put("/registry/example.com/widgets/control-plane/widget", map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "widget", "namespace": "control-plane"},
+	})
+	// Pods are known to the scheme, and are stored in a custom location.
+	put("/registry/custom-pods/control-plane/pod", &v1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "control-plane"},
+	})
+
+	backend := NewEtcdBackend(client, "/registry").(*etcdBackend)
+	backend.pageSize = 2
+	widgets := Resource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	pods := Resource{Version: "v1", Resource: "pods", EtcdKey: "custom-pods"}
+	got, err := backend.read(ctx, Scope{
+		Namespaces: []string{"kube-system", "control-plane"},
+		Resources:  []Resource{widgets, pods},
+	})
+	if err != nil {
+		t.Fatalf("read() = %v, want: nil", err)
+	}
+
+	var gotConfigMaps []string
+	for _, c := range got.configMaps.Items {
+		gotConfigMaps = append(gotConfigMaps, c.Namespace+"/"+c.Name)
+	}
+	sort.Strings(gotConfigMaps)
+	wantConfigMaps := []string{
+		"control-plane/config-0", "control-plane/config-1", "control-plane/config-2",
+		"kube-system/config-0", "kube-system/config-1", "kube-system/config-2",
+	}
+	if !reflect.DeepEqual(gotConfigMaps, wantConfigMaps) {
+		t.Errorf("read() configMaps = %v, want: %v", gotConfigMaps, wantConfigMaps)
+	}
+	if w := got.objects[widgets.GroupVersionResource()]; len(w) != 1 || w[0].GetKind() != "Widget" || w[0].GetName() != "widget" {
+		t.Errorf("read() widgets = %v, want: [widget]", w)
+	}
+	if p := got.objects[pods.GroupVersionResource()]; len(p) != 1 || p[0].GetKind() != "Pod" || p[0].GetName() != "pod" {
+		t.Errorf("read() pods = %v, want: [pod]", p)
+	}
+}
+
+func TestEtcdBackendListConsistentRevision(t *testing.T) {
+	client, stop := startEtcd(t)
+	defer stop()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Put(ctx, fmt.Sprintf("/registry/secrets/kube-system/secret-%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := client.Get(ctx, "/registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := resp.Header.Revision
+	// Keys written after rev must not be seen by a list at rev.
+	if _, err := client.Put(ctx, "/registry/secrets/kube-system/secret-3", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewEtcdBackend(client, "/registry").(*etcdBackend)
+	backend.pageSize = 2
+	elems, err := backend.list(ctx, "secrets/kube-system", &rev)
+	if err != nil {
+		t.Fatalf("list() = %v, want: nil", err)
+	}
+	if len(elems) != 3 {
+		t.Errorf("list() at revision %d = %d values, want: 3", rev, len(elems))
+	}
+}
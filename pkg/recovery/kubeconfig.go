@@ -0,0 +1,89 @@
+package recovery
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"text/template"
+
+	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset"
+	"github.com/kubernetes-sigs/bootkube/pkg/tlsutil"
+)
+
+// caSecretName is the secret that holds the cluster CA certificate and key, as output by `bootkube
+// render`.
+const caSecretName = "kube-controller-manager"
+
+// adminKubeConfigTemplate matches the admin kubeconfig output by `bootkube render`.
+var adminKubeConfigTemplate = template.Must(template.New("kubeconfig").Parse(`apiVersion: v1
+kind: Config
+clusters:
+- name: local
+  cluster:
+    server: {{ .Server }}
+    certificate-authority-data: {{ .CACert }}
+users:
+- name: admin
+  user:
+    client-certificate-data: {{ .AdminCert }}
+    client-key-data: {{ .AdminKey }}
+contexts:
+- context:
+    cluster: local
+    user: admin
+  name: admin@local
+current-context: admin@local
+`))
+
+// renderAdminKubeConfig mints a new admin client certificate using the CA recovered from the
+// kube-controller-manager secret and returns a kubeconfig asset that uses it to talk to server.
+// This allows recovery when the original kubeconfig has been lost.
+func (cp *controlPlane) renderAdminKubeConfig(server string) (asset.Asset, error) {
+	var caCertPEM, caKeyPEM []byte
+	for _, secret := range cp.secrets.Items {
+		if secret.Name == caSecretName {
+			caCertPEM = secret.Data[path.Base(asset.AssetPathCACert)]
+			caKeyPEM = secret.Data[path.Base(asset.AssetPathCAKey)]
+		}
+	}
+	if caCertPEM == nil || caKeyPEM == nil {
+		return asset.Asset{}, fmt.Errorf("secret %s does not contain the CA certificate and key, a kubeconfig is required", caSecretName)
+	}
+	caCert, err := tlsutil.ParsePEMEncodedCACert(caCertPEM)
+	if err != nil {
+		return asset.Asset{}, fmt.Errorf("failed to parse recovered CA certificate: %v", err)
+	}
+	caKey, err := tlsutil.ParsePEMEncodedPrivateKey(caKeyPEM)
+	if err != nil {
+		return asset.Asset{}, fmt.Errorf("failed to parse recovered CA key: %v", err)
+	}
+
+	key, err := tlsutil.NewPrivateKey()
+	if err != nil {
+		return asset.Asset{}, err
+	}
+	cert, err := tlsutil.NewSignedCertificate(tlsutil.CertConfig{
+		CommonName:   "admin",
+		Organization: []string{"system:masters"},
+	}, key, caCert, caKey)
+	if err != nil {
+		return asset.Asset{}, fmt.Errorf("failed to create admin certificate: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := adminKubeConfigTemplate.Execute(&buf, struct {
+		Server, CACert, AdminCert, AdminKey string
+	}{
+		Server:    server,
+		CACert:    base64.StdEncoding.EncodeToString(caCertPEM),
+		AdminCert: base64.StdEncoding.EncodeToString(tlsutil.EncodeCertificatePEM(cert)),
+		AdminKey:  base64.StdEncoding.EncodeToString(tlsutil.EncodePrivateKeyPEM(key)),
+	}); err != nil {
+		return asset.Asset{}, err
+	}
+	return asset.Asset{
+		Name: asset.AssetPathAdminKubeConfig, // used by `bootkube start`.
+		Data: buf.Bytes(),
+	}, nil
+}
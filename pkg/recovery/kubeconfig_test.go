@@ -0,0 +1,87 @@
+package recovery
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+
+	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset"
+	"github.com/kubernetes-sigs/bootkube/pkg/tlsutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// fakeBackend is a Backend that returns a fixed control plane.
+type fakeBackend struct {
+	cp *controlPlane
+}
+
+// read implements Backend.read().
+func (b fakeBackend) read(context.Context, Scope) (*controlPlane, error) {
+	return b.cp, nil
+}
+
+// readAt implements revisionReader.readAt().
+func (b fakeBackend) readAt(context.Context, Scope, int64) (*controlPlane, error) {
+	return b.cp, nil
+}
+
+func TestRenderAdminKubeConfig(t *testing.T) {
+	caKey, err := tlsutil.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := tlsutil.NewSelfSignedCACertificate(tlsutil.CertConfig{CommonName: "kube-ca"}, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := &controlPlane{
+		secrets: v1.SecretList{Items: []v1.Secret{{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-controller-manager", Namespace: "kube-system"},
+			Data: map[string][]byte{
+				"ca.crt": tlsutil.EncodeCertificatePEM(caCert),
+				"ca.key": tlsutil.EncodePrivateKeyPEM(caKey),
+			},
+		}}},
+	}
+
+	plan, err := NewPlan(context.Background(), fakeBackend{cp}, Options{Server: "https://10.0.0.1:6443"})
+	if err != nil {
+		t.Fatalf("NewPlan() = %v, want: nil", err)
+	}
+	a, err := plan.Assets.Get(asset.AssetPathAdminKubeConfig)
+	if err != nil {
+		t.Fatalf("NewPlan() did not render a kubeconfig: %v", err)
+	}
+	kubeConfig, err := clientcmd.Load(a.Data)
+	if err != nil {
+		t.Fatalf("clientcmd.Load() = %v, want: nil", err)
+	}
+	cluster := kubeConfig.Clusters[kubeConfig.Contexts[kubeConfig.CurrentContext].Cluster]
+	if cluster.Server != "https://10.0.0.1:6443" {
+		t.Errorf("kubeconfig server = %s, want: https://10.0.0.1:6443", cluster.Server)
+	}
+	authInfo := kubeConfig.AuthInfos[kubeConfig.Contexts[kubeConfig.CurrentContext].AuthInfo]
+	cert, err := tlsutil.ParsePEMEncodedCACert(authInfo.ClientCertificateData)
+	if err != nil {
+		t.Fatalf("failed to parse client certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("client certificate does not chain to the recovered CA: %v", err)
+	}
+	if len(cert.Subject.Organization) != 1 || cert.Subject.Organization[0] != "system:masters" {
+		t.Errorf("client certificate organization = %v, want: [system:masters]", cert.Subject.Organization)
+	}
+}
+
+func TestRenderAdminKubeConfigMissingCA(t *testing.T) {
+	if _, err := NewPlan(context.Background(), fakeBackend{&controlPlane{}}, Options{Server: "https://10.0.0.1:6443"}); err == nil {
+		t.Errorf("NewPlan() without a recovered CA = nil, want: non-nil")
+	}
+	if _, err := NewPlan(context.Background(), fakeBackend{&controlPlane{}}, Options{}); err == nil {
+		t.Errorf("NewPlan() without kubeconfig or server = nil, want: non-nil")
+	}
+}
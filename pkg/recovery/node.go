@@ -0,0 +1,137 @@
+package recovery
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// nodeFieldPaths are the downward API fields of a bootstrap pod that depend on the node it runs
+// on. Bootstrap pods use the host network, so the pod IP is the node IP.
+var nodeFieldPaths = map[string]func(node *v1.Node, ip string) string{
+	"spec.nodeName": func(node *v1.Node, _ string) string { return node.Name },
+	"status.hostIP": func(_ *v1.Node, ip string) string { return ip },
+	"status.podIP":  func(_ *v1.Node, ip string) string { return ip },
+	"status.podIPs": func(_ *v1.Node, ip string) string { return ip },
+}
+
+// findNode returns the recovered node with the given name or address, and the IP that bootstrap
+// pods on the node use.
+func (cp *controlPlane) findNode(nameOrAddress string) (*v1.Node, string, error) {
+	if len(cp.nodes.Items) == 0 {
+		return nil, "", fmt.Errorf("failed to find node %s: no nodes were recovered", nameOrAddress)
+	}
+	var names []string
+	for i := range cp.nodes.Items {
+		node := &cp.nodes.Items[i]
+		if node.Name == nameOrAddress {
+			ip, err := nodeIP(node)
+			return node, ip, err
+		}
+		for _, addr := range node.Status.Addresses {
+			if addr.Address != nameOrAddress {
+				continue
+			}
+			if net.ParseIP(addr.Address) != nil {
+				return node, addr.Address, nil
+			}
+			ip, err := nodeIP(node)
+			return node, ip, err
+		}
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+	return nil, "", fmt.Errorf("node %s not found, recovered nodes are: %s", nameOrAddress, strings.Join(names, ", "))
+}
+
+// nodeIP returns the internal IP of a node, or its external IP if it has no internal one.
+func nodeIP(node *v1.Node) (string, error) {
+	for _, t := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP} {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == t {
+				return addr.Address, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("node %s has no IP address", node.Name)
+}
+
+// masterNodes returns the recovered nodes that match the node selectors of all bootstrap pods, in
+// the order of their names.
+func (cp *controlPlane) masterNodes(pods []v1.Pod) ([]*v1.Node, error) {
+	var selectors []labels.Selector
+	for _, pod := range pods {
+		if len(pod.Spec.NodeSelector) > 0 {
+			selectors = append(selectors, labels.SelectorFromSet(pod.Spec.NodeSelector))
+		}
+	}
+	if len(selectors) == 0 {
+		return nil, fmt.Errorf("failed to find master nodes: no bootstrap pod has a node selector")
+	}
+	var nodes []*v1.Node
+	for i := range cp.nodes.Items {
+		node := &cp.nodes.Items[i]
+		matches := true
+		for _, s := range selectors {
+			matches = matches && s.Matches(labels.Set(node.Labels))
+		}
+		if matches {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("failed to find master nodes: no recovered node matches the node selectors of the bootstrap pods")
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, nil
+}
+
+// substituteNode replaces environment variables of bootstrap pods that are set from node-dependent
+// downward API fields with their values for node, and expands references to them in the command
+// lines, so that flags such as --advertise-address=$(POD_IP) are explicit in the manifests.
+func substituteNode(pods []v1.Pod, node *v1.Node, ip string) {
+	for i := range pods {
+		pod := &pods[i]
+		for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+			for j := range containers {
+				c := &containers[j]
+				values := make(map[string]string)
+				for k := range c.Env {
+					env := &c.Env[k]
+					if env.ValueFrom == nil || env.ValueFrom.FieldRef == nil {
+						continue
+					}
+					value, ok := nodeFieldPaths[env.ValueFrom.FieldRef.FieldPath]
+					if !ok {
+						continue
+					}
+					env.Value = value(node, ip)
+					env.ValueFrom = nil
+					values[env.Name] = env.Value
+				}
+				c.Command = expandVars(c.Command, values)
+				c.Args = expandVars(c.Args, values)
+			}
+		}
+	}
+}
+
+// expandVars replaces $(NAME) references to the given variables in args.
+func expandVars(args []string, values map[string]string) []string {
+	if len(values) == 0 {
+		return args
+	}
+	var oldnew []string
+	for name, value := range values {
+		oldnew = append(oldnew, "$("+name+")", value)
+	}
+	r := strings.NewReplacer(oldnew...)
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+	return args
+}
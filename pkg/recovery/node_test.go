@@ -0,0 +1,137 @@
+package recovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	v1apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const masterLabel = "node-role.kubernetes.io/master"
+
+func newNode(name string, labels map[string]string, addresses ...v1.NodeAddress) v1.Node {
+	return v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status:     v1.NodeStatus{Addresses: addresses},
+	}
+}
+
+// newMultiMasterControlPlane returns a control plane with an apiserver DaemonSet on two masters.
+func newMultiMasterControlPlane() *controlPlane {
+	fieldEnv := func(name, fieldPath string) v1.EnvVar {
+		return v1.EnvVar{Name: name, ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: fieldPath}}}
+	}
+	return &controlPlane{
+		daemonSets: v1apps.DaemonSetList{Items: []v1apps.DaemonSet{{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kube-apiserver",
+				Namespace: "kube-system",
+				Labels:    map[string]string{"k8s-app": "kube-apiserver"},
+			},
+			Spec: v1apps.DaemonSetSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{
+				NodeSelector: map[string]string{masterLabel: ""},
+				Containers: []v1.Container{{
+					Name:    "kube-apiserver",
+					Image:   "k8s.gcr.io/hyperkube:v1.14.0",
+					Command: []string{"kube-apiserver", "--advertise-address=$(POD_IP)", "--bind-address=$(POD_IP)", "--v=$(LOG_LEVEL)"},
+					Env: []v1.EnvVar{
+						fieldEnv("POD_IP", "status.podIP"),
+						fieldEnv("NODE_NAME", "spec.nodeName"),
+						fieldEnv("POD_NAME", "metadata.name"),
+						{Name: "LOG_LEVEL", Value: "2"},
+					},
+				}},
+			}}},
+		}}},
+		nodes: v1.NodeList{Items: []v1.Node{
+			newNode("worker", nil, v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.3"}),
+			newNode("master-2", map[string]string{masterLabel: ""},
+				v1.NodeAddress{Type: v1.NodeExternalIP, Address: "192.168.0.2"},
+				v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.2"}),
+			newNode("master-1", map[string]string{masterLabel: ""},
+				v1.NodeAddress{Type: v1.NodeHostName, Address: "master-1.example.com"},
+				v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"}),
+		}},
+	}
+}
+
+func writeTestKubeConfig(t *testing.T) string {
+	f, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("apiVersion: v1\nkind: Config\n"); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestNewPlanNode(t *testing.T) {
+	kubeConfigPath := writeTestKubeConfig(t)
+	defer os.Remove(kubeConfigPath)
+
+	for _, tc := range []struct {
+		node, wantName, wantIP string
+	}{
+		{"master-2", "master-2", "10.0.0.2"},
+		{"192.168.0.2", "master-2", "192.168.0.2"},
+		{"master-1.example.com", "master-1", "10.0.0.1"},
+	} {
+		plan, err := NewPlan(context.Background(), fakeBackend{newMultiMasterControlPlane()}, Options{KubeConfigPath: kubeConfigPath, Node: tc.node})
+		if err != nil {
+			t.Errorf("NewPlan(%s) = %v, want: nil", tc.node, err)
+			continue
+		}
+		if plan.Node != tc.wantName || plan.NodeIP != tc.wantIP {
+			t.Errorf("NewPlan(%s) node = %s (%s), want: %s (%s)", tc.node, plan.Node, plan.NodeIP, tc.wantName, tc.wantIP)
+		}
+		c := plan.Pods[0].Spec.Containers[0]
+		wantCommand := []string{"kube-apiserver", "--advertise-address=" + tc.wantIP, "--bind-address=" + tc.wantIP, "--v=$(LOG_LEVEL)"}
+		if !reflect.DeepEqual(c.Command, wantCommand) {
+			t.Errorf("NewPlan(%s) command = %v, want: %v", tc.node, c.Command, wantCommand)
+		}
+		wantEnv := []v1.EnvVar{
+			{Name: "POD_IP", Value: tc.wantIP},
+			{Name: "NODE_NAME", Value: tc.wantName},
+			{Name: "POD_NAME", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+			{Name: "LOG_LEVEL", Value: "2"},
+		}
+		if !reflect.DeepEqual(c.Env, wantEnv) {
+			t.Errorf("NewPlan(%s) env = %v, want: %v", tc.node, c.Env, wantEnv)
+		}
+	}
+
+	if _, err := NewPlan(context.Background(), fakeBackend{newMultiMasterControlPlane()}, Options{KubeConfigPath: kubeConfigPath, Node: "missing"}); err == nil {
+		t.Errorf("NewPlan() for a missing node = nil, want: non-nil")
+	}
+}
+
+func TestNewMasterPlans(t *testing.T) {
+	kubeConfigPath := writeTestKubeConfig(t)
+	defer os.Remove(kubeConfigPath)
+
+	plans, err := NewMasterPlans(context.Background(), fakeBackend{newMultiMasterControlPlane()}, Options{KubeConfigPath: kubeConfigPath})
+	if err != nil {
+		t.Fatalf("NewMasterPlans() = %v, want: nil", err)
+	}
+	var got []string
+	for _, p := range plans {
+		got = append(got, p.Node+" "+p.Pods[0].Spec.Containers[0].Command[1])
+	}
+	want := []string{"master-1 --advertise-address=10.0.0.1", "master-2 --advertise-address=10.0.0.2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewMasterPlans() = %v, want: %v", got, want)
+	}
+
+	cp := newMultiMasterControlPlane()
+	cp.daemonSets.Items[0].Spec.Template.Spec.NodeSelector = nil
+	if _, err := NewMasterPlans(context.Background(), fakeBackend{cp}, Options{KubeConfigPath: kubeConfigPath}); err == nil {
+		t.Errorf("NewMasterPlans() without node selectors = nil, want: non-nil")
+	}
+}
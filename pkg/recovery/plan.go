@@ -0,0 +1,246 @@
+package recovery
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Plan describes the result of a recovery before anything is written to disk.
+type Plan struct {
+	// Pods are the bootstrap pods of the recovered components.
+	Pods []v1.Pod
+	// Secrets are the names of the secrets that the bootstrap pods require. Names of secrets outside
+	// of kube-system are prefixed by their namespace.
+	Secrets []string
+	// ConfigMaps are the names of the configMaps that the bootstrap pods require, like Secrets.
+	ConfigMaps []string
+	// Assets are the files that are written to the recovery directory.
+	Assets asset.Assets
+	// Node and NodeIP are the name and IP of the node that the bootstrap pods were rendered for, if
+	// any.
+	Node   string
+	NodeIP string
+
+	projections []volumeProjection
+}
+
+// newPlan creates a Plan for the given bootstrap pods, the projections of their volumes and the
+// rendered assets.
+func newPlan(pods []v1.Pod, projections []volumeProjection, as asset.Assets) *Plan {
+	secrets, configMaps := make(map[string]bool), make(map[string]bool)
+	for _, p := range projections {
+		switch {
+		case p.source.Secret != nil:
+			secrets[objectName(p.pod.Namespace, p.source.Secret.Name)] = true
+		case p.source.ConfigMap != nil:
+			configMaps[objectName(p.pod.Namespace, p.source.ConfigMap.Name)] = true
+		}
+	}
+	return &Plan{
+		Pods:       pods,
+		Secrets:    sortedKeys(secrets),
+		ConfigMaps: sortedKeys(configMaps),
+		Assets:     as,
+
+		projections: projections,
+	}
+}
+
+// WriteSummary writes a human readable summary of the plan to w.
+func (p *Plan) WriteSummary(w io.Writer) {
+	if p.Node != "" {
+		fmt.Fprintf(w, "Node: %s (%s)\n", p.Node, p.NodeIP)
+	}
+	fmt.Fprintf(w, "Components:\n")
+	for _, pod := range p.Pods {
+		fmt.Fprintf(w, "  %s\n", pod.Name)
+		for _, c := range pod.Spec.Containers {
+			fmt.Fprintf(w, "    %s: %s\n", c.Name, c.Image)
+		}
+	}
+	fmt.Fprintf(w, "Secrets:\n")
+	for _, name := range p.Secrets {
+		fmt.Fprintf(w, "  %s\n", name)
+	}
+	fmt.Fprintf(w, "ConfigMaps:\n")
+	for _, name := range p.ConfigMaps {
+		fmt.Fprintf(w, "  %s\n", name)
+	}
+	fmt.Fprintf(w, "Files:\n")
+	for _, a := range p.Assets {
+		fmt.Fprintf(w, "  %s\n", a.Name)
+	}
+}
+
+// LoadBootstrapPods reads the bootstrap pod manifests from an asset directory, such as the output
+// of `bootkube render`.
+func LoadBootstrapPods(assetDir string) ([]v1.Pod, error) {
+	dir := filepath.Join(assetDir, asset.AssetPathBootstrapManifests)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var pods []v1.Pod
+	for _, f := range files {
+		if f.IsDir() || !(strings.HasSuffix(f.Name(), ".yaml") || strings.HasSuffix(f.Name(), ".json")) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var pod v1.Pod
+		if err := yaml.Unmarshal(data, &pod); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", f.Name(), err)
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// DiffBootstrapPods compares the images and command line flags of recovered bootstrap pods with
+// those of rendered ones, matching pods by name and containers by name. It writes the differences
+// to w and returns true if there were any.
+func DiffBootstrapPods(w io.Writer, recovered, rendered []v1.Pod) bool {
+	renderedPods := make(map[string]v1.Pod)
+	for _, pod := range rendered {
+		renderedPods[pod.Name] = pod
+	}
+	differs := false
+	for _, pod := range recovered {
+		want, ok := renderedPods[pod.Name]
+		if !ok {
+			fmt.Fprintf(w, "+ pod %s (not rendered)\n", pod.Name)
+			differs = true
+			continue
+		}
+		delete(renderedPods, pod.Name)
+
+		var lines []string
+		renderedContainers := make(map[string]v1.Container)
+		for _, c := range want.Spec.Containers {
+			renderedContainers[c.Name] = c
+		}
+		for _, c := range pod.Spec.Containers {
+			wc, ok := renderedContainers[c.Name]
+			if !ok {
+				lines = append(lines, fmt.Sprintf("  + container %s (not rendered)", c.Name))
+				continue
+			}
+			delete(renderedContainers, c.Name)
+			if cl := diffContainer(c, wc); len(cl) > 0 {
+				lines = append(lines, fmt.Sprintf("  container %s:", c.Name))
+				lines = append(lines, cl...)
+			}
+		}
+		for _, name := range sortedContainerNames(renderedContainers) {
+			lines = append(lines, fmt.Sprintf("  - container %s (not recovered)", name))
+		}
+
+		if len(lines) > 0 {
+			fmt.Fprintf(w, "pod %s:\n%s\n", pod.Name, strings.Join(lines, "\n"))
+			differs = true
+		}
+	}
+	var missing []string
+	for name := range renderedPods {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		fmt.Fprintf(w, "- pod %s (not recovered)\n", name)
+		differs = true
+	}
+	return differs
+}
+
+// diffContainer returns the differences in image and command line between a recovered and a
+// rendered container. Flags present in both with different values are reported as changed.
+func diffContainer(recovered, rendered v1.Container) []string {
+	var lines []string
+	if recovered.Image != rendered.Image {
+		lines = append(lines, fmt.Sprintf("    ~ image: %s -> %s", rendered.Image, recovered.Image))
+	}
+
+	recoveredArgs := countArgs(recovered.Command, recovered.Args)
+	renderedArgs := countArgs(rendered.Command, rendered.Args)
+	removedByName := make(map[string][]string)
+	for arg, n := range renderedArgs {
+		if recoveredArgs[arg] < n {
+			removedByName[flagName(arg)] = append(removedByName[flagName(arg)], arg)
+		}
+	}
+
+	// Collect the flag changes keyed by argument so that the output is sorted.
+	changes := make(map[string]string)
+	for arg, n := range recoveredArgs {
+		if renderedArgs[arg] >= n {
+			continue
+		}
+		if old := removedByName[flagName(arg)]; len(old) == 1 {
+			changes[arg] = fmt.Sprintf("    ~ %s -> %s", old[0], arg)
+			delete(removedByName, flagName(arg))
+			continue
+		}
+		changes[arg] = fmt.Sprintf("    + %s", arg)
+	}
+	for _, args := range removedByName {
+		for _, arg := range args {
+			changes[arg] = fmt.Sprintf("    - %s", arg)
+		}
+	}
+	var keys []string
+	for arg := range changes {
+		keys = append(keys, arg)
+	}
+	sort.Strings(keys)
+	for _, arg := range keys {
+		lines = append(lines, changes[arg])
+	}
+	return lines
+}
+
+// countArgs counts the occurrences of each command line argument.
+func countArgs(argLists ...[]string) map[string]int {
+	counts := make(map[string]int)
+	for _, args := range argLists {
+		for _, arg := range args {
+			counts[arg]++
+		}
+	}
+	return counts
+}
+
+// flagName returns the name of a "--name=value" flag, or the argument itself otherwise.
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") {
+		return arg
+	}
+	return strings.SplitN(arg, "=", 2)[0]
+}
+
+func sortedContainerNames(containers map[string]v1.Container) []string {
+	var names []string
+	for name := range containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,116 @@
+package recovery
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderBootstrapPlan(t *testing.T) {
+	plan, err := cp.renderBootstrap(DefaultComponents, nil, "")
+	if err != nil {
+		t.Fatalf("renderBootstrap() = %v, want: nil", err)
+	}
+	var gotPods []string
+	for _, pod := range plan.Pods {
+		gotPods = append(gotPods, pod.Name)
+	}
+	if want := []string{"bootstrap-kube-apiserver", "bootstrap-kube-scheduler"}; !reflect.DeepEqual(gotPods, want) {
+		t.Errorf("renderBootstrap() pods = %v, want: %v", gotPods, want)
+	}
+	if want := []string{"kube-apiserver"}; !reflect.DeepEqual(plan.Secrets, want) {
+		t.Errorf("renderBootstrap() secrets = %v, want: %v", plan.Secrets, want)
+	}
+	if len(plan.ConfigMaps) != 0 {
+		t.Errorf("renderBootstrap() configMaps = %v, want: []", plan.ConfigMaps)
+	}
+	var gotFiles []string
+	for _, a := range plan.Assets {
+		gotFiles = append(gotFiles, a.Name)
+	}
+	wantFiles := []string{
+		"bootstrap-manifests/bootstrap-kube-apiserver.yaml",
+		"bootstrap-manifests/bootstrap-kube-scheduler.yaml",
+		"tls/secrets/kube-apiserver/apiserver.crt",
+	}
+	if !reflect.DeepEqual(gotFiles, wantFiles) {
+		t.Errorf("renderBootstrap() files = %v, want: %v", gotFiles, wantFiles)
+	}
+}
+
+func TestDiffBootstrapPods(t *testing.T) {
+	rendered := []v1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-kube-apiserver"},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:    "kube-apiserver",
+			Image:   "k8s.gcr.io/hyperkube:v1.17.4",
+			Command: []string{"/hyperkube", "kube-apiserver", "--secure-port=6443", "--allow-privileged=true"},
+		}}},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-kube-controller-manager"},
+	}}
+	recovered := []v1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-kube-apiserver"},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:    "kube-apiserver",
+			Image:   "k8s.gcr.io/hyperkube:v1.18.2",
+			Command: []string{"/hyperkube", "kube-apiserver", "--secure-port=443"},
+			Args:    []string{"--v=2"},
+		}}},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-my-admission-server"},
+	}}
+	want := `pod bootstrap-kube-apiserver:
+  container kube-apiserver:
+    ~ image: k8s.gcr.io/hyperkube:v1.17.4 -> k8s.gcr.io/hyperkube:v1.18.2
+    - --allow-privileged=true
+    ~ --secure-port=6443 -> --secure-port=443
+    + --v=2
++ pod bootstrap-my-admission-server (not rendered)
+- pod bootstrap-kube-controller-manager (not recovered)
+`
+	var buf bytes.Buffer
+	if !DiffBootstrapPods(&buf, recovered, rendered) {
+		t.Errorf("DiffBootstrapPods() = false, want: true")
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("DiffBootstrapPods() output:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	if DiffBootstrapPods(&buf, rendered, rendered) || buf.Len() != 0 {
+		t.Errorf("DiffBootstrapPods() of identical pods = true, %q, want: false, \"\"", buf.String())
+	}
+}
+
+func TestLoadBootstrapPods(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recovery-plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	as, err := outputBootstrapPods([]v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-kube-apiserver"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	as = append(as, asset.Asset{Name: filepath.Join(asset.AssetPathBootstrapManifests, "README"), Data: []byte("ignored")})
+	if err := as.WriteFiles(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	pods, err := LoadBootstrapPods(dir)
+	if err != nil {
+		t.Fatalf("LoadBootstrapPods(%s) = %v, want: nil", dir, err)
+	}
+	if len(pods) != 1 || pods[0].Name != "bootstrap-kube-apiserver" {
+		t.Errorf("LoadBootstrapPods(%s) = %v, want: [bootstrap-kube-apiserver]", dir, pods)
+	}
+}
@@ -2,18 +2,20 @@
 // Backend to extract the control plane from a store, such as etcd, and use those to write assets
 // that can be used by `bootkube start` to reboot the control plane.
 //
-// The recovery tool assumes that the component names for the control plane elements are the same as
-// what is output by `bootkube render`. The `bootkube start` command also makes this assumption.
+// By default the recovery tool assumes that the component names for the control plane elements are
+// the same as what is output by `bootkube render`; see DefaultComponents. Other workloads can be
+// recovered by configuring Options.Components. The `bootkube start` command also makes this
+// assumption.
 // It also assumes that kubeconfig on the kubelet is located at /etc/kubernetes/kubeconfig, though
 // that can be changed in the bootstrap manifests that are rendered.
 package recovery
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"path"
-	"path/filepath"
 	"reflect"
 
 	"github.com/ghodss/yaml"
@@ -22,6 +24,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
@@ -34,19 +37,6 @@ const (
 )
 
 var (
-	// bootstrapK8sApps contains the components (as identified by the label "k8s-app") that we
-	// will extract to construct the temporary bootstrap control plane.
-	bootstrapK8sApps = map[string]struct{}{
-		apiServerContainerName:    {},
-		"kube-controller-manager": {},
-		"kube-scheduler":          {},
-	}
-	// kubeConfigK8sContainers contains the names of the bootstrap container specs that need to add a
-	// --kubeconfig flag to run in non-self-hosted mode.
-	kubeConfigK8sContainers = map[string]struct{}{
-		"kube-controller-manager": {},
-		"kube-scheduler":          {},
-	}
 	// typeMetas contains a mapping from API object types to the TypeMeta struct that should be
 	// populated for them when they are serialized.
 	typeMetas    = make(map[reflect.Type]metav1.TypeMeta)
@@ -70,7 +60,13 @@ func init() {
 
 // Backend defines an interface for any backend that can populate a controlPlane struct.
 type Backend interface {
-	read(context.Context) (*controlPlane, error)
+	read(context.Context, Scope) (*controlPlane, error)
+}
+
+// revisionReader is implemented by backends that can read the control plane as it was at a past
+// etcd revision, such as the revision of a snapshot.
+type revisionReader interface {
+	readAt(ctx context.Context, scope Scope, rev int64) (*controlPlane, error)
 }
 
 // controlPlane holds the control plane objects that are recovered from a backend.
@@ -79,64 +75,181 @@ type controlPlane struct {
 	daemonSets  v1apps.DaemonSetList
 	deployments v1apps.DeploymentList
 	secrets     v1.SecretList
+	nodes       v1.NodeList
+	// objects holds the objects of additional resources in the Scope.
+	objects map[schema.GroupVersionResource][]unstructured.Unstructured
+}
+
+// Options defines the parameters that are used to recover a control plane.
+type Options struct {
+	// KubeConfigPath is the path to the kubeconfig that is written to the recovered assets. If empty,
+	// a new admin kubeconfig is created using the recovered CA.
+	KubeConfigPath string
+	// Server is the apiserver URL, e.g. "https://10.0.0.1:6443", that is used in a newly created
+	// admin kubeconfig. Required if KubeConfigPath is empty.
+	Server string
+	// Scope defines the namespaces that are read from the backend, and the etcd keys of the
+	// resources needed for recovery. It must not contain additional resources.
+	Scope Scope
+	// Components are the workloads that are extracted to construct the temporary bootstrap control
+	// plane. DefaultComponents is used if empty.
+	Components []Component
+	// Node is the name or an address of the node that the bootstrap pods are rendered for. If set,
+	// environment variables from node-dependent downward API fields, such as POD_IP, are replaced
+	// by their values for the node. The node is looked up in the nodes read from the backend.
+	Node string
 }
 
-// Recover recovers a control plane using the provided backend and kubeConfigPath, returning assets
-// for the existing control plane and a bootstrap control plane that can be used with `bootkube
-// start` to re-bootstrap the control plane.
-func Recover(ctx context.Context, backend Backend, kubeConfigPath string) (asset.Assets, error) {
-	cp, err := backend.read(ctx)
+// Recover recovers a control plane using the provided backend and options, returning assets for
+// the existing control plane and a bootstrap control plane that can be used with `bootkube start`
+// to re-bootstrap the control plane.
+func Recover(ctx context.Context, backend Backend, opts Options) (asset.Assets, error) {
+	plan, err := NewPlan(ctx, backend, opts)
 	if err != nil {
 		return nil, err
 	}
+	return plan.Assets, nil
+}
 
-	as, err := cp.renderBootstrap()
+// NewPlan recovers a control plane like Recover, but returns a Plan that also describes the
+// recovered bootstrap pods and the objects they require.
+func NewPlan(ctx context.Context, backend Backend, opts Options) (*Plan, error) {
+	if err := CheckRecoveryScope(opts.Scope); err != nil {
+		return nil, err
+	}
+	scope := opts.Scope
+	scope.Nodes = scope.Nodes || opts.Node != ""
+	cp, err := backend.read(ctx, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	kc, err := renderKubeConfig(kubeConfigPath)
+	var node *v1.Node
+	var ip string
+	if opts.Node != "" {
+		if node, ip, err = cp.findNode(opts.Node); err != nil {
+			return nil, err
+		}
+	}
+	plan, err := cp.renderBootstrap(opts.components(), node, ip)
 	if err != nil {
 		return nil, err
 	}
-	as = append(as, kc)
 
-	return as, nil
+	kc, err := cp.kubeConfigAsset(opts)
+	if err != nil {
+		return nil, err
+	}
+	plan.Assets = append(plan.Assets, kc)
+
+	return plan, nil
 }
 
-// renderBootstrap returns assets for a bootstrap control plane that can be used with `bootkube
-// start` to re-bootstrap a control plane. These assets are derived from the self-hosted control
-// plane that was recovered by the backend, but modified for direct injection into a kubelet.
-func (cp *controlPlane) renderBootstrap() (asset.Assets, error) {
-	pods, err := extractBootstrapPods(cp.daemonSets.Items, cp.deployments.Items)
+// NewMasterPlans recovers a control plane like NewPlan, but returns a Plan for each master node,
+// ordered by node name. The master nodes are the nodes that match the node selectors of all
+// bootstrap pods. opts.Node is ignored.
+func NewMasterPlans(ctx context.Context, backend Backend, opts Options) ([]*Plan, error) {
+	if err := CheckRecoveryScope(opts.Scope); err != nil {
+		return nil, err
+	}
+	scope := opts.Scope
+	scope.Nodes = true
+	cp, err := backend.read(ctx, scope)
 	if err != nil {
 		return nil, err
 	}
-	requiredConfigMaps, requiredSecrets := fixUpBootstrapPods(pods)
-	as, err := outputBootstrapPods(pods)
+
+	components := opts.components()
+	pods, err := extractBootstrapPods(components, cp.daemonSets.Items, cp.deployments.Items)
 	if err != nil {
 		return nil, err
 	}
-	configMaps, err := outputBootstrapConfigMaps(cp.configMaps, requiredConfigMaps)
+	nodes, err := cp.masterNodes(pods)
+	if err != nil {
+		return nil, err
+	}
+	kc, err := cp.kubeConfigAsset(opts)
 	if err != nil {
 		return nil, err
 	}
-	as = append(as, configMaps...)
 
-	secrets, err := outputBootstrapSecrets(cp.secrets, requiredSecrets)
+	var plans []*Plan
+	for _, node := range nodes {
+		ip, err := nodeIP(node)
+		if err != nil {
+			return nil, err
+		}
+		plan, err := cp.renderBootstrap(components, node, ip)
+		if err != nil {
+			return nil, err
+		}
+		plan.Assets = append(plan.Assets, kc)
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// components returns the configured components, or DefaultComponents.
+func (opts Options) components() []Component {
+	if len(opts.Components) == 0 {
+		return DefaultComponents
+	}
+	return opts.Components
+}
+
+// kubeConfigAsset returns the kubeconfig asset, either read from opts.KubeConfigPath or created
+// for opts.Server.
+func (cp *controlPlane) kubeConfigAsset(opts Options) (asset.Asset, error) {
+	switch {
+	case opts.KubeConfigPath != "":
+		return renderKubeConfig(opts.KubeConfigPath)
+	case opts.Server != "":
+		return cp.renderAdminKubeConfig(opts.Server)
+	default:
+		return asset.Asset{}, errors.New("either a kubeconfig or an apiserver URL is required")
+	}
+}
+
+// renderBootstrap returns a plan with assets for a bootstrap control plane that can be used with
+// `bootkube start` to re-bootstrap a control plane. These assets are derived from the self-hosted
+// control plane that was recovered by the backend, but modified for direct injection into a
+// kubelet. If node is non-nil, the bootstrap pods are rendered for the node with the given IP.
+func (cp *controlPlane) renderBootstrap(components []Component, node *v1.Node, ip string) (*Plan, error) {
+	pods, err := extractBootstrapPods(components, cp.daemonSets.Items, cp.deployments.Items)
 	if err != nil {
 		return nil, err
 	}
-	as = append(as, secrets...)
-	return as, nil
+	projections := fixUpBootstrapPods(pods, kubeConfigContainers(components))
+	if node != nil {
+		substituteNode(pods, node, ip)
+	}
+	as, err := outputBootstrapPods(pods)
+	if err != nil {
+		return nil, err
+	}
+	files, err := cp.outputVolumeProjections(projections, node)
+	if err != nil {
+		return nil, err
+	}
+	as = append(as, files...)
+	plan := newPlan(pods, projections, as)
+	if node != nil {
+		plan.Node, plan.NodeIP = node.Name, ip
+	}
+	return plan, nil
 }
 
-// extractBootstrapPods extracts bootstrap pod specs from daemonsets and deployments.
-func extractBootstrapPods(daemonSets []v1apps.DaemonSet, deployments []v1apps.Deployment) ([]v1.Pod, error) {
+// extractBootstrapPods extracts bootstrap pod specs from the daemonsets and deployments that belong
+// to one of the components.
+func extractBootstrapPods(components []Component, daemonSets []v1apps.DaemonSet, deployments []v1apps.Deployment) ([]v1.Pod, error) {
 	var pods []v1.Pod
 	for _, ds := range daemonSets {
-		if isBootstrapApp(ds.Labels) {
-			pod := v1.Pod{Spec: ds.Spec.Template.Spec}
+		ok, err := isBootstrapApp(components, ds.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			pod := v1.Pod{Spec: *ds.Spec.Template.Spec.DeepCopy()}
 			if err := setBootstrapPodMetadata(&pod, ds.ObjectMeta); err != nil {
 				return nil, err
 			}
@@ -144,8 +257,12 @@ func extractBootstrapPods(daemonSets []v1apps.DaemonSet, deployments []v1apps.De
 		}
 	}
 	for _, ds := range deployments {
-		if isBootstrapApp(ds.Labels) {
-			pod := v1.Pod{Spec: ds.Spec.Template.Spec}
+		ok, err := isBootstrapApp(components, ds.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			pod := v1.Pod{Spec: *ds.Spec.Template.Spec.DeepCopy()}
 			if err := setBootstrapPodMetadata(&pod, ds.ObjectMeta); err != nil {
 				return nil, err
 			}
@@ -157,13 +274,17 @@ func extractBootstrapPods(daemonSets []v1apps.DaemonSet, deployments []v1apps.De
 
 // isBootstrapApp returns true if this app belongs to the bootstrap control plane, based on its
 // labels.
-func isBootstrapApp(labels map[string]string) bool {
-	k8sApp := labels[k8sAppLabel]
-	if k8sApp == "" {
-		k8sApp = labels[componentAppLabel]
+func isBootstrapApp(components []Component, labels map[string]string) (bool, error) {
+	for _, c := range components {
+		ok, err := c.matches(labels)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
 	}
-	_, ok := bootstrapK8sApps[k8sApp]
-	return ok
+	return false, nil
 }
 
 // setBootstrapPodMetadata creates valid metadata for a bootstrap pod. Currently it sets the
@@ -182,10 +303,11 @@ func setBootstrapPodMetadata(pod *v1.Pod, parent metav1.ObjectMeta) error {
 
 // fixUpBootstrapPods modifies extracted bootstrap pod specs to have correct metadata and point to
 // filesystem-mount-based secrets, and removes any security contexts that might prevent the pods
-// from accessing those secrets. It returns mappings from configMap and secret names to output
-// paths that must also be rendered in order for the bootstrap pods to be functional.
-func fixUpBootstrapPods(pods []v1.Pod) (requiredConfigMaps, requiredSecrets map[string]string) {
-	requiredConfigMaps, requiredSecrets = make(map[string]string), make(map[string]string)
+// from accessing those secrets. Containers named in kubeConfigContainers get a --kubeconfig flag.
+// It returns the volume projections that must also be rendered in order for the bootstrap pods to be
+// functional.
+func fixUpBootstrapPods(pods []v1.Pod, kubeConfigContainers map[string]struct{}) []volumeProjection {
+	var projections []volumeProjection
 	for i := range pods {
 		pod := &pods[i]
 
@@ -198,21 +320,8 @@ func fixUpBootstrapPods(pods []v1.Pod) (requiredConfigMaps, requiredSecrets map[
 		if pod.Spec.HostNetwork == false {
 			pod.Spec.HostNetwork = true
 		}
-		// Change secret volumes to point to file mounts.
-		for i := range pod.Spec.Volumes {
-			vol := &pod.Spec.Volumes[i]
-			if vol.Secret != nil {
-				pathSuffix := filepath.Join("secrets", vol.Secret.SecretName)
-				requiredSecrets[vol.Secret.SecretName] = filepath.Join(asset.AssetPathSecrets, pathSuffix)
-				vol.HostPath = &v1.HostPathVolumeSource{Path: filepath.Join(asset.BootstrapSecretsDir, pathSuffix)}
-				vol.Secret = nil
-			} else if vol.ConfigMap != nil {
-				pathSuffix := filepath.Join("config-maps", vol.ConfigMap.Name)
-				requiredConfigMaps[vol.ConfigMap.Name] = filepath.Join(asset.AssetPathSecrets, pathSuffix)
-				vol.HostPath = &v1.HostPathVolumeSource{Path: path.Join(asset.BootstrapSecretsDir, pathSuffix)}
-				vol.ConfigMap = nil
-			}
-		}
+		// Change secret, configMap and projected volumes to point to file mounts.
+		projections = append(projections, fixUpVolumes(pod)...)
 
 		// Make sure the kubeconfig is in the commandline.
 		for i := range pod.Spec.Containers {
@@ -224,8 +333,8 @@ func fixUpBootstrapPods(pods []v1.Pod) (requiredConfigMaps, requiredSecrets map[
 				cn.SecurityContext.RunAsUser = nil
 			}
 
-			// Assumes the bootkube naming convention is used. Could also just make sure the image uses hyperkube.
-			if _, ok := kubeConfigK8sContainers[cn.Name]; ok {
+			// Assumes the bootkube naming convention is used unless the components were configured.
+			if _, ok := kubeConfigContainers[cn.Name]; ok {
 				cn.Command = append(cn.Command, "--kubeconfig=/kubeconfig/kubeconfig")
 				cn.VolumeMounts = append(cn.VolumeMounts, v1.VolumeMount{
 					MountPath: "/kubeconfig",
@@ -241,78 +350,23 @@ func fixUpBootstrapPods(pods []v1.Pod) (requiredConfigMaps, requiredSecrets map[
 			Name:         "kubeconfig",
 		})
 	}
-	return
+	return projections
 }
 
 // outputBootstrapPods outputs the bootstrap pod definitions.
 func outputBootstrapPods(pods []v1.Pod) (asset.Assets, error) {
 	var as asset.Assets
 	for _, pod := range pods {
-		a, err := serializeObjToYAML(path.Join(asset.AssetPathBootstrapManifests, pod.Name+".yaml"), &pod)
-		if err != nil {
-			return nil, err
-		}
-		as = append(as, a)
-	}
-	return as, nil
-}
-
-// outputBootstrapConfigMaps creates assets for all the configMap names in the requiredConfigMaps
-// set. It returns an error if any configMap cannot be found in the provided configMaps list.
-func outputBootstrapConfigMaps(configMaps v1.ConfigMapList, requiredConfigMaps map[string]string) (asset.Assets, error) {
-	return outputKeyValueData(&configMaps, requiredConfigMaps, func(obj runtime.Object) map[string][]byte {
-		configMap, ok := obj.(*v1.ConfigMap)
-		if !ok || configMap == nil {
-			return nil
-		}
-		output := make(map[string][]byte)
-		for k, v := range configMap.Data {
-			output[k] = []byte(v)
+		// Static pods in different namespaces may have the same name.
+		name := pod.Name
+		if namespaceDir(pod.Namespace) != "" {
+			name = pod.Namespace + "-" + name
 		}
-		return output
-	})
-}
-
-// outputBootstrapSecrets creates assets for all the secret names in the requiredSecrets set. It
-// returns an error if any secret cannot be found in the provided secrets list.
-func outputBootstrapSecrets(secrets v1.SecretList, requiredSecrets map[string]string) (asset.Assets, error) {
-	return outputKeyValueData(&secrets, requiredSecrets, func(obj runtime.Object) map[string][]byte {
-		if secret, ok := obj.(*v1.Secret); ok && secret != nil {
-			return secret.Data
-		}
-		return nil
-	})
-}
-
-// outputKeyValueData takes a key-value object (such as a Secret or ConfigMap) and outputs assets
-// for each key-value pair. See outputBootstrapConfigMaps or outputBootstrapSecrets for usage.
-func outputKeyValueData(objList runtime.Object, requiredObjs map[string]string, extractData func(runtime.Object) map[string][]byte) (asset.Assets, error) {
-	var as asset.Assets
-	objs, err := meta.ExtractList(objList)
-	if err != nil {
-		return nil, err
-	}
-	for _, obj := range objs {
-		name, err := metaAccessor.Name(obj)
+		a, err := serializeObjToYAML(path.Join(asset.AssetPathBootstrapManifests, name+".yaml"), &pod)
 		if err != nil {
 			return nil, err
 		}
-		if namePrefix, ok := requiredObjs[name]; ok {
-			for key, data := range extractData(obj) {
-				as = append(as, asset.Asset{
-					Name: path.Join(namePrefix, key),
-					Data: data,
-				})
-			}
-			delete(requiredObjs, name)
-		}
-	}
-	if len(requiredObjs) > 0 {
-		var missingObjs []string
-		for obj := range requiredObjs {
-			missingObjs = append(missingObjs, obj)
-		}
-		return nil, fmt.Errorf("failed to extract some required objects: %v", missingObjs)
+		as = append(as, a)
 	}
 	return as, nil
 }
@@ -99,7 +99,7 @@ var (
 )
 
 func TestExtractBootstrapPods(t *testing.T) {
-	got, err := extractBootstrapPods(cp.daemonSets.Items, cp.deployments.Items)
+	got, err := extractBootstrapPods(DefaultComponents, cp.daemonSets.Items, cp.deployments.Items)
 	want := []v1.Pod{{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Pod",
@@ -331,65 +331,84 @@ func TestFixUpBootstrapPods(t *testing.T) {
 			}},
 		},
 	}}
-	wantConfigMaps := map[string]string{"kube-apiserver": "tls/config-maps/kube-apiserver"}
-	wantSecrets := map[string]string{"kube-apiserver": "tls/secrets/kube-apiserver"}
-	gotConfigMaps, gotSecrets := fixUpBootstrapPods(pods)
-	if !reflect.DeepEqual(gotSecrets, wantSecrets) || !reflect.DeepEqual(gotConfigMaps, wantConfigMaps) {
-		t.Errorf("fixUpBootstrapPods(%v) = %v, %v, want: %v, %v", pods, gotConfigMaps, gotSecrets, wantConfigMaps, wantSecrets)
+	wantProjections := []volumeProjection{{
+		dir: "tls/config-maps/kube-apiserver",
+		source: v1.VolumeProjection{ConfigMap: &v1.ConfigMapProjection{
+			LocalObjectReference: v1.LocalObjectReference{Name: "kube-apiserver"},
+		}},
+		pod: &pods[0],
+	}, {
+		dir: "tls/secrets/kube-apiserver",
+		source: v1.VolumeProjection{Secret: &v1.SecretProjection{
+			LocalObjectReference: v1.LocalObjectReference{Name: "kube-apiserver"},
+		}},
+		pod: &pods[0],
+	}}
+	gotProjections := fixUpBootstrapPods(pods, kubeConfigContainers(DefaultComponents))
+	if !reflect.DeepEqual(gotProjections, wantProjections) {
+		t.Errorf("fixUpBootstrapPods(%v) = %v, want: %v", pods, gotProjections, wantProjections)
 	} else if !reflect.DeepEqual(pods, wantPods) {
 		t.Errorf("fixUpBootstrapPods(%v) = %v, want: %v", pods, pods, wantPods)
 	}
 }
 
-func TestOutputConfigMaps(t *testing.T) {
-	requiredSecrets := map[string]string{"kube-apiserver": "tls/kube-apiserver"}
-	want := asset.Assets{{
-		Name: "tls/kube-apiserver/apiserver.crt",
-		Data: secretData,
+func TestOutputVolumeProjections(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-kube-apiserver"}}
+	projections := []volumeProjection{{
+		dir: "tls/config-maps/kube-apiserver",
+		source: v1.VolumeProjection{ConfigMap: &v1.ConfigMapProjection{
+			LocalObjectReference: v1.LocalObjectReference{Name: "kube-apiserver"},
+		}},
+		pod: pod,
+	}, {
+		dir: "tls/secrets/kube-apiserver",
+		source: v1.VolumeProjection{Secret: &v1.SecretProjection{
+			LocalObjectReference: v1.LocalObjectReference{Name: "kube-apiserver"},
+		}},
+		pod: pod,
 	}}
-	if got, err := outputBootstrapSecrets(cp.secrets, requiredSecrets); err != nil {
-		t.Errorf("outputBootstrapSecrets(%v, %v) = %v, want: nil", cp.secrets.Items, requiredSecrets, err)
-	} else if !reflect.DeepEqual(got, want) {
-		t.Errorf("outputBootstrapSecrets(%v, %v) = %v, want: %v", cp.secrets.Items, requiredSecrets, got, want)
-	}
-}
-
-func TestOutputBootstrapSecrets(t *testing.T) {
-	requiredSecrets := map[string]string{"kube-apiserver": "tls/kube-apiserver"}
 	want := asset.Assets{{
-		Name: "tls/kube-apiserver/apiserver.crt",
+		Name: "tls/config-maps/kube-apiserver/key",
+		Data: []byte("value"),
+	}, {
+		Name: "tls/secrets/kube-apiserver/apiserver.crt",
 		Data: secretData,
 	}}
-	if got, err := outputBootstrapSecrets(cp.secrets, requiredSecrets); err != nil {
-		t.Errorf("outputBootstrapSecrets(%v, %v) = %v, want: nil", cp.secrets.Items, requiredSecrets, err)
+	if got, err := cp.outputVolumeProjections(projections, nil); err != nil {
+		t.Errorf("outputVolumeProjections(%v) = %v, want: nil", projections, err)
 	} else if !reflect.DeepEqual(got, want) {
-		t.Errorf("outputBootstrapSecrets(%v, %v) = %v, want: %v", cp.secrets.Items, requiredSecrets, got, want)
+		t.Errorf("outputVolumeProjections(%v) = %v, want: %v", projections, got, want)
 	}
 }
 
-func TestOutputKeyValueDataKeyMissing(t *testing.T) {
-	objList := &v1.SecretList{}
-	requiredObjs := map[string]string{"missing-key": "some-path"}
-	if as, err := outputKeyValueData(objList, requiredObjs, func(obj runtime.Object) map[string][]byte { return obj.(*v1.Secret).Data }); err == nil {
-		t.Errorf("outputKeyValueData(%v, %v) = %v, %v, want: nil, non-nil", objList, requiredObjs, as, err)
+func TestOutputVolumeProjectionsObjectMissing(t *testing.T) {
+	projections := []volumeProjection{{
+		dir: "some-path",
+		source: v1.VolumeProjection{Secret: &v1.SecretProjection{
+			LocalObjectReference: v1.LocalObjectReference{Name: "missing-key"},
+		}},
+		pod: &v1.Pod{},
+	}}
+	if as, err := cp.outputVolumeProjections(projections, nil); err == nil {
+		t.Errorf("outputVolumeProjections(%v) = %v, %v, want: nil, non-nil", projections, as, err)
 	}
 }
 
 func TestIsBootstrapApp(t *testing.T) {
-	for app := range bootstrapK8sApps {
+	for _, c := range DefaultComponents {
 		labels := map[string]string{
 			"tier":      "control-plane",
-			k8sAppLabel: app,
+			k8sAppLabel: c.Name,
 		}
-		if !isBootstrapApp(labels) {
-			t.Errorf("isBootstrapApp(%v) = false, want: true", labels)
+		if ok, err := isBootstrapApp(DefaultComponents, labels); !ok || err != nil {
+			t.Errorf("isBootstrapApp(%v) = %t, %v, want: true, nil", labels, ok, err)
 		}
 		labels = map[string]string{
 			"tier":            "control-plane",
-			componentAppLabel: app,
+			componentAppLabel: c.Name,
 		}
-		if !isBootstrapApp(labels) {
-			t.Errorf("isBootstrapApp(%v) = false, want: true", labels)
+		if ok, err := isBootstrapApp(DefaultComponents, labels); !ok || err != nil {
+			t.Errorf("isBootstrapApp(%v) = %t, %v, want: true, nil", labels, ok, err)
 		}
 	}
 }
@@ -402,8 +421,8 @@ func TestIsNotBootstrapApp(t *testing.T) {
 		"tier":        "control-plane",
 		"wrong-label": "kube-apiserver",
 	}} {
-		if isBootstrapApp(labels) {
-			t.Errorf("isBootstrapApp(%v) = true, want: false", labels)
+		if ok, err := isBootstrapApp(DefaultComponents, labels); ok || err != nil {
+			t.Errorf("isBootstrapApp(%v) = %t, %v, want: false, nil", labels, ok, err)
 		}
 	}
 }
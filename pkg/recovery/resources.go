@@ -0,0 +1,233 @@
+package recovery
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// defaultNamespace is the namespace that the control plane output by `bootkube render` runs in.
+const defaultNamespace = "kube-system"
+
+// Resource identifies an API resource that is read by a Backend.
+type Resource struct {
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	// EtcdKey is the key below the etcd prefix that objects of the resource are stored under, e.g.
+	// "deployments" or "example.com/widgets". Defaults to the resource name for the core group,
+	// groups without a dot and *.k8s.io groups, and to "<group>/<resource>" otherwise, which
+	// matches the layout the apiserver uses for most resources.
+	EtcdKey string `json:"etcdKey,omitempty"`
+}
+
+var (
+	configMapsResource  = Resource{Version: "v1", Resource: "configmaps"}
+	daemonSetsResource  = Resource{Group: "apps", Version: "v1", Resource: "daemonsets"}
+	deploymentsResource = Resource{Group: "apps", Version: "v1", Resource: "deployments"}
+	secretsResource     = Resource{Version: "v1", Resource: "secrets"}
+	// nodesResource is stored under the name it had in early versions of Kubernetes.
+	nodesResource = Resource{Version: "v1", Resource: "nodes", EtcdKey: "minions"}
+
+	// recoveryResources are the resources that are always read, since they are needed by Recover.
+	recoveryResources = []Resource{configMapsResource, daemonSetsResource, deploymentsResource, secretsResource}
+)
+
+// ParseResource parses a resource in the format "[group/]version/resource[=etcdKey]", e.g.
+// "apps/v1/statefulsets" or "example.com/v1/widgets=example.com/widgets".
+func ParseResource(s string) (Resource, error) {
+	var r Resource
+	if i := strings.Index(s, "="); i >= 0 {
+		s, r.EtcdKey = s[:i], s[i+1:]
+		if r.EtcdKey == "" {
+			return Resource{}, fmt.Errorf("invalid resource %q: empty etcd key", s)
+		}
+	}
+	parts := strings.Split(s, "/")
+	switch len(parts) {
+	case 2:
+		r.Version, r.Resource = parts[0], parts[1]
+	case 3:
+		r.Group, r.Version, r.Resource = parts[0], parts[1], parts[2]
+	default:
+		return Resource{}, fmt.Errorf("invalid resource %q: must be [group/]version/resource", s)
+	}
+	if r.Version == "" || r.Resource == "" {
+		return Resource{}, fmt.Errorf("invalid resource %q: must be [group/]version/resource", s)
+	}
+	return r, nil
+}
+
+// String returns the resource in the format accepted by ParseResource.
+func (r Resource) String() string {
+	s := path.Join(r.Group, r.Version, r.Resource)
+	if r.EtcdKey != "" {
+		s += "=" + r.EtcdKey
+	}
+	return s
+}
+
+// GroupVersionResource returns the schema.GroupVersionResource of r.
+func (r Resource) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+}
+
+func (r Resource) groupResource() schema.GroupResource {
+	return schema.GroupResource{Group: r.Group, Resource: r.Resource}
+}
+
+// namespaced returns false for the cluster-scoped resources that are read by a Backend. Additional
+// resources are always namespaced.
+func (r Resource) namespaced() bool {
+	return r.groupResource() != nodesResource.groupResource()
+}
+
+// etcdKey returns the key below the etcd prefix that objects of the resource are stored under.
+func (r Resource) etcdKey() string {
+	if r.EtcdKey != "" {
+		return r.EtcdKey
+	}
+	if r.Group == "" || !strings.Contains(r.Group, ".") || strings.HasSuffix(r.Group, ".k8s.io") {
+		return r.Resource
+	}
+	return path.Join(r.Group, r.Resource)
+}
+
+// Scope defines the objects that a Backend reads.
+type Scope struct {
+	// Namespaces are the namespaces that objects are read from. Defaults to kube-system.
+	Namespaces []string
+	// Resources are read in addition to the configMaps, daemonSets, deployments and secrets that
+	// are needed for recovery. A Resource with the group and name of one of those overrides its
+	// etcd key. Additional resources are only exported by Backup, see CheckRecoveryScope.
+	Resources []Resource
+	// Nodes reads the nodes of the cluster in addition, which are needed to render bootstrap pods
+	// for a specific node.
+	Nodes bool
+}
+
+// namespaces returns the namespaces of the scope, defaulting to kube-system.
+func (s Scope) namespaces() []string {
+	if len(s.Namespaces) == 0 {
+		return []string{defaultNamespace}
+	}
+	return s.Namespaces
+}
+
+// namespacesOf returns the namespaces that objects of r are read from, which is a single empty
+// namespace for cluster-scoped resources.
+func (s Scope) namespacesOf(r Resource) []string {
+	if !r.namespaced() {
+		return []string{""}
+	}
+	return s.namespaces()
+}
+
+// resources returns the resources needed for recovery and the nodes if requested, with any
+// overrides applied, followed by the additional resources of the scope.
+func (s Scope) resources() []Resource {
+	resources := append([]Resource(nil), recoveryResources...)
+	if s.Nodes {
+		resources = append(resources, nodesResource)
+	}
+	base := len(resources)
+	for _, r := range s.Resources {
+		overridden := false
+		for i := range resources[:base] {
+			if resources[i].groupResource() == r.groupResource() {
+				if r.EtcdKey != "" {
+					resources[i].EtcdKey = r.EtcdKey
+				}
+				overridden = true
+			}
+		}
+		if !overridden {
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// CheckRecoveryScope returns an error if scope has additional resources, so that it may only
+// override the etcd keys of the resources needed for recovery. Recovery only renders the
+// bootstrap control plane, and the objects of additional resources are restored with the etcd
+// snapshot of a backup, so reading them for recovery would silently drop them.
+func CheckRecoveryScope(scope Scope) error {
+	var additional []string
+	for _, r := range scope.Resources {
+		if !isRecoveryResource(r) {
+			additional = append(additional, r.String())
+		}
+	}
+	if len(additional) > 0 {
+		return fmt.Errorf("additional resources are not recovered, only exported by backups: %s", strings.Join(additional, ", "))
+	}
+	return nil
+}
+
+// isRecoveryResource returns true if r is one of the resources needed for recovery or the nodes.
+func isRecoveryResource(r Resource) bool {
+	for _, rr := range append([]Resource{nodesResource}, recoveryResources...) {
+		if rr.groupResource() == r.groupResource() {
+			return true
+		}
+	}
+	return false
+}
+
+// typedList returns the list of cp that holds objects of r, or nil if r is not one of the
+// resources needed for recovery or the nodes.
+func (cp *controlPlane) typedList(r Resource) runtime.Object {
+	switch r.groupResource() {
+	case configMapsResource.groupResource():
+		return &cp.configMaps
+	case daemonSetsResource.groupResource():
+		return &cp.daemonSets
+	case deploymentsResource.groupResource():
+		return &cp.deployments
+	case secretsResource.groupResource():
+		return &cp.secrets
+	case nodesResource.groupResource():
+		return &cp.nodes
+	}
+	return nil
+}
+
+// addObject adds an object of an additional resource to cp.
+func (cp *controlPlane) addObject(r Resource, obj unstructured.Unstructured) {
+	if cp.objects == nil {
+		cp.objects = make(map[schema.GroupVersionResource][]unstructured.Unstructured)
+	}
+	gvr := r.GroupVersionResource()
+	cp.objects[gvr] = append(cp.objects[gvr], obj)
+}
+
+// objectName returns the name of an object as shown to users, which includes the namespace unless
+// it is kube-system.
+func objectName(namespace, name string) string {
+	if namespace == "" || namespace == defaultNamespace {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// namespaceDir returns the asset directory for objects in namespace, relative to the directory for
+// kube-system objects, so that objects with the same name in different namespaces do not collide.
+func namespaceDir(namespace string) string {
+	if namespace == "" || namespace == defaultNamespace {
+		return ""
+	}
+	return path.Join("namespaces", namespace)
+}
+
+// describeResource describes the objects of r in namespace ns for error messages.
+func describeResource(r Resource, ns string) string {
+	if ns == "" {
+		return r.Resource
+	}
+	return fmt.Sprintf("%s in namespace %s", r.Resource, ns)
+}
@@ -0,0 +1,107 @@
+package recovery
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseResource(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    Resource
+		wantKey string
+	}{{
+		in:      "v1/configmaps",
+		want:    Resource{Version: "v1", Resource: "configmaps"},
+		wantKey: "configmaps",
+	}, {
+		in:      "apps/v1/statefulsets",
+		want:    Resource{Group: "apps", Version: "v1", Resource: "statefulsets"},
+		wantKey: "statefulsets",
+	}, {
+		in:      "rbac.authorization.k8s.io/v1/roles",
+		want:    Resource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"},
+		wantKey: "roles",
+	}, {
+		in:      "example.com/v1/widgets",
+		want:    Resource{Group: "example.com", Version: "v1", Resource: "widgets"},
+		wantKey: "example.com/widgets",
+	}, {
+		in:      "apps/v1/deployments=custom/deployments",
+		want:    Resource{Group: "apps", Version: "v1", Resource: "deployments", EtcdKey: "custom/deployments"},
+		wantKey: "custom/deployments",
+	}} {
+		got, err := ParseResource(tc.in)
+		if err != nil {
+			t.Errorf("ParseResource(%q) = %v, want: nil", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseResource(%q) = %v, want: %v", tc.in, got, tc.want)
+		}
+		if got.etcdKey() != tc.wantKey {
+			t.Errorf("ParseResource(%q).etcdKey() = %s, want: %s", tc.in, got.etcdKey(), tc.wantKey)
+		}
+		if got.String() != tc.in {
+			t.Errorf("ParseResource(%q).String() = %s, want: %s", tc.in, got.String(), tc.in)
+		}
+	}
+}
+
+func TestParseResourceInvalid(t *testing.T) {
+	for _, in := range []string{"", "configmaps", "a/b/c/d", "v1/", "v1/configmaps="} {
+		if got, err := ParseResource(in); err == nil {
+			t.Errorf("ParseResource(%q) = %v, nil, want: non-nil", in, got)
+		}
+	}
+}
+
+func TestScopeResources(t *testing.T) {
+	widgets := Resource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	scope := Scope{Resources: []Resource{
+		{Group: "apps", Version: "v1", Resource: "deployments", EtcdKey: "custom/deployments"},
+		widgets,
+	}}
+	want := []Resource{
+		configMapsResource,
+		daemonSetsResource,
+		{Group: "apps", Version: "v1", Resource: "deployments", EtcdKey: "custom/deployments"},
+		secretsResource,
+		widgets,
+	}
+	if got := scope.resources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("resources() = %v, want: %v", got, want)
+	}
+	if !reflect.DeepEqual(recoveryResources[2], deploymentsResource) {
+		t.Errorf("resources() modified the default resources: %v", recoveryResources)
+	}
+	if got := scope.namespaces(); !reflect.DeepEqual(got, []string{"kube-system"}) {
+		t.Errorf("namespaces() = %v, want: [kube-system]", got)
+	}
+}
+
+func TestCheckRecoveryScope(t *testing.T) {
+	overrides := Scope{Resources: []Resource{
+		{Group: "apps", Version: "v1", Resource: "deployments", EtcdKey: "custom/deployments"},
+		{Version: "v1", Resource: "nodes", EtcdKey: "custom/nodes"},
+	}}
+	if err := CheckRecoveryScope(overrides); err != nil {
+		t.Errorf("CheckRecoveryScope() with etcd key overrides = %v, want: nil", err)
+	}
+
+	widgets := Scope{Resources: []Resource{{Group: "example.com", Version: "v1", Resource: "widgets"}}}
+	if err := CheckRecoveryScope(widgets); err == nil {
+		t.Errorf("CheckRecoveryScope() with additional resources = nil, want: non-nil")
+	}
+	// Recovery would drop the objects of the additional resources, so it must fail.
+	const want = "example.com/v1/widgets"
+	opts := Options{Server: "https://10.0.0.1:6443", Scope: widgets}
+	if _, err := NewPlan(context.Background(), fakeBackend{cp}, opts); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("NewPlan() with additional resources = %v, want: error about %s", err, want)
+	}
+	if _, err := NewMasterPlans(context.Background(), fakeBackend{cp}, opts); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("NewMasterPlans() with additional resources = %v, want: error about %s", err, want)
+	}
+}
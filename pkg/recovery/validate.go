@@ -0,0 +1,336 @@
+package recovery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset"
+	"github.com/kubernetes-sigs/bootkube/pkg/projection"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// imageReferenceRegexp matches image references that can be pulled, following the grammar of
+// github.com/docker/distribution/reference: [domain[:port]/]name[:tag][@digest].
+var imageReferenceRegexp = func() *regexp.Regexp {
+	domainComponent := `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+	domain := domainComponent + `(?:\.` + domainComponent + `)*(?::[0-9]+)?`
+	nameComponent := `[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*`
+	name := `(?:` + domain + `/)?` + nameComponent + `(?:/` + nameComponent + `)*`
+	tag := `[\w][\w.-]{0,127}`
+	digest := `[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}`
+	return regexp.MustCompile(`^` + name + `(?::` + tag + `)?(?:@` + digest + `)?$`)
+}()
+
+// maxImageNameLength is the maximum length of the name part of an image reference.
+const maxImageNameLength = 255
+
+// Validate checks that the recovered assets can be used by `bootkube start`, and returns every
+// problem found. Recovered certificates must be valid now and chain to a recovered CA, and the
+// apiserver certificate must cover the server of the kubeconfig and the advertise address of the
+// apiserver. Every hostPath below asset.BootstrapSecretsDir that a bootstrap pod uses must be
+// rendered, and all images must be valid references.
+func (p *Plan) Validate() []error {
+	return p.validate(time.Now())
+}
+
+func (p *Plan) validate(now time.Time) []error {
+	var problems []error
+	problems = append(problems, p.validateCertificates(now)...)
+	problems = append(problems, p.validateAPIServerCertificate()...)
+	problems = append(problems, p.validateHostPaths()...)
+	problems = append(problems, p.validateImages()...)
+	return problems
+}
+
+// recoveredCertificate is a certificate found in an asset.
+type recoveredCertificate struct {
+	asset string
+	cert  *x509.Certificate
+}
+
+// certificates returns the certificates in the recovered secrets and configMaps, skipping
+// duplicates.
+func (p *Plan) certificates() ([]recoveredCertificate, []error) {
+	var certs []recoveredCertificate
+	var problems []error
+	seen := make(map[[sha256.Size]byte]bool)
+	for _, a := range p.Assets {
+		if !strings.HasPrefix(a.Name, asset.AssetPathSecrets+"/") {
+			continue
+		}
+		rest := a.Data
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s: failed to parse certificate: %v", a.Name, err))
+				continue
+			}
+			if sum := sha256.Sum256(cert.Raw); !seen[sum] {
+				seen[sum] = true
+				certs = append(certs, recoveredCertificate{asset: a.Name, cert: cert})
+			}
+		}
+	}
+	return certs, problems
+}
+
+// validateCertificates checks that all recovered certificates are valid at now, and that all
+// certificates that are not CAs chain to a recovered CA.
+func (p *Plan) validateCertificates(now time.Time) []error {
+	certs, problems := p.certificates()
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	for _, c := range certs {
+		if !c.cert.IsCA {
+			continue
+		}
+		if bytes.Equal(c.cert.RawIssuer, c.cert.RawSubject) && c.cert.CheckSignatureFrom(c.cert) == nil {
+			roots.AddCert(c.cert)
+		} else {
+			intermediates.AddCert(c.cert)
+		}
+	}
+
+	for _, c := range certs {
+		switch {
+		case now.After(c.cert.NotAfter):
+			problems = append(problems, fmt.Errorf("%s: certificate %q expired at %s", c.asset, c.cert.Subject.CommonName, c.cert.NotAfter.UTC().Format(time.RFC3339)))
+			continue
+		case now.Before(c.cert.NotBefore):
+			problems = append(problems, fmt.Errorf("%s: certificate %q is not valid before %s", c.asset, c.cert.Subject.CommonName, c.cert.NotBefore.UTC().Format(time.RFC3339)))
+			continue
+		case c.cert.IsCA:
+			continue
+		}
+		_, err := c.cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		// Expired CAs have already been reported above.
+		if invalid, ok := err.(x509.CertificateInvalidError); ok && invalid.Reason == x509.Expired {
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: certificate %q does not chain to a recovered CA: %v", c.asset, c.cert.Subject.CommonName, err))
+		}
+	}
+	return problems
+}
+
+// validateAPIServerCertificate checks that the serving certificate of the apiserver covers the
+// endpoints that clients use to reach it.
+func (p *Plan) validateAPIServerCertificate() []error {
+	var problems []error
+	for i := range p.Pods {
+		pod := &p.Pods[i]
+		for _, c := range pod.Spec.Containers {
+			if c.Name != apiServerContainerName {
+				continue
+			}
+			certFile, ok := flagValue(c, "--tls-cert-file")
+			if !ok {
+				continue
+			}
+			assetName, ok := containerPathAsset(pod, c, certFile)
+			if !ok {
+				continue
+			}
+			a, err := p.Assets.Get(assetName)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("pod %s: apiserver certificate %s is not recovered", pod.Name, certFile))
+				continue
+			}
+			block, _ := pem.Decode(a.Data)
+			if block == nil {
+				problems = append(problems, fmt.Errorf("%s: no PEM encoded apiserver certificate", a.Name))
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				// Reported by validateCertificates.
+				continue
+			}
+			for _, host := range p.apiServerEndpoints(c) {
+				if err := cert.VerifyHostname(host); err != nil {
+					problems = append(problems, fmt.Errorf("%s: apiserver certificate is not valid for %s", a.Name, host))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// apiServerEndpoints returns the hosts that clients use to reach the apiserver: the advertise
+// address of the apiserver container, if it does not reference an environment variable, and the
+// server of the recovered kubeconfig.
+func (p *Plan) apiServerEndpoints(c v1.Container) []string {
+	var hosts []string
+	if addr, ok := flagValue(c, "--advertise-address"); ok && addr != "" && !strings.Contains(addr, "$(") {
+		hosts = append(hosts, addr)
+	}
+	a, err := p.Assets.Get(asset.AssetPathAdminKubeConfig)
+	if err != nil {
+		return hosts
+	}
+	kubeConfig, err := clientcmd.Load(a.Data)
+	if err != nil {
+		return hosts
+	}
+	kc, ok := kubeConfig.Contexts[kubeConfig.CurrentContext]
+	if !ok {
+		return hosts
+	}
+	cluster, ok := kubeConfig.Clusters[kc.Cluster]
+	if !ok {
+		return hosts
+	}
+	u, err := url.Parse(cluster.Server)
+	if err != nil || u.Hostname() == "" {
+		return hosts
+	}
+	for _, h := range hosts {
+		if h == u.Hostname() {
+			return hosts
+		}
+	}
+	return append(hosts, u.Hostname())
+}
+
+// validateHostPaths checks that every hostPath below asset.BootstrapSecretsDir that is used by a
+// bootstrap pod is rendered. Volumes whose secrets and configMaps are all optional may be empty.
+func (p *Plan) validateHostPaths() []error {
+	optional := make(map[string]bool)
+	for _, proj := range p.projections {
+		opt := (proj.source.Secret != nil && projection.IsOptional(proj.source.Secret.Optional)) ||
+			(proj.source.ConfigMap != nil && projection.IsOptional(proj.source.ConfigMap.Optional))
+		if prev, ok := optional[proj.dir]; ok {
+			opt = opt && prev
+		}
+		optional[proj.dir] = opt
+	}
+
+	var problems []error
+	for _, pod := range p.Pods {
+		for _, vol := range pod.Spec.Volumes {
+			if vol.HostPath == nil {
+				continue
+			}
+			assetName, ok := hostPathAsset(vol.HostPath.Path)
+			if !ok || optional[assetName] {
+				continue
+			}
+			found := false
+			for _, a := range p.Assets {
+				if a.Name == assetName || strings.HasPrefix(a.Name, assetName+"/") {
+					found = true
+					break
+				}
+			}
+			if !found {
+				problems = append(problems, fmt.Errorf("pod %s: hostPath %s of volume %s is not rendered", pod.Name, vol.HostPath.Path, vol.Name))
+			}
+		}
+	}
+	return problems
+}
+
+// validateImages checks that the images of all containers are valid image references.
+func (p *Plan) validateImages() []error {
+	var problems []error
+	for _, pod := range p.Pods {
+		containers := append(append([]v1.Container(nil), pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for _, c := range containers {
+			if err := validateImageReference(c.Image); err != nil {
+				problems = append(problems, fmt.Errorf("pod %s: container %s: %v", pod.Name, c.Name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// validateImageReference returns an error if image is not a reference that can be pulled.
+func validateImageReference(image string) error {
+	if image == "" {
+		return fmt.Errorf("no image")
+	}
+	if !imageReferenceRegexp.MatchString(image) {
+		return fmt.Errorf("invalid image reference %q", image)
+	}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	if len(name) > maxImageNameLength {
+		return fmt.Errorf("invalid image reference %q: name longer than %d characters", image, maxImageNameLength)
+	}
+	return nil
+}
+
+// hostPathAsset returns the name of the asset that `bootkube start` copies to a hostPath, if the
+// hostPath is below asset.BootstrapSecretsDir.
+func hostPathAsset(hostPath string) (string, bool) {
+	rel := strings.TrimPrefix(path.Clean(hostPath), asset.BootstrapSecretsDir+"/")
+	if rel == path.Clean(hostPath) {
+		return "", false
+	}
+	if rel == "kubeconfig" {
+		return asset.AssetPathAdminKubeConfig, true
+	}
+	return path.Join(asset.AssetPathSecrets, rel), true
+}
+
+// containerPathAsset returns the name of the asset that provides a file in a container, if the file
+// is in a hostPath volume below asset.BootstrapSecretsDir.
+func containerPathAsset(pod *v1.Pod, c v1.Container, file string) (string, bool) {
+	for _, m := range c.VolumeMounts {
+		rel := strings.TrimPrefix(file, strings.TrimSuffix(m.MountPath, "/")+"/")
+		if rel == file {
+			continue
+		}
+		for _, vol := range pod.Spec.Volumes {
+			if vol.Name != m.Name || vol.HostPath == nil {
+				continue
+			}
+			if dir, ok := hostPathAsset(vol.HostPath.Path); ok {
+				return path.Join(dir, m.SubPath, rel), true
+			}
+		}
+	}
+	return "", false
+}
+
+// flagValue returns the value of a command line flag of a container, given as "--name=value" or
+// "--name value".
+func flagValue(c v1.Container, name string) (string, bool) {
+	args := append(append([]string(nil), c.Command...), c.Args...)
+	for i, arg := range args {
+		if strings.HasPrefix(arg, name+"=") {
+			return strings.TrimPrefix(arg, name+"="), true
+		}
+		if arg == name && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,188 @@
+package recovery
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset"
+	"github.com/kubernetes-sigs/bootkube/pkg/tlsutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestCA returns a PEM encoded CA certificate and a function that signs server certificates
+// with it.
+func newTestCA(t *testing.T) ([]byte, func(ips ...string) []byte) {
+	caKey, err := tlsutil.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := tlsutil.NewSelfSignedCACertificate(tlsutil.CertConfig{CommonName: "kube-ca"}, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(ips ...string) []byte {
+		key, err := tlsutil.NewPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := tlsutil.CertConfig{CommonName: "kube-apiserver"}
+		for _, ip := range ips {
+			cfg.AltNames.IPs = append(cfg.AltNames.IPs, net.ParseIP(ip))
+		}
+		cert, err := tlsutil.NewSignedCertificate(cfg, key, caCert, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tlsutil.EncodeCertificatePEM(cert)
+	}
+	return tlsutil.EncodeCertificatePEM(caCert), sign
+}
+
+func TestValidate(t *testing.T) {
+	ca, sign := newTestCA(t)
+	_, signOther := newTestCA(t)
+	newValidPlan := func() *Plan {
+		return &Plan{
+			Pods: []v1.Pod{{
+				ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-kube-apiserver"},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{
+						Name:         "kube-apiserver",
+						Image:        "k8s.gcr.io/hyperkube:v1.14.0",
+						Command:      []string{"kube-apiserver", "--advertise-address=$(POD_IP)", "--tls-cert-file=/etc/kubernetes/secrets/apiserver.crt"},
+						VolumeMounts: []v1.VolumeMount{{Name: "secrets", MountPath: "/etc/kubernetes/secrets"}},
+					}},
+					Volumes: []v1.Volume{{
+						Name:         "secrets",
+						VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/etc/kubernetes/bootstrap-secrets/secrets/kube-apiserver"}},
+					}, {
+						Name:         "ssl-certs-host",
+						VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/etc/ssl/certs"}},
+					}},
+				},
+			}},
+			Assets: asset.Assets{
+				{Name: "tls/secrets/kube-apiserver/ca.crt", Data: ca},
+				{Name: "tls/secrets/kube-apiserver/apiserver.crt", Data: sign("10.0.0.1")},
+				{Name: asset.AssetPathAdminKubeConfig, Data: []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: local
+  cluster:
+    server: https://10.0.0.1:6443
+contexts:
+- name: local
+  context:
+    cluster: local
+current-context: local
+`)},
+			},
+		}
+	}
+
+	now := time.Now()
+	if problems := newValidPlan().validate(now); len(problems) != 0 {
+		t.Errorf("validate() = %v, want: []", problems)
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		modify func(*Plan)
+		now    time.Time
+		want   string
+	}{{
+		desc: "expired",
+		now:  now.Add(2 * tlsutil.Duration365d),
+		want: `tls/secrets/kube-apiserver/apiserver.crt: certificate "kube-apiserver" expired`,
+	}, {
+		desc: "not chained",
+		modify: func(p *Plan) {
+			p.Assets[1].Data = signOther("10.0.0.1")
+		},
+		want: `certificate "kube-apiserver" does not chain to a recovered CA`,
+	}, {
+		desc: "advertise address not covered",
+		modify: func(p *Plan) {
+			p.Pods[0].Spec.Containers[0].Command[1] = "--advertise-address=10.0.0.2"
+		},
+		want: "apiserver certificate is not valid for 10.0.0.2",
+	}, {
+		desc: "server not covered",
+		modify: func(p *Plan) {
+			p.Assets[1].Data = sign("10.0.0.2")
+		},
+		want: "apiserver certificate is not valid for 10.0.0.1",
+	}, {
+		desc: "hostPath not rendered",
+		modify: func(p *Plan) {
+			p.Pods[0].Spec.Volumes[0].HostPath.Path = "/etc/kubernetes/bootstrap-secrets/secrets/missing"
+		},
+		want: "hostPath /etc/kubernetes/bootstrap-secrets/secrets/missing of volume secrets is not rendered",
+	}, {
+		desc: "invalid image",
+		modify: func(p *Plan) {
+			p.Pods[0].Spec.Containers[0].Image = "k8s.gcr.io/Hyperkube:v1.14.0"
+		},
+		want: `container kube-apiserver: invalid image reference "k8s.gcr.io/Hyperkube:v1.14.0"`,
+	}} {
+		p := newValidPlan()
+		if tc.modify != nil {
+			tc.modify(p)
+		}
+		if tc.now.IsZero() {
+			tc.now = now
+		}
+		problems := p.validate(tc.now)
+		found := false
+		for _, err := range problems {
+			found = found || strings.Contains(err.Error(), tc.want)
+		}
+		if !found {
+			t.Errorf("%s: validate() = %v, want: %s", tc.desc, problems, tc.want)
+		}
+	}
+}
+
+func TestValidateOptionalHostPath(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-kube-apiserver"},
+		Spec: v1.PodSpec{Volumes: []v1.Volume{{
+			Name: "optional",
+			VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+				SecretName: "missing",
+				Optional:   boolPtr(true),
+			}},
+		}}},
+	}
+	projections := fixUpVolumes(pod)
+	p := newPlan([]v1.Pod{*pod}, projections, nil)
+	if problems := p.validateHostPaths(); len(problems) != 0 {
+		t.Errorf("validateHostPaths() = %v, want: []", problems)
+	}
+}
+
+func TestValidateImageReference(t *testing.T) {
+	for _, tc := range []struct {
+		image string
+		valid bool
+	}{
+		{"busybox", true},
+		{"k8s.gcr.io/hyperkube:v1.14.0", true},
+		{"localhost:5000/coreos/bootkube:v0.14.0", true},
+		{"quay.io/coreos/etcd@sha256:" + strings.Repeat("a", 64), true},
+		{"quay.io/coreos/etcd:v3.3.12@sha256:" + strings.Repeat("a", 64), true},
+		{"", false},
+		{"Busybox", false},
+		{"busybox:", false},
+		{"busybox:v1 ", false},
+		{"quay.io/coreos/etcd@sha256:abc", false},
+		{strings.Repeat("a", 256), false},
+	} {
+		if err := validateImageReference(tc.image); (err == nil) != tc.valid {
+			t.Errorf("validateImageReference(%q) = %v, want valid: %v", tc.image, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package recovery
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset"
+	"github.com/kubernetes-sigs/bootkube/pkg/projection"
+	v1 "k8s.io/api/core/v1"
+)
+
+// volumeProjection describes the files that must be rendered for a single source of a bootstrap
+// pod volume once that volume has been converted to a hostPath. Plain secret and configMap volumes
+// are treated as projected volumes with a single source.
+type volumeProjection struct {
+	// dir is the asset path of the directory that the files are written to.
+	dir string
+	// source is the secret, configMap, downwardAPI or serviceAccountToken source.
+	source v1.VolumeProjection
+	// defaultMode is the mode of files that do not specify their own mode. Assets get the default
+	// asset mode if nil.
+	defaultMode *int32
+	// pod is the bootstrap pod that the volume belongs to.
+	pod *v1.Pod
+}
+
+// fixUpVolumes converts the secret, configMap and projected volumes of a bootstrap pod to hostPath
+// volumes that point into asset.BootstrapSecretsDir. It returns the projections that must be
+// rendered for these hostPaths to be populated.
+//
+// Secret and configMap volumes without items mappings share a directory per object, as they always
+// contain the same files. Everything else gets a directory per pod and volume. Pods outside of
+// kube-system get these directories below a directory for their namespace. Plain downwardAPI
+// volumes are left alone since the kubelet supports them for static pods.
+func fixUpVolumes(pod *v1.Pod) []volumeProjection {
+	var projections []volumeProjection
+	nsDir := namespaceDir(pod.Namespace)
+	for i := range pod.Spec.Volumes {
+		vol := &pod.Spec.Volumes[i]
+		var pathSuffix string
+		switch {
+		case vol.Secret != nil:
+			pathSuffix = filepath.Join(nsDir, "secrets", vol.Secret.SecretName)
+			if len(vol.Secret.Items) > 0 {
+				pathSuffix = filepath.Join(nsDir, "volumes", pod.Name, vol.Name)
+			}
+			projections = append(projections, volumeProjection{
+				dir: filepath.Join(asset.AssetPathSecrets, pathSuffix),
+				source: v1.VolumeProjection{Secret: &v1.SecretProjection{
+					LocalObjectReference: v1.LocalObjectReference{Name: vol.Secret.SecretName},
+					Items:                vol.Secret.Items,
+					Optional:             vol.Secret.Optional,
+				}},
+				defaultMode: vol.Secret.DefaultMode,
+				pod:         pod,
+			})
+			vol.Secret = nil
+		case vol.ConfigMap != nil:
+			pathSuffix = filepath.Join(nsDir, "config-maps", vol.ConfigMap.Name)
+			if len(vol.ConfigMap.Items) > 0 {
+				pathSuffix = filepath.Join(nsDir, "volumes", pod.Name, vol.Name)
+			}
+			projections = append(projections, volumeProjection{
+				dir: filepath.Join(asset.AssetPathSecrets, pathSuffix),
+				source: v1.VolumeProjection{ConfigMap: &v1.ConfigMapProjection{
+					LocalObjectReference: vol.ConfigMap.LocalObjectReference,
+					Items:                vol.ConfigMap.Items,
+					Optional:             vol.ConfigMap.Optional,
+				}},
+				defaultMode: vol.ConfigMap.DefaultMode,
+				pod:         pod,
+			})
+			vol.ConfigMap = nil
+		case vol.Projected != nil:
+			pathSuffix = filepath.Join(nsDir, "volumes", pod.Name, vol.Name)
+			for _, source := range vol.Projected.Sources {
+				projections = append(projections, volumeProjection{
+					dir:         filepath.Join(asset.AssetPathSecrets, pathSuffix),
+					source:      source,
+					defaultMode: vol.Projected.DefaultMode,
+					pod:         pod,
+				})
+			}
+			vol.Projected = nil
+		default:
+			continue
+		}
+		vol.HostPath = &v1.HostPathVolumeSource{Path: path.Join(asset.BootstrapSecretsDir, pathSuffix)}
+	}
+	return projections
+}
+
+// outputVolumeProjections renders the files for all projections using the secrets and configMaps
+// in the namespaces of their pods. It returns an error if a required secret, configMap or key is
+// missing. node is the node that the pods are rendered for, or nil if it is not known.
+func (cp *controlPlane) outputVolumeProjections(projections []volumeProjection, node *v1.Node) (asset.Assets, error) {
+	secrets := make(map[string]*v1.Secret)
+	for i := range cp.secrets.Items {
+		s := &cp.secrets.Items[i]
+		secrets[objectName(s.Namespace, s.Name)] = s
+	}
+	configMaps := make(map[string]*v1.ConfigMap)
+	for i := range cp.configMaps.Items {
+		c := &cp.configMaps.Items[i]
+		configMaps[objectName(c.Namespace, c.Name)] = c
+	}
+
+	var as asset.Assets
+	seen := make(map[string]bool)
+	missing := make(map[string]bool)
+	for _, p := range projections {
+		var files asset.Assets
+		var err error
+		switch {
+		case p.source.Secret != nil:
+			s := p.source.Secret
+			secret, ok := secrets[objectName(p.pod.Namespace, s.Name)]
+			if !ok {
+				if !projection.IsOptional(s.Optional) {
+					missing["secret/"+objectName(p.pod.Namespace, s.Name)] = true
+				}
+				continue
+			}
+			files, err = projectKeys(p.dir, secret.Data, s.Items, p.defaultMode, projection.IsOptional(s.Optional))
+		case p.source.ConfigMap != nil:
+			c := p.source.ConfigMap
+			configMap, ok := configMaps[objectName(p.pod.Namespace, c.Name)]
+			if !ok {
+				if !projection.IsOptional(c.Optional) {
+					missing["configmap/"+objectName(p.pod.Namespace, c.Name)] = true
+				}
+				continue
+			}
+			files, err = projectKeys(p.dir, projection.ConfigMapData(configMap), c.Items, p.defaultMode, projection.IsOptional(c.Optional))
+		case p.source.DownwardAPI != nil:
+			files, err = projectDownwardAPI(p.dir, p.pod, p.source.DownwardAPI.Items, p.defaultMode, node)
+		case p.source.ServiceAccountToken != nil:
+			files, err = projectServiceAccountToken(p.dir, p.pod, p.source.ServiceAccountToken, p.defaultMode, cp.secrets.Items)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to render volume files for pod %s: %v", p.pod.Name, err)
+		}
+		for _, f := range files {
+			// Shared directories are referenced by several pods; only render them once.
+			if seen[f.Name] {
+				continue
+			}
+			seen[f.Name] = true
+			as = append(as, f)
+		}
+	}
+	if len(missing) > 0 {
+		var missingObjs []string
+		for obj := range missing {
+			missingObjs = append(missingObjs, obj)
+		}
+		sort.Strings(missingObjs)
+		return nil, fmt.Errorf("failed to extract some required objects: %v", missingObjs)
+	}
+	return as, nil
+}
+
+// projectKeys creates assets for key-value data (such as from a Secret or ConfigMap) in dir. If
+// items is set only the listed keys are written, to their mapped paths.
+func projectKeys(dir string, data map[string][]byte, items []v1.KeyToPath, defaultMode *int32, optional bool) (asset.Assets, error) {
+	files, err := projection.Keys(data, items, defaultMode, optional)
+	if err != nil {
+		return nil, err
+	}
+	return fileAssets(dir, files), nil
+}
+
+// projectDownwardAPI creates assets for downward API files of a bootstrap pod in dir. Only the
+// pod name, namespace, labels and annotations as well as container resources are known at recovery
+// time. Limits that are not set are the allocatable resources of node, so they require it. Note that
+// the kubelet appends the node name to the names of static pods.
+func projectDownwardAPI(dir string, pod *v1.Pod, items []v1.DownwardAPIVolumeFile, defaultMode *int32, node *v1.Node) (asset.Assets, error) {
+	var allocatable projection.AllocatableFunc
+	if node != nil {
+		allocatable = func() (v1.ResourceList, error) { return node.Status.Allocatable, nil }
+	}
+	files, err := projection.DownwardAPI(pod, items, defaultMode, allocatable)
+	if err != nil {
+		return nil, err
+	}
+	return fileAssets(dir, files), nil
+}
+
+// fileAssets returns assets for the files of a volume in dir, ordered by name.
+func fileAssets(dir string, files map[string]projection.File) asset.Assets {
+	var as asset.Assets
+	for p, f := range files {
+		as = append(as, asset.Asset{Name: path.Join(dir, p), Data: f.Data, Mode: f.Mode})
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].Name < as[j].Name })
+	return as
+}
+
+// projectServiceAccountToken creates an asset for a projected service account token. Bound tokens
+// cannot be minted without an apiserver, so the legacy token secret of the pod's service account is
+// used instead.
+func projectServiceAccountToken(dir string, pod *v1.Pod, source *v1.ServiceAccountTokenProjection, defaultMode *int32, secrets []v1.Secret) (asset.Assets, error) {
+	serviceAccount := pod.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	for _, secret := range secrets {
+		if secret.Type != v1.SecretTypeServiceAccountToken || secret.Namespace != pod.Namespace || secret.Annotations[v1.ServiceAccountNameKey] != serviceAccount {
+			continue
+		}
+		token, ok := secret.Data[v1.ServiceAccountTokenKey]
+		if !ok {
+			continue
+		}
+		return asset.Assets{{
+			Name: path.Join(dir, source.Path),
+			Data: token,
+			Mode: projection.FileMode(nil, defaultMode),
+		}}, nil
+	}
+	return nil, fmt.Errorf("no token secret found for service account %s/%s", pod.Namespace, serviceAccount)
+}
@@ -0,0 +1,262 @@
+package recovery
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-sigs/bootkube/cmd/render/plugin/default/asset"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFixUpVolumesProjected(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-kube-apiserver"},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{{
+				Name: "mapped",
+				VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+					SecretName:  "kube-apiserver",
+					Items:       []v1.KeyToPath{{Key: "apiserver.crt", Path: "tls/server.crt"}},
+					DefaultMode: int32Ptr(0400),
+				}},
+			}, {
+				Name: "token",
+				VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{
+					Sources: []v1.VolumeProjection{{
+						ServiceAccountToken: &v1.ServiceAccountTokenProjection{Path: "token"},
+					}, {
+						ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: "kube-root-ca.crt"}},
+					}},
+					DefaultMode: int32Ptr(0644),
+				}},
+			}, {
+				Name: "podinfo",
+				VolumeSource: v1.VolumeSource{DownwardAPI: &v1.DownwardAPIVolumeSource{
+					Items: []v1.DownwardAPIVolumeFile{{Path: "name", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+				}},
+			}},
+		},
+	}
+	wantVolumes := []v1.Volume{{
+		Name:         "mapped",
+		VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/etc/kubernetes/bootstrap-secrets/volumes/bootstrap-kube-apiserver/mapped"}},
+	}, {
+		Name:         "token",
+		VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/etc/kubernetes/bootstrap-secrets/volumes/bootstrap-kube-apiserver/token"}},
+	}, {
+		// Plain downwardAPI volumes are supported by the kubelet for static pods.
+		Name: "podinfo",
+		VolumeSource: v1.VolumeSource{DownwardAPI: &v1.DownwardAPIVolumeSource{
+			Items: []v1.DownwardAPIVolumeFile{{Path: "name", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+		}},
+	}}
+	wantDirs := []string{
+		"tls/volumes/bootstrap-kube-apiserver/mapped",
+		"tls/volumes/bootstrap-kube-apiserver/token",
+		"tls/volumes/bootstrap-kube-apiserver/token",
+	}
+
+	projections := fixUpVolumes(pod)
+	var gotDirs []string
+	for _, p := range projections {
+		gotDirs = append(gotDirs, p.dir)
+	}
+	if !reflect.DeepEqual(pod.Spec.Volumes, wantVolumes) {
+		t.Errorf("fixUpVolumes() volumes = %v, want: %v", pod.Spec.Volumes, wantVolumes)
+	}
+	if !reflect.DeepEqual(gotDirs, wantDirs) {
+		t.Errorf("fixUpVolumes() dirs = %v, want: %v", gotDirs, wantDirs)
+	}
+}
+
+func TestProjectKeys(t *testing.T) {
+	data := map[string][]byte{"a": []byte("A"), "b": []byte("B")}
+	for _, tc := range []struct {
+		desc     string
+		items    []v1.KeyToPath
+		optional bool
+		want     asset.Assets
+		wantErr  bool
+	}{{
+		desc: "all keys",
+		want: asset.Assets{{Name: "dir/a", Data: []byte("A"), Mode: 0440}, {Name: "dir/b", Data: []byte("B"), Mode: 0440}},
+	}, {
+		desc:  "mapped keys with modes",
+		items: []v1.KeyToPath{{Key: "b", Path: "sub/b.txt", Mode: int32Ptr(0755)}, {Key: "a", Path: "a.txt"}},
+		want:  asset.Assets{{Name: "dir/a.txt", Data: []byte("A"), Mode: 0440}, {Name: "dir/sub/b.txt", Data: []byte("B"), Mode: 0755}},
+	}, {
+		desc:    "missing key",
+		items:   []v1.KeyToPath{{Key: "c", Path: "c"}},
+		wantErr: true,
+	}, {
+		desc:     "missing optional key",
+		items:    []v1.KeyToPath{{Key: "c", Path: "c"}},
+		optional: true,
+	}} {
+		got, err := projectKeys("dir", data, tc.items, int32Ptr(0440), tc.optional)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: projectKeys() = %v, want error: %t", tc.desc, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: projectKeys() = %v, want: %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestProjectDownwardAPI(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "bootstrap-kube-apiserver",
+			Namespace:   "kube-system",
+			Annotations: map[string]string{"b": "2", "a": "1"},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name: "kube-apiserver",
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m")},
+					Limits:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+				},
+			}},
+		},
+	}
+	items := []v1.DownwardAPIVolumeFile{{
+		Path:     "name",
+		FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"},
+	}, {
+		Path:     "annotations",
+		FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.annotations"},
+	}, {
+		Path:             "cpu",
+		ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "requests.cpu"},
+	}, {
+		Path:             "memory",
+		ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "limits.memory", Divisor: resource.MustParse("1Mi")},
+		Mode:             int32Ptr(0600),
+	}}
+	want := asset.Assets{
+		{Name: "dir/annotations", Data: []byte("a=\"1\"\nb=\"2\""), Mode: 0644},
+		{Name: "dir/cpu", Data: []byte("1"), Mode: 0644},
+		{Name: "dir/memory", Data: []byte("1024"), Mode: 0600},
+		{Name: "dir/name", Data: []byte("bootstrap-kube-apiserver"), Mode: 0644},
+	}
+	if got, err := projectDownwardAPI("dir", pod, items, int32Ptr(0644), nil); err != nil {
+		t.Errorf("projectDownwardAPI() = %v, want: nil", err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("projectDownwardAPI() = %v, want: %v", got, want)
+	}
+
+	unsupported := []v1.DownwardAPIVolumeFile{{Path: "uid", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.uid"}}}
+	if got, err := projectDownwardAPI("dir", pod, unsupported, nil, nil); err == nil {
+		t.Errorf("projectDownwardAPI(%v) = %v, nil, want: non-nil", unsupported, got)
+	}
+
+	// Limits that are not set are the allocatable resources of the node.
+	unset := []v1.DownwardAPIVolumeFile{{
+		Path:             "cpu",
+		ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "kube-apiserver", Resource: "limits.cpu"},
+	}}
+	if got, err := projectDownwardAPI("dir", pod, unset, nil, nil); err == nil {
+		t.Errorf("projectDownwardAPI(%v) without node = %v, nil, want: non-nil", unset, got)
+	}
+	node := &v1.Node{Status: v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}}}
+	want = asset.Assets{{Name: "dir/cpu", Data: []byte("4")}}
+	if got, err := projectDownwardAPI("dir", pod, unset, nil, node); err != nil {
+		t.Errorf("projectDownwardAPI(%v) = %v, want: nil", unset, err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("projectDownwardAPI(%v) = %v, want: %v", unset, got, want)
+	}
+}
+
+func TestProjectServiceAccountToken(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system"},
+		Spec:       v1.PodSpec{ServiceAccountName: "kube-apiserver"},
+	}
+	secrets := []v1.Secret{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "other-token-abcde",
+			Namespace:   "kube-system",
+			Annotations: map[string]string{v1.ServiceAccountNameKey: "other"},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+		Data: map[string][]byte{v1.ServiceAccountTokenKey: []byte("wrong")},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "kube-apiserver-token-abcde",
+			Namespace:   "kube-system",
+			Annotations: map[string]string{v1.ServiceAccountNameKey: "kube-apiserver"},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+		Data: map[string][]byte{v1.ServiceAccountTokenKey: []byte("token")},
+	}}
+	source := &v1.ServiceAccountTokenProjection{Path: "sa/token"}
+	want := asset.Assets{{Name: "dir/sa/token", Data: []byte("token"), Mode: 0644}}
+	if got, err := projectServiceAccountToken("dir", pod, source, int32Ptr(0644), secrets); err != nil {
+		t.Errorf("projectServiceAccountToken() = %v, want: nil", err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("projectServiceAccountToken() = %v, want: %v", got, want)
+	}
+
+	pod.Spec.ServiceAccountName = "missing"
+	if got, err := projectServiceAccountToken("dir", pod, source, nil, secrets); err == nil {
+		t.Errorf("projectServiceAccountToken() = %v, nil, want: non-nil", got)
+	}
+}
+
+func TestOutputVolumeProjectionsOptional(t *testing.T) {
+	projections := []volumeProjection{{
+		dir: "dir",
+		source: v1.VolumeProjection{ConfigMap: &v1.ConfigMapProjection{
+			LocalObjectReference: v1.LocalObjectReference{Name: "missing"},
+			Optional:             boolPtr(true),
+		}},
+		pod: &v1.Pod{},
+	}}
+	if got, err := cp.outputVolumeProjections(projections, nil); err != nil || len(got) != 0 {
+		t.Errorf("outputVolumeProjections(%v) = %v, %v, want: [], nil", projections, got, err)
+	}
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestVolumesNamespaced(t *testing.T) {
+	cp := &controlPlane{secrets: v1.SecretList{Items: []v1.Secret{{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "kube-system"},
+		Data:       map[string][]byte{"key": []byte("kube-system")},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "control-plane"},
+		Data:       map[string][]byte{"key": []byte("control-plane")},
+	}}}}
+	newPod := func(namespace string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-kube-apiserver", Namespace: namespace},
+			Spec: v1.PodSpec{Volumes: []v1.Volume{{
+				Name:         "secrets",
+				VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "kube-apiserver"}},
+			}}},
+		}
+	}
+
+	pod := newPod("control-plane")
+	projections := fixUpVolumes(pod)
+	wantPath := "/etc/kubernetes/bootstrap-secrets/namespaces/control-plane/secrets/kube-apiserver"
+	if got := pod.Spec.Volumes[0].HostPath; got == nil || got.Path != wantPath {
+		t.Errorf("fixUpVolumes() hostPath = %v, want: %s", got, wantPath)
+	}
+	want := asset.Assets{{Name: "tls/namespaces/control-plane/secrets/kube-apiserver/key", Data: []byte("control-plane")}}
+	if got, err := cp.outputVolumeProjections(projections, nil); err != nil {
+		t.Errorf("outputVolumeProjections(%v) = %v, want: nil", projections, err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("outputVolumeProjections(%v) = %v, want: %v", projections, got, want)
+	}
+
+	projections = fixUpVolumes(newPod("other"))
+	if _, err := cp.outputVolumeProjections(projections, nil); err == nil || !strings.Contains(err.Error(), "secret/other/kube-apiserver") {
+		t.Errorf("outputVolumeProjections(%v) = %v, want: secret/other/kube-apiserver missing", projections, err)
+	}
+}